@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+const bashPolicyConfigPath = ".ikm/bash.json"
+
+type bashPolicyConfig struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+func loadBashPolicyConfig() (bashPolicyConfig, error) {
+	var cfg bashPolicyConfig
+	data, err := os.ReadFile(bashPolicyConfigPath)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("error reading bash policy config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing bash policy config: %w", err)
+	}
+	for _, pattern := range cfg.Allow {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid allow pattern %q: %w", pattern, err)
+		}
+		cfg.allow = append(cfg.allow, re)
+	}
+	for _, pattern := range cfg.Deny {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid deny pattern %q: %w", pattern, err)
+		}
+		cfg.deny = append(cfg.deny, re)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/markusylisiurunen/ikm/internal/tui"
+)
+
+func readBatchPrompts(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading batch file: %w", err)
+	}
+	content := strings.TrimSpace(string(data))
+	var prompts []string
+	if strings.HasPrefix(content, "[") {
+		// a JSON array of prompts allows multi-line prompts
+		if err := json.Unmarshal([]byte(content), &prompts); err != nil {
+			return nil, fmt.Errorf("error parsing batch file as a JSON array of strings: %w", err)
+		}
+	} else {
+		prompts = strings.Split(content, "\n")
+	}
+	var result []string
+	for _, prompt := range prompts {
+		if prompt = strings.TrimSpace(prompt); prompt != "" {
+			result = append(result, prompt)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("batch file does not contain any prompts")
+	}
+	return result, nil
+}
+
+func runBatch(model tui.Model, cfg config) {
+	prompts, err := readBatchPrompts(cfg.batch)
+	if err != nil {
+		log.Fatalf("error reading batch prompts: %v", err)
+	}
+	outDir := cfg.batchOut
+	if outDir == "" {
+		outDir = filepath.Join(".ikm/batch", time.Now().Format("2006-01-02T15:04:05"))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := model.RunBatch(ctx, prompts, outDir, cfg.batchMaxCost)
+	if err != nil {
+		log.Fatalf("error running batch: %v", err)
+	}
+	fmt.Printf("batch finished: %d prompts, %d completed, %d failed, %d skipped\n",
+		report.Prompts, report.Completed, report.Failed, report.Skipped)
+	fmt.Printf("usage: %d prompt tokens, %d completion tokens, total cost %.4f €\n",
+		report.Usage.PromptTokens, report.Usage.CompletionTokens, report.Usage.TotalCost)
+	fmt.Printf("results written to %s\n", outDir)
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
@@ -14,47 +16,61 @@ import (
 
 var bashDockerImageTag string
 
-func buildBashDockerIfNeeded() error {
+func isDockerInstalled() bool {
+	_, err := exec.LookPath("docker")
+	return err == nil
+}
+
+func buildBashDockerIfNeeded(ctx context.Context, sandbox sandboxConfig, rebuild bool) error {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current working directory: %s", err.Error())
 	}
-	baseImage := "ubuntu:noble"
+	// the image tag is a hash of the base image and the commands, a changed sandbox config builds a new image
+	baseImage := sandbox.image()
+	packages := append([]string{"git", "tree", "ripgrep", "curl"}, sandbox.Packages...)
 	cmdsToExecute := []string{
 		"apt-get update",
-		"apt-get install -y git tree ripgrep curl",
+		"apt-get install -y " + strings.Join(packages, " "),
 		"curl -sSL https://go.dev/dl/go1.24.4.linux-amd64.tar.gz | tar -C /usr/local -xzf -",
 		"echo 'export PATH=$PATH:/usr/local/go/bin' > /etc/profile.d/go.sh",
 		"chmod +x /etc/profile.d/go.sh && source /etc/profile.d/go.sh",
 		"go mod tidy",
 	}
-	cmdHash := fnv.New64a()
-	for _, cmd := range cmdsToExecute {
-		if _, err := cmdHash.Write([]byte(cmd)); err != nil {
-			return fmt.Errorf("error writing command to hash: %w", err)
-		}
-	}
-	bashDockerImageTag = "ikm-bash:" + fmt.Sprintf("%x", cmdHash.Sum64())
-	cmd := exec.Command("docker", "images", "-q", bashDockerImageTag)
-	out, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("error checking for existing image: %w", err)
+	bashDockerImageTag = getBashDockerImageTag(baseImage, cmdsToExecute)
+	// make sure the daemon is reachable before trying to inspect or build anything
+	var stderr bytes.Buffer
+	infoCmd := exec.CommandContext(ctx, "docker", "info", "--format", "{{.ServerVersion}}")
+	infoCmd.Stderr = &stderr
+	if err := infoCmd.Run(); err != nil {
+		return fmt.Errorf("docker daemon is not running or not reachable: %s", strings.TrimSpace(stderr.String()))
 	}
-	if len(out) > 0 {
-		return nil
+	if !rebuild {
+		cmd := exec.Command("docker", "images", "-q", bashDockerImageTag)
+		out, err := cmd.Output()
+		if err != nil {
+			return fmt.Errorf("error checking for existing image: %w", err)
+		}
+		if len(out) > 0 {
+			return nil
+		}
+		fmt.Printf("docker image %s not found, building\n", bashDockerImageTag)
+	} else {
+		fmt.Printf("rebuilding docker image %s\n", bashDockerImageTag)
 	}
-	fmt.Printf("docker image %s not found, building\n", bashDockerImageTag)
 	tempContainerName := "ikm-" + fmt.Sprintf("%x", time.Now().Unix())
+	// the temp container is removed whether the build succeeds, fails or is aborted mid-way
+	defer exec.Command("docker", "rm", "-f", tempContainerName).Run() //nolint:errcheck
 	dockerCmds := [][]string{
 		{"docker", "pull", baseImage},
 		{"docker", "run", "-v", fmt.Sprintf(".:%s", cwd), "-w", cwd, "--name", tempContainerName, baseImage, "/bin/bash", "-c", strings.Join(cmdsToExecute, " && ")},
 		{"docker", "commit", tempContainerName, bashDockerImageTag},
-		{"docker", "rm", tempContainerName},
 	}
-	for _, cmd := range dockerCmds {
-		out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
-		if err != nil {
-			fmt.Println(string(out))
+	for idx, cmd := range dockerCmds {
+		if err := runDockerBuildStep(ctx, idx+1, len(dockerCmds), cmd); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("docker image build aborted: %w", ctx.Err())
+			}
 			return fmt.Errorf("error running docker command %v: %w", cmd, err)
 		}
 	}
@@ -62,17 +78,91 @@ func buildBashDockerIfNeeded() error {
 	return nil
 }
 
-func runInBashDocker(ctx context.Context, cmd string) (int, string, string, error) {
+func getBashDockerImageTag(baseImage string, cmds []string) string {
+	// every part is terminated so that moving text between adjacent commands changes the hash
+	hash := fnv.New64a()
+	for _, part := range append([]string{baseImage}, cmds...) {
+		hash.Write([]byte(part)) //nolint:errcheck
+		hash.Write([]byte{0})    //nolint:errcheck
+	}
+	return "ikm-bash:" + fmt.Sprintf("%x", hash.Sum64())
+}
+
+func runDockerBuildStep(ctx context.Context, step, steps int, args []string) error {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting command: %w", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+		pw.Close() //nolint:errcheck
+	}()
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(pr)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		// keep draining so that the command never blocks on a full pipe
+		io.Copy(io.Discard, pr) //nolint:errcheck
+	}()
+	// stream the output above a spinner line until the command exits
+	frames := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	started := time.Now()
+	for frame := 0; ; frame++ {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				fmt.Print("\r\033[K")
+				return <-done
+			}
+			fmt.Printf("\r\033[K  %s\n", line)
+		case <-ticker.C:
+		}
+		fmt.Printf("\r\033[K%s [%d/%d] %s %s (%s)",
+			frames[frame%len(frames)], step, steps, args[0], args[1], time.Since(started).Round(time.Second))
+	}
+}
+
+func newBashDockerRunner(sandbox sandboxConfig) func(context.Context, string) (int, string, string, error) {
+	return func(ctx context.Context, cmd string) (int, string, string, error) {
+		return runInBashDocker(ctx, sandbox, cmd)
+	}
+}
+
+func getBashDockerArgs(cwd, containerName string, sandbox sandboxConfig, cmd string) []string {
+	args := []string{"run", "--rm",
+		"--name", containerName,
+		"-v", fmt.Sprintf(".:%s:ro", cwd),
+	}
+	// the writable directory is mounted on top of the read-only working directory
+	if sandbox.Writable != "" {
+		args = append(args, "-v", fmt.Sprintf("./%s:%s/%s", sandbox.Writable, cwd, sandbox.Writable))
+	}
+	args = append(args, "-w", cwd)
+	if !sandbox.allowsNetwork(cmd) {
+		args = append(args, "--network", "none")
+	}
+	return append(args, bashDockerImageTag, "bash", "-l", "-c", cmd)
+}
+
+func runInBashDocker(ctx context.Context, sandbox sandboxConfig, cmd string) (int, string, string, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return 0, "", "", fmt.Errorf("failed to get current working directory: %s", err.Error())
 	}
-	dockerCmd := exec.CommandContext(ctx, "docker", "run", "--rm",
-		"-v", fmt.Sprintf(".:%s:ro", cwd),
-		"-w", cwd,
-		"--network", "none",
-		bashDockerImageTag,
-		"bash", "-l", "-c", cmd)
+	// the container is named so that it can be removed if the command is cancelled or times out,
+	// killing the docker client alone leaves the container running
+	containerName := fmt.Sprintf("ikm-bash-%d", time.Now().UnixNano())
+	dockerCmd := exec.CommandContext(ctx, "docker", getBashDockerArgs(cwd, containerName, sandbox, cmd)...)
 	var stdoutBuf, stderrBuf bytes.Buffer
 	dockerCmd.Stdout = &stdoutBuf
 	dockerCmd.Stderr = &stderrBuf
@@ -80,6 +170,10 @@ func runInBashDocker(ctx context.Context, cmd string) (int, string, string, erro
 		return 0, "", "", fmt.Errorf("error executing command: %w", err)
 	}
 	err = dockerCmd.Wait()
+	if ctx.Err() != nil {
+		_ = exec.Command("docker", "rm", "-f", containerName).Run()
+		return 0, stdoutBuf.String(), stderrBuf.String(), fmt.Errorf("command aborted: %w", ctx.Err())
+	}
 	var exitErr *exec.ExitError
 	if errors.As(err, &exitErr) {
 		return exitErr.ExitCode(), stdoutBuf.String(), stderrBuf.String(), nil
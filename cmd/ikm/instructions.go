@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"unicode/utf8"
+)
+
+const (
+	instructionsPath     = ".ikm/instructions.md"
+	instructionsMaxBytes = 32 * 1024
+)
+
+func readCustomInstructions(cwd string) (string, error) {
+	// instructions are layered from the repository root down to the working directory, the nearest come last so
+	// that they take precedence over the more general ones
+	dirs := instructionsDirs(cwd)
+	var (
+		contents [][]byte
+		total    int
+	)
+	for _, dir := range dirs {
+		path := filepath.Join(dir, instructionsPath)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading instructions file at %s: %w", path, err)
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 || slices.ContainsFunc(contents, func(c []byte) bool { return bytes.Equal(c, data) }) {
+			continue
+		}
+		contents = append(contents, data)
+		total += len(data)
+	}
+	// the most general instructions are dropped first when the combined size is over the limit
+	for len(contents) > 1 && total > instructionsMaxBytes {
+		total -= len(contents[0])
+		contents = contents[1:]
+	}
+	if len(contents) == 1 && total > instructionsMaxBytes {
+		end := instructionsMaxBytes
+		for end > 0 && !utf8.RuneStart(contents[0][end]) {
+			end--
+		}
+		contents[0] = contents[0][:end]
+	}
+	return string(bytes.Join(contents, []byte("\n\n"))), nil
+}
+
+func instructionsDirs(cwd string) []string {
+	// outside of a repository only the working directory is considered
+	dirs := []string{cwd}
+	for dir := cwd; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			slices.Reverse(dirs)
+			return dirs
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return []string{cwd}
+		}
+		dir = parent
+		dirs = append(dirs, dir)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// only these variables are passed to commands run without the sandbox, API keys and other secrets stay out
+var bashLocalEnvKeys = []string{"PATH", "HOME", "USER", "LANG", "LC_ALL", "TERM", "TMPDIR", "GOPATH", "GOCACHE"}
+
+func runInBashLocal(ctx context.Context, cmd string) (int, string, string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return 0, "", "", fmt.Errorf("failed to get current working directory: %s", err.Error())
+	}
+	localCmd := exec.CommandContext(ctx, "bash", "-c", cmd)
+	localCmd.Dir = cwd
+	localCmd.Env = []string{}
+	for _, key := range bashLocalEnvKeys {
+		if value, ok := os.LookupEnv(key); ok {
+			localCmd.Env = append(localCmd.Env, key+"="+value)
+		}
+	}
+	// the command runs in its own process group so that cancelling kills its children too, not just bash
+	localCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	localCmd.Cancel = func() error {
+		return syscall.Kill(-localCmd.Process.Pid, syscall.SIGKILL)
+	}
+	// background processes may keep the output pipes open after bash is killed, so waiting is bounded
+	localCmd.WaitDelay = 2 * time.Second
+	var stdoutBuf, stderrBuf bytes.Buffer
+	localCmd.Stdout = &stdoutBuf
+	localCmd.Stderr = &stderrBuf
+	if err := localCmd.Start(); err != nil {
+		return 0, "", "", fmt.Errorf("error executing command: %w", err)
+	}
+	err = localCmd.Wait()
+	if ctx.Err() != nil {
+		return 0, stdoutBuf.String(), stderrBuf.String(), fmt.Errorf("command aborted: %w", ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), stdoutBuf.String(), stderrBuf.String(), nil
+	}
+	if err != nil {
+		return 0, "", "", fmt.Errorf("error executing command: %w", err)
+	}
+	return localCmd.ProcessState.ExitCode(), stdoutBuf.String(), stderrBuf.String(), nil
+}
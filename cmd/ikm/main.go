@@ -1,17 +1,24 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"regexp"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/markusylisiurunen/ikm/internal/agent"
 	"github.com/markusylisiurunen/ikm/internal/logger"
 	"github.com/markusylisiurunen/ikm/internal/tui"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
 )
 
 //go:embed prompts/agent.txt
@@ -35,11 +42,10 @@ func readSystemPromptWithCustomInstructions(systemPromptTemplate string) string
 	if err != nil {
 		log.Fatalf("failed to get current working directory: %v", err)
 	}
-	customInstructions, err := os.ReadFile(".ikm/instructions.md")
-	if err != nil && !os.IsNotExist(err) {
-		log.Fatalf("failed to read instructions file at %s: %v", ".ikm/instructions.md", err)
+	customInstructionsContent, err := readCustomInstructions(cwd)
+	if err != nil {
+		log.Fatalf("failed to read custom instructions: %v", err)
 	}
-	customInstructionsContent := string(bytes.TrimSpace(customInstructions))
 	if customInstructionsContent == "" {
 		customInstructionsContent = "No custom instructions provided."
 	}
@@ -47,33 +53,77 @@ func readSystemPromptWithCustomInstructions(systemPromptTemplate string) string
 		"cwd":          cwd,
 		"instructions": customInstructionsContent,
 	}
+	// the tree listing is only built when the template asks for it
+	if regexp.MustCompile(`{{\s?tree\s?}}`).MatchString(systemPromptTemplate) {
+		vars["tree"] = getDirectoryTree()
+	}
 	return injectVariablesToPrompt(systemPromptTemplate, vars)
 }
 
 type config struct {
-	debug           bool
-	disabledTools   []string
-	reasoningEffort uint8
-	mode            string
-	model           string
-	anthropicKey    string
-	openRouterKey   string
-	openAIKey       string
+	debug                bool
+	debugMaxBytes        int64
+	printConfig          bool
+	disabledTools        []string
+	logsTool             bool
+	bashTimeout          time.Duration
+	bashRunner           string
+	rebuildBash          bool
+	reasoningEffort      uint8
+	mode                 string
+	model                string
+	summarizeToolResults bool
+	compactionThreshold  int
+	maxTurns             int
+	costBudget           float64
+	yolo                 bool
+	prompt               string
+	batch                string
+	batchOut             string
+	batchMaxCost         float64
+	anthropicKey         string
+	openRouterKey        string
+	openAIKey            string
+	mistralKey           string
+	geminiKey            string
+	ollamaBaseURL        string
+	openAISummary        string
+	anthropicBaseURL     string
+	openAIBaseURL        string
 }
 
 func (c *config) read() {
 	var (
 		debug       = flag.Bool("debug", false, "enable debug logging")
+		debugMaxMB  = flag.Int64("debug-max-mb", 50, "rotate the debug log file once it exceeds this many megabytes (0 disables)")
 		mode        = flag.String("mode", "raw", "mode to use (agent, dev, raw)")
 		model       = flag.String("model", "claude-sonnet-4", "model to use")
 		reasoning   = flag.String("reasoning", "2", "reasoning effort level (0, 1, 2, 3)")
+		summarize   = flag.Bool("summarize-tool-results", false, "send summaries of already seen tool results instead of the full text")
+		prompt      = flag.String("prompt", "", "send a single prompt without the terminal UI and print the answer (- reads stdin)")
+		batch       = flag.String("batch", "", "run the prompts in this file (one per line or a JSON array) without the terminal UI")
+		batchOut    = flag.String("batch-out", "", "output folder for batch results (default .ikm/batch/<timestamp>)")
+		batchCost   = flag.Float64("batch-max-cost", 0, "stop the batch once the total cost in € reaches this limit (0 disables)")
+		maxTurns    = flag.Int("max-turns", 128, "maximum number of tool-call turns the agent may take for a single message")
+		budget      = flag.Float64("budget", 0, "stop the agent once the total cost in € of the whole run, including cleared and loaded conversations, reaches this budget (0 disables)")
+		compactAt   = flag.Int("compaction-threshold", 0, "compact the conversation once the prompt exceeds this many tokens (0 disables)")
+		bashTimeout = flag.Duration("bash-timeout", 120*time.Second, "timeout for a single bash tool command")
+		rebuildBash = flag.Bool("rebuild-bash", false, "rebuild the bash tool Docker image even if it already exists")
+		bashRunner  = flag.String("bash-runner", "docker", "where the bash tool runs commands (docker, local), local runs them unsandboxed on this machine")
+		yolo        = flag.Bool("yolo", false, "run file edits and bash commands without asking for approval")
 		noTools     = flag.Bool("no-tools", false, "disable all tools")
 		noToolBash  = flag.Bool("no-tool-bash", false, "disable the bash tool")
 		noToolFS    = flag.Bool("no-tool-fs", false, "disable the fs tool")
 		noToolLLM   = flag.Bool("no-tool-llm", false, "disable the llm tool")
 		noToolTask  = flag.Bool("no-tool-task", false, "disable the task tool")
 		noToolThink = flag.Bool("no-tool-think", false, "disable the think tool")
+		noToolTest  = flag.Bool("no-tool-test", false, "disable the test tool")
 		noToolTodo  = flag.Bool("no-tool-todo", false, "disable the todo tool")
+		noToolWeb   = flag.Bool("no-tool-web", false, "disable the web fetch tool")
+		noToolMCP   = flag.Bool("no-tool-mcp", false, "disable the tools provided by MCP servers")
+		oaiSummary  = flag.String("openai-reasoning-summary", "", "ask OpenAI models for a readable reasoning summary (auto, concise, detailed), requires a verified organization")
+		printConfig = flag.Bool("print-config", false, "print the resolved configuration and exit")
+		toolLogs    = flag.Bool("tool-logs", false, "enable the logs tool for reading the debug logs in .ikm/logs")
 	)
 	flag.Parse()
 	switch *reasoning {
@@ -88,13 +138,23 @@ func (c *config) read() {
 	default:
 		log.Fatalf("invalid reasoning effort level: %s, must be one of: 0, 1, 2, 3", *reasoning)
 	}
+	if *oaiSummary != "" && *oaiSummary != "auto" && *oaiSummary != "concise" && *oaiSummary != "detailed" {
+		log.Fatalf("invalid OpenAI reasoning summary: %s, must be one of: auto, concise, detailed", *oaiSummary)
+	}
+	if *bashRunner != "docker" && *bashRunner != "local" {
+		log.Fatalf("invalid bash runner: %s, must be one of: docker, local", *bashRunner)
+	}
 	if *noTools {
 		*noToolBash = true
 		*noToolFS = true
 		*noToolLLM = true
 		*noToolTask = true
+		*noToolTest = true
 		*noToolThink = true
 		*noToolTodo = true
+		*noToolWeb = true
+		*noToolMCP = true
+		*toolLogs = false
 	}
 	if *noToolBash {
 		c.disabledTools = append(c.disabledTools, "bash")
@@ -108,23 +168,100 @@ func (c *config) read() {
 	if *noToolTask {
 		c.disabledTools = append(c.disabledTools, "task")
 	}
+	if *noToolTest {
+		c.disabledTools = append(c.disabledTools, "test")
+	}
 	if *noToolThink {
 		c.disabledTools = append(c.disabledTools, "think")
 	}
 	if *noToolTodo {
 		c.disabledTools = append(c.disabledTools, "todo")
 	}
+	if *noToolWeb {
+		c.disabledTools = append(c.disabledTools, "web")
+	}
+	if *noToolMCP {
+		c.disabledTools = append(c.disabledTools, "mcp")
+	}
+	c.logsTool = *toolLogs
+	c.bashTimeout = *bashTimeout
+	c.bashRunner = *bashRunner
+	c.rebuildBash = *rebuildBash
 	c.debug = *debug
+	c.printConfig = *printConfig
+	c.debugMaxBytes = *debugMaxMB * 1024 * 1024
 	c.mode = *mode
 	c.model = *model
+	c.summarizeToolResults = *summarize
+	c.compactionThreshold = *compactAt
+	c.maxTurns = *maxTurns
+	c.costBudget = *budget
+	c.yolo = *yolo
+	c.prompt = *prompt
+	c.batch = *batch
+	c.batchOut = *batchOut
+	c.batchMaxCost = *batchCost
 	c.anthropicKey = os.Getenv("ANTHROPIC_KEY")
 	c.openRouterKey = os.Getenv("OPENROUTER_KEY")
 	c.openAIKey = os.Getenv("OPENAI_KEY")
+	c.mistralKey = os.Getenv("MISTRAL_KEY")
+	c.geminiKey = os.Getenv("GEMINI_KEY")
+	c.ollamaBaseURL = os.Getenv("OLLAMA_BASE_URL")
+	c.openAISummary = *oaiSummary
+	c.anthropicBaseURL = os.Getenv("ANTHROPIC_BASE_URL")
+	c.openAIBaseURL = os.Getenv("OPENAI_BASE_URL")
+}
+
+func (c config) render() string {
+	var tools []string
+	for _, name := range []string{"bash", "fs", "llm", "task", "test", "think", "todo", "web", "mcp"} {
+		if !slices.Contains(c.disabledTools, name) {
+			tools = append(tools, name)
+		}
+	}
+	if c.logsTool {
+		tools = append(tools, "logs")
+	}
+	if len(tools) == 0 {
+		tools = []string{"none"}
+	}
+	// secrets are only reported as set or not, the output ends up in bug reports and terminal scrollback
+	isSet := func(v string) string {
+		if v == "" {
+			return "not set"
+		}
+		return "set"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "model: %s\n", c.model)
+	fmt.Fprintf(&b, "mode: %s\n", c.mode)
+	fmt.Fprintf(&b, "reasoning: %d\n", c.reasoningEffort)
+	fmt.Fprintf(&b, "tools: %s\n", strings.Join(tools, ", "))
+	fmt.Fprintf(&b, "bash runner: %s (timeout %s)\n", c.bashRunner, c.bashTimeout)
+	fmt.Fprintf(&b, "yolo: %t\n", c.yolo)
+	fmt.Fprintf(&b, "max turns: %d\n", c.maxTurns)
+	fmt.Fprintf(&b, "budget: %.2f €\n", c.costBudget)
+	fmt.Fprintf(&b, "compaction threshold: %d\n", c.compactionThreshold)
+	fmt.Fprintf(&b, "debug: %t\n", c.debug)
+	fmt.Fprintf(&b, "ANTHROPIC_KEY: %s\n", isSet(c.anthropicKey))
+	fmt.Fprintf(&b, "OPENROUTER_KEY: %s\n", isSet(c.openRouterKey))
+	fmt.Fprintf(&b, "OPENAI_KEY: %s\n", isSet(c.openAIKey))
+	fmt.Fprintf(&b, "MISTRAL_KEY: %s\n", isSet(c.mistralKey))
+	fmt.Fprintf(&b, "GEMINI_KEY: %s\n", isSet(c.geminiKey))
+	fmt.Fprintf(&b, "OLLAMA_BASE_URL: %s\n", isSet(c.ollamaBaseURL))
+	fmt.Fprintf(&b, "ANTHROPIC_BASE_URL: %s\n", isSet(c.anthropicBaseURL))
+	fmt.Fprintf(&b, "OPENAI_BASE_URL: %s\n", isSet(c.openAIBaseURL))
+	return b.String()
 }
 
 func main() {
 	var cfg config
 	cfg.read()
+	// printed before the API keys are validated, a missing key is one of the things to check with it
+	if cfg.printConfig {
+		fmt.Print(cfg.render())
+		return
+	}
 	// validate API keys
 	if cfg.anthropicKey == "" {
 		log.Fatal("ANTHROPIC_KEY environment variable is not set")
@@ -135,9 +272,33 @@ func main() {
 	if cfg.openAIKey == "" {
 		log.Fatal("OPENAI_KEY environment variable is not set")
 	}
-	// setup the Docker container for running bash commands
-	if err := buildBashDockerIfNeeded(); err != nil {
-		log.Fatalf("error building bash docker image: %v", err)
+	// load the optional sandbox exceptions of the bash tool
+	sandbox, err := loadSandboxConfig()
+	if err != nil {
+		log.Fatalf("error loading sandbox config: %v", err)
+	}
+	// load the optional allow and deny patterns of the bash tool
+	bashPolicy, err := loadBashPolicyConfig()
+	if err != nil {
+		log.Fatalf("error loading bash policy config: %v", err)
+	}
+	// setup the runner for bash commands, the bash tool is disabled instead of failing if Docker is missing
+	runInBash := newBashDockerRunner(sandbox)
+	switch {
+	case slices.Contains(cfg.disabledTools, "bash"):
+	case cfg.bashRunner == "local":
+		runInBash = runInBashLocal
+	case !isDockerInstalled():
+		fmt.Fprintln(os.Stderr, "warning: docker not found, the bash tool is disabled (use -bash-runner=local to run commands without a sandbox)")
+		cfg.disabledTools = append(cfg.disabledTools, "bash")
+	default:
+		// build the Docker image for running bash commands, allowing the build to be aborted
+		buildCtx, stopBuild := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		if err := buildBashDockerIfNeeded(buildCtx, sandbox, cfg.rebuildBash); err != nil {
+			stopBuild()
+			log.Fatalf("error building bash docker image: %v", err)
+		}
+		stopBuild()
 	}
 	// if in debug mode, create a debug log file
 	var debugLogger logger.Logger = logger.NoOp()
@@ -154,20 +315,107 @@ func main() {
 		debugLogger = logger.New(f)
 		debugLogger.SetEnabled(true)
 		debugLogger.SetLevel("debug")
+		debugLogger.SetMaxBytes(cfg.debugMaxBytes)
+	}
+	// load the optional pricing table overriding the built-in model prices
+	var pricing llm.PricingTable
+	if _, err := os.Stat(".ikm/pricing.json"); err == nil {
+		pricing, err = llm.LoadPricingTable(".ikm/pricing.json")
+		if err != nil {
+			log.Fatalf("error loading pricing table: %v", err)
+		}
+	}
+	// load the optional host allowlist and denylist of the web fetch tool
+	webConfig, err := loadWebConfig()
+	if err != nil {
+		log.Fatalf("error loading web config: %v", err)
+	}
+	// load the optional extra directories the file system tools may read
+	rootsConfig, err := loadRootsConfig()
+	if err != nil {
+		log.Fatalf("error loading roots config: %v", err)
+	}
+	// load the optional test command of the test tool
+	testConfig, err := loadTestConfig()
+	if err != nil {
+		log.Fatalf("error loading test config: %v", err)
+	}
+	// load the optional placeholder and banner settings of the TUI
+	uiConfig, err := loadUIConfig()
+	if err != nil {
+		log.Fatalf("error loading ui config: %v", err)
+	}
+	// start the MCP servers configured for this project, unless their tools are disabled
+	var (
+		mcpTools        []llm.Tool
+		closeMCPServers = func() {}
+	)
+	if !slices.Contains(cfg.disabledTools, "mcp") {
+		mcpTools, closeMCPServers, err = startMCPServers(debugLogger)
+		if err != nil {
+			log.Fatalf("error starting MCP servers: %v", err)
+		}
+	}
+	defer closeMCPServers()
+	// load the OpenRouter model catalog, falling back to the built-in model list on failure
+	openRouterModels, err := loadOpenRouterModels(cfg.openRouterKey)
+	if err != nil {
+		debugLogger.Errorf("failed to load OpenRouter models: %v", err)
 	}
 	// init the terminal UI model and run the program
 	if cfg.mode != "agent" && cfg.mode != "dev" && cfg.mode != "raw" {
 		log.Fatalf("invalid mode: %s, must be one of: agent, dev, raw", cfg.mode)
 	}
-	model := tui.Initial(debugLogger, cfg.anthropicKey, cfg.openRouterKey, cfg.openAIKey, runInBashDocker,
+	var agentOptions []agent.Option
+	if cfg.summarizeToolResults {
+		agentOptions = append(agentOptions, agent.WithSummarizeConsumedToolResults())
+	}
+	if cfg.maxTurns > 0 {
+		agentOptions = append(agentOptions, agent.WithMaxTurns(cfg.maxTurns))
+	}
+	if cfg.costBudget > 0 {
+		agentOptions = append(agentOptions, agent.WithCostBudget(cfg.costBudget))
+	}
+	if cfg.compactionThreshold > 0 {
+		agentOptions = append(agentOptions, agent.WithCompactionThreshold(cfg.compactionThreshold))
+	}
+	model := tui.Initial(debugLogger, cfg.anthropicKey, cfg.openRouterKey, cfg.openAIKey, runInBash,
 		tui.WithDynamicMode("agent", func() string { return readSystemPromptWithCustomInstructions(agentPrompt) }),
 		tui.WithDynamicMode("dev", func() string { return readSystemPromptWithCustomInstructions(devPrompt) }),
 		tui.WithDynamicMode("raw", func() string { return readSystemPromptWithCustomInstructions(rawPrompt) }),
 		tui.WithSetDefaultMode(cfg.mode),
+		tui.WithOpenRouterModels(openRouterModels),
 		tui.WithSetDefaultModel(cfg.model),
 		tui.WithDisabledTools(cfg.disabledTools),
+		tui.WithBashTimeout(cfg.bashTimeout),
+		tui.WithBashSandbox(sandbox.Writable, sandbox.Network),
+		tui.WithBashPolicy(bashPolicy.allow, bashPolicy.deny),
+		tui.WithTestCommand(testConfig.Command, testConfig.timeout),
+		tui.WithWebHosts(webConfig.Allow, webConfig.Deny),
+		tui.WithFSRoots(rootsConfig.Roots),
+		tui.WithMCPTools(mcpTools),
+		tui.WithLogsTool(cfg.logsTool),
+		tui.WithPlaceholder(uiConfig.Placeholder),
+		tui.WithBanner(uiConfig.Banner),
 		tui.WithReasoningEffort(cfg.reasoningEffort),
+		tui.WithYolo(cfg.yolo),
+		tui.WithMistralKey(cfg.mistralKey),
+		tui.WithGeminiKey(cfg.geminiKey),
+		tui.WithOllamaBaseURL(cfg.ollamaBaseURL),
+		tui.WithOpenAIReasoningSummary(cfg.openAISummary),
+		tui.WithAnthropicBaseURL(cfg.anthropicBaseURL),
+		tui.WithOpenAIBaseURL(cfg.openAIBaseURL),
+		tui.WithPricingTable(pricing),
+		tui.WithAgentOptions(agentOptions...),
 	)
+	if cfg.prompt != "" {
+		runPrompt(model, cfg)
+		return
+	}
+	if cfg.batch != "" {
+		runBatch(model, cfg)
+		return
+	}
 	program := tea.NewProgram(model, tea.WithAltScreen())
 	if _, err := program.Run(); err != nil {
 		log.Fatalf("error running program: %v", err)
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/markusylisiurunen/ikm/internal/logger"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+	"github.com/markusylisiurunen/ikm/toolkit/tool"
+)
+
+const mcpConfigPath = ".ikm/mcp.json"
+
+type mcpConfig struct {
+	Servers map[string]struct {
+		Command []string `json:"command"`
+	} `json:"servers"`
+}
+
+func startMCPServers(logger logger.Logger) ([]llm.Tool, func(), error) {
+	data, err := os.ReadFile(mcpConfigPath)
+	if os.IsNotExist(err) {
+		return nil, func() {}, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading MCP config: %w", err)
+	}
+	var cfg mcpConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("error parsing MCP config: %w", err)
+	}
+	names := make([]string, 0, len(cfg.Servers))
+	for name := range cfg.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var (
+		tools   []llm.Tool
+		clients []interface{ Close() error }
+	)
+	closeAll := func() {
+		for _, client := range clients {
+			client.Close() //nolint:errcheck
+		}
+	}
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		client := tool.NewMCPClient(cfg.Servers[name].Command).SetLogger(logger)
+		if err := client.Start(ctx); err != nil {
+			cancel()
+			closeAll()
+			return nil, nil, fmt.Errorf("error starting MCP server %s: %w", name, err)
+		}
+		clients = append(clients, client)
+		serverTools, err := client.Tools(ctx)
+		cancel()
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("error listing tools of MCP server %s: %w", name, err)
+		}
+		logger.Debugf("MCP server %s provides %d tools", name, len(serverTools))
+		tools = append(tools, serverTools...)
+	}
+	return tools, closeAll, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+)
+
+const (
+	openRouterModelsCachePath = ".ikm/cache/openrouter_models.json"
+	openRouterModelsCacheTTL  = 24 * time.Hour
+)
+
+func loadOpenRouterModels(token string) ([]llm.OpenRouterModel, error) {
+	// use the cached catalog if it is fresh enough
+	if info, err := os.Stat(openRouterModelsCachePath); err == nil && time.Since(info.ModTime()) < openRouterModelsCacheTTL {
+		data, err := os.ReadFile(openRouterModelsCachePath)
+		if err == nil {
+			var models []llm.OpenRouterModel
+			if err := json.Unmarshal(data, &models); err == nil && len(models) > 0 {
+				return models, nil
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	models, err := llm.FetchOpenRouterModels(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(models)
+	if err != nil {
+		return models, fmt.Errorf("error marshalling OpenRouter models: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(openRouterModelsCachePath), 0755); err != nil {
+		return models, fmt.Errorf("error creating cache folder: %w", err)
+	}
+	if err := os.WriteFile(openRouterModelsCachePath, data, 0644); err != nil {
+		return models, fmt.Errorf("error writing OpenRouter models cache: %w", err)
+	}
+	return models, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/markusylisiurunen/ikm/internal/tui"
+)
+
+func runPrompt(model tui.Model, cfg config) {
+	prompt := cfg.prompt
+	if prompt == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("error reading prompt from stdin: %v", err)
+		}
+		prompt = string(data)
+	}
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		log.Fatal("prompt is empty")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	usage, err := model.RunPrompt(ctx, prompt, os.Stdout)
+	fmt.Fprintf(os.Stderr, "usage: %d prompt tokens, %d completion tokens, total cost %.4f €\n", //nolint:errcheck
+		usage.PromptTokens, usage.CompletionTokens, usage.TotalCost)
+	if err != nil {
+		log.Fatalf("error running prompt: %v", err)
+	}
+}
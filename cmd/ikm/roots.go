@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const rootsConfigPath = ".ikm/roots.json"
+
+type rootsConfig struct {
+	Roots []string `json:"roots"`
+}
+
+func loadRootsConfig() (rootsConfig, error) {
+	var cfg rootsConfig
+	data, err := os.ReadFile(rootsConfigPath)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("error reading roots config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing roots config: %w", err)
+	}
+	// the extra roots are only readable, they are resolved to absolute directories up front
+	roots := make([]string, 0, len(cfg.Roots))
+	for _, root := range cfg.Roots {
+		if after, ok := strings.CutPrefix(root, "~/"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return cfg, fmt.Errorf("error resolving home directory: %w", err)
+			}
+			root = filepath.Join(home, after)
+		}
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return cfg, fmt.Errorf("error resolving root %s: %w", root, err)
+		}
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			return cfg, fmt.Errorf("root must be an existing directory: %s", root)
+		}
+		roots = append(roots, abs)
+	}
+	cfg.Roots = roots
+	return cfg, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	sandboxConfigPath   = ".ikm/sandbox.json"
+	sandboxDefaultImage = "ubuntu:noble"
+)
+
+var (
+	// the image and the packages end up in a shell command, so only plain names are accepted
+	sandboxImageRegexp   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._/:@-]*$`)
+	sandboxPackageRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]*(=[a-zA-Z0-9.+~:-]+)?$`)
+)
+
+type sandboxConfig struct {
+	Writable string   `json:"writable"`
+	Network  []string `json:"network"`
+	Image    string   `json:"image"`
+	Packages []string `json:"packages"`
+}
+
+func loadSandboxConfig() (sandboxConfig, error) {
+	var cfg sandboxConfig
+	data, err := os.ReadFile(sandboxConfigPath)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("error reading sandbox config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing sandbox config: %w", err)
+	}
+	if cfg.Writable != "" {
+		// the writable directory must stay inside the working directory, everything else remains read-only
+		writable := filepath.Clean(cfg.Writable)
+		if filepath.IsAbs(writable) || writable == "." || writable == ".." || strings.HasPrefix(writable, ".."+string(filepath.Separator)) {
+			return cfg, fmt.Errorf("sandbox writable directory must be a subdirectory of the working directory: %s", cfg.Writable)
+		}
+		if err := os.MkdirAll(writable, 0755); err != nil {
+			return cfg, fmt.Errorf("error creating sandbox writable directory: %w", err)
+		}
+		cfg.Writable = filepath.ToSlash(writable)
+	}
+	if cfg.Image != "" && !sandboxImageRegexp.MatchString(cfg.Image) {
+		return cfg, fmt.Errorf("invalid sandbox image: %s", cfg.Image)
+	}
+	for _, pkg := range cfg.Packages {
+		if !sandboxPackageRegexp.MatchString(pkg) {
+			return cfg, fmt.Errorf("invalid sandbox package: %s", pkg)
+		}
+	}
+	return cfg, nil
+}
+
+func (c sandboxConfig) image() string {
+	if c.Image == "" {
+		return sandboxDefaultImage
+	}
+	return c.Image
+}
+
+func (c sandboxConfig) allowsNetwork(cmd string) bool {
+	cmd = strings.TrimSpace(cmd)
+	// chained or substituted commands could smuggle in other commands, so they never get network access
+	if strings.ContainsAny(cmd, ";&|`$<>\n") {
+		return false
+	}
+	for _, prefix := range c.Network {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" && (cmd == prefix || strings.HasPrefix(cmd, prefix+" ")) {
+			return true
+		}
+	}
+	return false
+}
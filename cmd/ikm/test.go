@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const testConfigPath = ".ikm/test.json"
+
+type testConfig struct {
+	Command string `json:"command"`
+	Timeout string `json:"timeout"`
+
+	timeout time.Duration
+}
+
+func loadTestConfig() (testConfig, error) {
+	var cfg testConfig
+	data, err := os.ReadFile(testConfigPath)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("error reading test config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing test config: %w", err)
+	}
+	if cfg.Timeout != "" {
+		cfg.timeout, err = time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return cfg, fmt.Errorf("error parsing test config timeout: %w", err)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	treeMaxDepth    = 3
+	treeMaxBytes    = 8 * 1024
+	treeMaxWalkSize = 10000
+)
+
+var (
+	treeOnce  sync.Once
+	treeCache string
+)
+
+func getDirectoryTree() string {
+	// the tree is computed once per session, later prompt builds reuse it
+	treeOnce.Do(func() {
+		cwd, err := os.Getwd()
+		if err != nil {
+			treeCache = "Directory tree not available."
+			return
+		}
+		files, err := listTreeFiles(cwd)
+		if err != nil || len(files) == 0 {
+			treeCache = "Directory tree not available."
+			return
+		}
+		treeCache = renderDirectoryTree(files, treeMaxDepth, treeMaxBytes)
+	})
+	return treeCache
+}
+
+func listTreeFiles(root string) ([]string, error) {
+	// prefer git so that ignored files are left out, fall back to a bounded walk outside of a repo
+	cmd := exec.Command("git", "ls-files", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = root
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err == nil {
+		var files []string
+		for file := range strings.SplitSeq(strings.TrimSpace(stdout.String()), "\n") {
+			if file != "" {
+				files = append(files, file)
+			}
+		}
+		return files, nil
+	}
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if strings.Count(rel, "/") >= treeMaxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files = append(files, rel)
+		if len(files) >= treeMaxWalkSize {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking directory: %w", err)
+	}
+	return files, nil
+}
+
+func renderDirectoryTree(files []string, maxDepth, maxBytes int) string {
+	root := &treeNode{children: map[string]*treeNode{}}
+	for _, file := range files {
+		parts := strings.Split(file, "/")
+		node := root
+		for i, part := range parts {
+			if i >= maxDepth {
+				// entries below the depth limit are only counted
+				node.hidden++
+				break
+			}
+			child, ok := node.children[part]
+			if !ok {
+				child = &treeNode{children: map[string]*treeNode{}, dir: i < len(parts)-1}
+				node.children[part] = child
+			}
+			node = child
+		}
+	}
+	var lines []string
+	root.render(&lines, 0)
+	var b strings.Builder
+	for i, line := range lines {
+		if b.Len()+len(line)+1 > maxBytes {
+			fmt.Fprintf(&b, "... (truncated, %d more entries)\n", len(lines)-i)
+			break
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// helper types ------------------------------------------------------------------------------------
+
+type treeNode struct {
+	children map[string]*treeNode
+	dir      bool
+	hidden   int
+}
+
+func (n *treeNode) render(lines *[]string, depth int) {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	// directories are listed before files
+	slices.SortFunc(names, func(a, b string) int {
+		if n.children[a].dir != n.children[b].dir {
+			if n.children[a].dir {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	indent := strings.Repeat("  ", depth)
+	for _, name := range names {
+		child := n.children[name]
+		if !child.dir {
+			*lines = append(*lines, indent+name)
+			continue
+		}
+		if child.hidden > 0 && len(child.children) == 0 {
+			*lines = append(*lines, fmt.Sprintf("%s%s/ (%d files)", indent, name, child.hidden))
+			continue
+		}
+		*lines = append(*lines, indent+name+"/")
+		child.render(lines, depth+1)
+		if child.hidden > 0 {
+			*lines = append(*lines, fmt.Sprintf("%s  ... (%d more files)", indent, child.hidden))
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const uiConfigPath = ".ikm/ui.json"
+
+type uiConfig struct {
+	Placeholder string `json:"placeholder"`
+	Banner      bool   `json:"banner"`
+}
+
+func loadUIConfig() (uiConfig, error) {
+	var cfg uiConfig
+	data, err := os.ReadFile(uiConfigPath)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("error reading ui config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing ui config: %w", err)
+	}
+	return cfg, nil
+}
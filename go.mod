@@ -7,7 +7,10 @@ require (
 	github.com/charmbracelet/bubbletea v1.3.5
 	github.com/fatih/color v1.18.0
 	github.com/markusylisiurunen/glamour v0.0.0-20250607173023-7f63b8e02010
+	github.com/mattn/go-runewidth v0.0.16
 	github.com/tidwall/gjson v1.18.0
+	golang.org/x/image v0.28.0
+	golang.org/x/net v0.41.0
 	golang.org/x/sync v0.15.0
 )
 
@@ -29,7 +32,6 @@ require (
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-localereader v0.0.1 // indirect
-	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/microcosm-cc/bluemonday v1.0.27 // indirect
 	github.com/muesli/ansi v0.0.0-20230316100256-276c6243b2f6 // indirect
 	github.com/muesli/cancelreader v0.2.2 // indirect
@@ -41,7 +43,6 @@ require (
 	github.com/xo/terminfo v0.0.0-20220910002029-abceb7e1c41e // indirect
 	github.com/yuin/goldmark v1.7.12 // indirect
 	github.com/yuin/goldmark-emoji v1.0.6 // indirect
-	golang.org/x/net v0.41.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/term v0.32.0 // indirect
 	golang.org/x/text v0.26.0 // indirect
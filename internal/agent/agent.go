@@ -2,8 +2,13 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
+	"unicode/utf8"
 
 	"slices"
 
@@ -11,14 +16,36 @@ import (
 	"github.com/markusylisiurunen/ikm/toolkit/llm"
 )
 
+const (
+	consumedToolResultMaxLength = 1024
+	continuePrompt              = "Your previous response was cut off because it hit the output token limit. Continue exactly where it " +
+		"left off, without repeating anything that was already said."
+)
+
 type Event any
 
 type ChangeEvent struct{}
 
+type MaxTurnsReachedEvent struct {
+	MaxTurns int
+}
+
+type TruncatedEvent struct{}
+
+type CostBudgetExceededEvent struct {
+	Budget float64
+	Cost   float64
+}
+
 type ErrorEvent struct {
 	Err error
 }
 
+type CompactionEvent struct {
+	Messages int
+	Err      error
+}
+
 type Agent struct {
 	mux           sync.RWMutex
 	logger        logger.Logger
@@ -27,29 +54,96 @@ type Agent struct {
 	system        func() string
 	streamOptions []llm.StreamOption
 
+	summarizeConsumedToolResults bool
+	compactionModel              llm.Model
+	compactionThreshold          int
+	maxTurns                     int
+	costBudget                   float64
+	spent                        float64
+
 	running       bool
+	stopped       chan struct{}
+	truncated     bool
 	inFlightTools map[string]bool
-	messages      []llm.Message
+	history       History
 	usage         llm.Usage
+	contextTokens int
+	editedFiles   []string
 
 	subscriptions []chan<- Event
 }
 
-func New(logger logger.Logger, tools []llm.Tool) *Agent {
-	return &Agent{
+type Option func(*Agent)
+
+func WithSummarizeConsumedToolResults() Option {
+	return func(a *Agent) {
+		a.summarizeConsumedToolResults = true
+	}
+}
+
+func WithCompactionModel(model llm.Model) Option {
+	return func(a *Agent) {
+		a.compactionModel = model
+	}
+}
+
+func WithCompactionThreshold(tokens int) Option {
+	return func(a *Agent) {
+		a.compactionThreshold = tokens
+	}
+}
+
+func WithHistory(history History) Option {
+	return func(a *Agent) {
+		if history != nil {
+			a.history = history
+		}
+	}
+}
+
+func WithMaxTurns(turns int) Option {
+	return func(a *Agent) {
+		if turns > 0 {
+			a.maxTurns = turns
+		}
+	}
+}
+
+func WithCostBudget(eur float64) Option {
+	return func(a *Agent) {
+		if eur > 0 {
+			a.costBudget = eur
+		}
+	}
+}
+
+func New(logger logger.Logger, tools []llm.Tool, opts ...Option) *Agent {
+	a := &Agent{
 		logger:        logger,
 		tools:         tools,
 		inFlightTools: make(map[string]bool),
+		history:       NewMemoryHistory(),
+		maxTurns:      128,
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
+	return a
 }
 
-func (a *Agent) Reset() {
+func (a *Agent) Reset() error {
 	a.mux.Lock()
 	defer a.mux.Unlock()
-	a.running = false
+	if a.running {
+		return errors.New("cannot reset the conversation while the agent is running")
+	}
+	a.truncated = false
 	a.inFlightTools = make(map[string]bool)
-	a.messages = nil
+	a.history.Truncate(0)
 	a.usage = llm.Usage{}
+	a.contextTokens = 0
+	a.editedFiles = nil
+	return nil
 }
 
 func (a *Agent) Subscribe() (<-chan Event, func()) {
@@ -75,7 +169,7 @@ func (a *Agent) SetModel(model llm.Model, options ...llm.StreamOption) {
 	defer a.mux.Unlock()
 	a.model = model
 	a.streamOptions = options
-	a.streamOptions = append(a.streamOptions, llm.WithMaxTurns(128))
+	a.streamOptions = append(a.streamOptions, llm.WithMaxTurns(a.maxTurns))
 }
 
 func (a *Agent) SetSystem(system func() string) {
@@ -90,10 +184,26 @@ func (a *Agent) GetIsRunning() bool {
 	return a.running
 }
 
+func (a *Agent) Wait(ctx context.Context) error {
+	a.mux.RLock()
+	if !a.running {
+		a.mux.RUnlock()
+		return nil
+	}
+	stopped := a.stopped
+	a.mux.RUnlock()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (a *Agent) GetHistoryState() ([]llm.Message, llm.Usage) {
 	a.mux.RLock()
 	defer a.mux.RUnlock()
-	return a.messages, a.usage
+	return a.history.Messages(), a.usage
 }
 
 func (a *Agent) IsToolCallInFlight(toolCallID string) bool {
@@ -102,56 +212,219 @@ func (a *Agent) IsToolCallInFlight(toolCallID string) bool {
 	return a.inFlightTools[toolCallID]
 }
 
-func (a *Agent) Send(ctx context.Context, message string) {
-	go a.send(ctx, message)
+type session struct {
+	Messages []llm.Message `json:"messages"`
+	Usage    llm.Usage     `json:"usage"`
 }
-func (a *Agent) send(ctx context.Context, message string) {
+
+func (a *Agent) SaveSession(path string) error {
+	a.mux.RLock()
+	data, err := json.MarshalIndent(session{Messages: a.history.Messages(), Usage: a.usage}, "", "  ")
+	a.mux.RUnlock()
+	if err != nil {
+		return fmt.Errorf("error marshalling session: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating session folder: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing session: %w", err)
+	}
+	return nil
+}
+
+func (a *Agent) LoadSession(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading session: %w", err)
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("error unmarshalling session: %w", err)
+	}
 	a.mux.Lock()
 	if a.running {
 		a.mux.Unlock()
-		return
+		return errors.New("cannot load a session while the agent is running")
+	}
+	a.truncated = false
+	a.inFlightTools = make(map[string]bool)
+	replaceHistory(a.history, s.Messages)
+	a.usage = s.Usage
+	a.contextTokens = 0
+	a.mux.Unlock()
+	a.notify(&ChangeEvent{})
+	return nil
+}
+
+func (a *Agent) Rewind() (llm.ContentParts, bool) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if a.running {
+		return nil, false
+	}
+	messages := a.history.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == llm.RoleUser {
+			content := messages[i].Content
+			a.history.Truncate(i)
+			a.truncated = false
+			a.inFlightTools = make(map[string]bool)
+			return content, true
+		}
+	}
+	return nil, false
+}
+
+func (a *Agent) PopLastExchange() bool {
+	_, ok := a.Rewind()
+	if ok {
+		a.notify(&ChangeEvent{})
+	}
+	return ok
+}
+
+func (a *Agent) Send(ctx context.Context, message string, opts ...llm.StreamOption) {
+	go a.send(ctx, llm.ContentParts{llm.NewTextContentPart(message)}, opts...)
+}
+
+func (a *Agent) SendContent(ctx context.Context, content llm.ContentParts, opts ...llm.StreamOption) {
+	go a.send(ctx, content, opts...)
+}
+
+func (a *Agent) Run(ctx context.Context, message string, opts ...llm.StreamOption) {
+	a.send(ctx, llm.ContentParts{llm.NewTextContentPart(message)}, opts...)
+}
+func (a *Agent) Continue(ctx context.Context, opts ...llm.StreamOption) bool {
+	a.mux.RLock()
+	ok := !a.running && a.truncated
+	a.mux.RUnlock()
+	if !ok {
+		return false
+	}
+	go func() {
+		if !a.start() {
+			return
+		}
+		a.mux.Lock()
+		replaceHistory(a.history, continuationMessages(a.history.Messages()))
+		a.inFlightTools = make(map[string]bool)
+		a.mux.Unlock()
+		a.notify(&ChangeEvent{})
+		// the request to continue is only sent to the model, the continuation ends up in the truncated message
+		a.stream(ctx, []llm.Message{{Role: llm.RoleUser, Content: llm.ContentParts{llm.NewTextContentPart(continuePrompt)}}}, opts...)
+	}()
+	return true
+}
+
+func continuationMessages(messages []llm.Message) []llm.Message {
+	messages = slices.Clone(messages)
+	last := len(messages) - 1
+	if last < 0 || messages[last].Role != llm.RoleAssistant || len(messages[last].ToolCalls) == 0 {
+		return messages
+	}
+	// the tool calls of the last message never got results, their arguments were likely cut off mid-way, so they
+	// are dropped and left for the model to make again
+	messages[last].ToolCalls = nil
+	if len(messages[last].Content) == 0 {
+		return messages[:last]
+	}
+	return messages
+}
+
+func (a *Agent) start() bool {
+	a.mux.Lock()
+	if a.running {
+		a.mux.Unlock()
+		return false
+	}
+	if a.costBudget > 0 && a.spent >= a.costBudget {
+		// the budget is spent for the whole session, nothing more is sent
+		cost := a.spent
+		a.mux.Unlock()
+		a.notify(&CostBudgetExceededEvent{Budget: a.costBudget, Cost: cost})
+		return false
 	}
 	a.running = true
+	a.stopped = make(chan struct{})
+	a.truncated = false
 	a.mux.Unlock()
-	a.messages = append(a.messages, llm.Message{
+	return true
+}
+
+func (a *Agent) send(ctx context.Context, content llm.ContentParts, opts ...llm.StreamOption) {
+	if !a.start() {
+		return
+	}
+	a.mux.Lock()
+	a.history.Append(llm.Message{
 		Role:    llm.RoleUser,
-		Content: llm.ContentParts{llm.NewTextContentPart(message)},
+		Content: content,
 	})
+	a.mux.Unlock()
 	a.notify(&ChangeEvent{})
-	for event := range a.model.Stream(ctx, a.getMessageHistory(), a.streamOptions...) {
+	a.stream(ctx, nil, opts...)
+}
+
+func (a *Agent) stream(ctx context.Context, extra []llm.Message, opts ...llm.StreamOption) {
+	// per-turn options are applied after the defaults so that they take precedence
+	streamOptions := append(slices.Clone(a.streamOptions), opts...)
+	// the channel is captured up front, a reset mid-run may already have let a new run start by the end
+	a.mux.RLock()
+	stopped := a.stopped
+	a.mux.RUnlock()
+	// the stream is cancelled on its own when the cost budget runs out mid-turn
+	streamCtx, cancelStream := context.WithCancel(ctx)
+	defer cancelStream()
+	var budgetExceeded bool
+	for event := range a.model.Stream(streamCtx, append(a.getMessageHistory(), extra...), streamOptions...) {
 		switch e := event.(type) {
 		case *llm.ThinkingDeltaEvent:
-			continue
+			a.mux.Lock()
+			a.ensureAssistantMessage()
+			updateLastMessage(a.history, func(msg *llm.Message) {
+				content := &msg.Content
+				if len(*content) > 0 {
+					if p, ok := (*content)[len(*content)-1].(llm.ThinkingContentPart); ok {
+						// the signature arrives separately at the end of the thinking block
+						p.Thinking += e.Thinking
+						if e.Signature != "" {
+							p.Signature = e.Signature
+						}
+						if e.EncryptedContent != "" {
+							p.EncryptedContent = e.EncryptedContent
+						}
+						(*content)[len(*content)-1] = p
+					} else {
+						*content = append(*content, newThinkingContentPart(e))
+					}
+				} else {
+					*content = append(*content, newThinkingContentPart(e))
+				}
+			})
+			a.mux.Unlock()
+			a.notify(&ChangeEvent{})
 		case *llm.ContentDeltaEvent:
 			a.mux.Lock()
-			if msg := a.messages[len(a.messages)-1]; msg.Role != llm.RoleAssistant {
-				a.messages = append(a.messages, llm.Message{
-					Role:    llm.RoleAssistant,
-					Content: llm.ContentParts{},
-				})
-			}
-			a.messages[len(a.messages)-1].Content.AppendText(e.Content)
+			a.ensureAssistantMessage()
+			updateLastMessage(a.history, func(msg *llm.Message) {
+				msg.Content.AppendText(e.Content)
+			})
 			a.mux.Unlock()
 			a.notify(&ChangeEvent{})
 		case *llm.ToolUseEvent:
 			a.mux.Lock()
-			if msg := a.messages[len(a.messages)-1]; msg.Role != llm.RoleAssistant {
-				a.messages = append(a.messages, llm.Message{
-					Role:    llm.RoleAssistant,
-					Content: llm.ContentParts{},
-				})
-			}
-			a.messages[len(a.messages)-1].ToolCalls = append(
-				a.messages[len(a.messages)-1].ToolCalls,
-				llm.ToolCall{
+			a.ensureAssistantMessage()
+			updateLastMessage(a.history, func(msg *llm.Message) {
+				msg.ToolCalls = append(msg.ToolCalls, llm.ToolCall{
 					ID:    e.ID,
 					Index: e.Index,
 					Function: llm.ToolCallFunction{
 						Name: e.FuncName,
 						Args: e.FuncArgs,
 					},
-				},
-			)
+				})
+			})
 			a.inFlightTools[e.ID] = true
 			a.mux.Unlock()
 			a.notify(&ChangeEvent{})
@@ -159,9 +432,10 @@ func (a *Agent) send(ctx context.Context, message string) {
 			a.mux.Lock()
 			delete(a.inFlightTools, e.ID)
 			var msg *llm.Message
-			for i := len(a.messages) - 1; i >= 0; i-- {
-				if a.messages[i].Role == llm.RoleAssistant {
-					msg = &a.messages[i]
+			messages := a.history.Messages()
+			for i := len(messages) - 1; i >= 0; i-- {
+				if messages[i].Role == llm.RoleAssistant {
+					msg = &messages[i]
 					break
 				}
 			}
@@ -178,35 +452,88 @@ func (a *Agent) send(ctx context.Context, message string) {
 				if toolCall == nil {
 					a.logger.Errorf("tool result event without matching tool call: %s", e.ID)
 				} else {
-					a.messages = append(a.messages, llm.Message{
+					result := llm.Message{
 						Role:       llm.RoleTool,
 						ToolCallID: toolCall.ID,
 						Name:       toolCall.Function.Name,
 						Content:    llm.ContentParts{},
-					})
-					a.messages[len(a.messages)-1].Content.AppendText(e.Result)
+					}
+					result.Content.AppendText(e.Result)
+					a.history.Append(result)
 				}
 			}
 			a.mux.Unlock()
 			a.notify(&ChangeEvent{})
 		case *llm.UsageEvent:
 			a.mux.Lock()
-			a.usage.PromptTokens = e.Usage.PromptTokens
-			a.usage.CompletionTokens = e.Usage.CompletionTokens
+			// usage is reported per turn, the session usage is the sum over all turns
+			a.usage.PromptTokens += e.Usage.PromptTokens
+			a.usage.CompletionTokens += e.Usage.CompletionTokens
 			a.usage.TotalCost += e.Usage.TotalCost
+			// unlike the usage, the spent cost is kept when the conversation is cleared or replaced
+			a.spent += e.Usage.TotalCost
+			// the latest turn tells how large the context currently is
+			a.contextTokens = e.Usage.PromptTokens + e.Usage.CompletionTokens
+			cost := a.spent
 			a.mux.Unlock()
 			a.notify(&ChangeEvent{})
+			if a.costBudget > 0 && cost >= a.costBudget && !budgetExceeded {
+				budgetExceeded = true
+				a.logger.Debugf("stopped after exceeding the cost budget of %.4f with %.4f", a.costBudget, cost)
+				cancelStream()
+				a.notify(&CostBudgetExceededEvent{Budget: a.costBudget, Cost: cost})
+			}
+		case *llm.FinishEvent:
+			a.mux.Lock()
+			a.truncated = e.Reason == "length"
+			a.mux.Unlock()
+			// a response cut off by the token limit would otherwise look like a complete one
+			if e.Reason == "length" {
+				a.logger.Debugf("the response was truncated after hitting the max tokens")
+				a.notify(&TruncatedEvent{})
+			}
+		case *llm.MaxTurnsReachedEvent:
+			a.logger.Debugf("stopped after reaching the max tool-call turns of %d", e.MaxTurns)
+			a.notify(&MaxTurnsReachedEvent{MaxTurns: e.MaxTurns})
 		case *llm.ErrorEvent:
+			if budgetExceeded && errors.Is(e.Err, context.Canceled) {
+				continue
+			}
 			a.notify(&ErrorEvent{Err: e.Err})
 		default:
 			a.notify(fmt.Errorf("unknown event type: %T", e))
 		}
 	}
+	a.mux.RLock()
+	shouldCompact := a.compactionThreshold > 0 && a.contextTokens >= a.compactionThreshold
+	a.mux.RUnlock()
+	if shouldCompact && !budgetExceeded && ctx.Err() == nil {
+		if err := a.compact(ctx); err != nil {
+			a.logger.Errorf("failed to compact the conversation: %v", err)
+			a.notify(&CompactionEvent{Err: err})
+		}
+	}
 	a.mux.Lock()
 	a.running = false
+	close(stopped)
 	a.mux.Unlock()
 }
 
+func (a *Agent) EditedFiles() []string {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	return slices.Clone(a.editedFiles)
+}
+
+func (a *Agent) RecordEditedFile(path string) {
+	// the tools report the files they change, each file is listed once in the order it was first edited
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if !slices.Contains(a.editedFiles, path) {
+		a.editedFiles = append(a.editedFiles, path)
+	}
+}
+
 func (a *Agent) notify(event Event) {
 	var subsToNotify []chan<- Event
 	a.mux.RLock()
@@ -227,15 +554,59 @@ func (a *Agent) notify(event Event) {
 	}
 }
 
+func (a *Agent) ensureAssistantMessage() {
+	if last, ok := a.history.Last(); !ok || last.Role != llm.RoleAssistant {
+		a.history.Append(llm.Message{
+			Role:    llm.RoleAssistant,
+			Content: llm.ContentParts{},
+		})
+	}
+}
+
 func (a *Agent) getMessageHistory() []llm.Message {
 	a.mux.RLock()
 	defer a.mux.RUnlock()
-	messages := make([]llm.Message, 0, 1+len(a.messages))
+	history := a.history.Messages()
+	messages := make([]llm.Message, 0, 1+len(history))
 	if a.system != nil {
 		messages = append(messages, llm.Message{
 			Role:    llm.RoleSystem,
 			Content: llm.ContentParts{llm.NewTextContentPart(a.system())},
 		})
 	}
-	return append(messages, a.messages...)
+	if !a.summarizeConsumedToolResults {
+		return append(messages, history...)
+	}
+	// replace the tool results the model has already seen with a short summary
+	lastAssistantIdx := -1
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == llm.RoleAssistant {
+			lastAssistantIdx = i
+			break
+		}
+	}
+	for i, msg := range history {
+		if msg.Role == llm.RoleTool && i < lastAssistantIdx {
+			msg.Content = llm.ContentParts{llm.NewTextContentPart(summarizeToolResult(msg.Content.Text()))}
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func summarizeToolResult(result string) string {
+	if len(result) <= consumedToolResultMaxLength {
+		return result
+	}
+	cut := consumedToolResultMaxLength
+	for cut > 0 && !utf8.RuneStart(result[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s... (%d bytes of this previously seen tool result omitted)", result[:cut], len(result)-cut)
+}
+
+func newThinkingContentPart(e *llm.ThinkingDeltaEvent) llm.ThinkingContentPart {
+	part := llm.NewThinkingContentPart(e.Thinking, e.Signature)
+	part.EncryptedContent = e.EncryptedContent
+	return part
 }
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+)
+
+//go:embed compaction_system.md
+var compactionSystem string
+
+func (a *Agent) Compact(ctx context.Context) error {
+	a.mux.Lock()
+	if a.running {
+		a.mux.Unlock()
+		return errors.New("cannot compact the conversation while the agent is running")
+	}
+	a.running = true
+	a.stopped = make(chan struct{})
+	stopped := a.stopped
+	a.mux.Unlock()
+	defer func() {
+		a.mux.Lock()
+		a.running = false
+		close(stopped)
+		a.mux.Unlock()
+	}()
+	if err := a.compact(ctx); err != nil {
+		a.notify(&CompactionEvent{Err: err})
+		return err
+	}
+	return nil
+}
+
+func (a *Agent) compact(ctx context.Context) error {
+	if a.compactionModel == nil {
+		return errors.New("no compaction model configured")
+	}
+	a.mux.RLock()
+	messages := a.history.Messages()
+	a.mux.RUnlock()
+	// split at a user message so that no tool call is separated from its result
+	split := getCompactionSplitIndex(messages)
+	if split <= 0 {
+		return errors.New("the conversation is too short to compact")
+	}
+	events := a.compactionModel.Stream(ctx, []llm.Message{
+		{Role: llm.RoleSystem, Content: llm.ContentParts{llm.NewTextContentPart(strings.TrimSpace(compactionSystem))}},
+		{Role: llm.RoleUser, Content: llm.ContentParts{llm.NewTextContentPart(renderCompactionTranscript(messages[:split]))}},
+	}, llm.WithMaxTokens(8192), llm.WithMaxTurns(1), llm.WithTemperature(0.2))
+	result, usage, err := llm.Rollup(events)
+	if err != nil {
+		return fmt.Errorf("error summarizing the conversation: %w", err)
+	}
+	var summary string
+	for _, msg := range result {
+		if msg.Role == llm.RoleAssistant {
+			summary += msg.Content.Text()
+		}
+	}
+	if strings.TrimSpace(summary) == "" {
+		return errors.New("the conversation summary is empty")
+	}
+	compacted := make([]llm.Message, 0, 1+len(messages)-split)
+	// the summary is given as context by the user, the model would otherwise take it as something it said itself
+	compacted = append(compacted, llm.Message{
+		Role: llm.RoleUser,
+		Content: llm.ContentParts{llm.NewTextContentPart(
+			"Summary of the earlier conversation:\n\n" + strings.TrimSpace(summary),
+		)},
+	})
+	a.mux.Lock()
+	current := a.history.Messages()
+	// the summarized messages must still be there, otherwise the history was replaced in the meantime
+	if len(current) < split {
+		a.mux.Unlock()
+		return errors.New("the conversation changed while it was being compacted")
+	}
+	// keep anything appended to the history while the summary was being generated
+	compacted = append(compacted, current[split:]...)
+	replaceHistory(a.history, compacted)
+	a.usage.PromptTokens += usage.PromptTokens
+	a.usage.CompletionTokens += usage.CompletionTokens
+	a.usage.TotalCost += usage.TotalCost
+	a.spent += usage.TotalCost
+	a.contextTokens = 0
+	a.mux.Unlock()
+	a.logger.Debugf("compacted %d messages into a summary of %d bytes", split, len(summary))
+	a.notify(&CompactionEvent{Messages: split})
+	return nil
+}
+
+func getCompactionSplitIndex(messages []llm.Message) int {
+	mid := len(messages) / 2
+	for i := mid; i < len(messages); i++ {
+		if messages[i].Role == llm.RoleUser {
+			return i
+		}
+	}
+	for i := mid - 1; i > 0; i-- {
+		if messages[i].Role == llm.RoleUser {
+			return i
+		}
+	}
+	return 0
+}
+
+func renderCompactionTranscript(messages []llm.Message) string {
+	var sb strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case llm.RoleUser:
+			sb.WriteString("USER:\n" + msg.Content.Text() + "\n\n")
+		case llm.RoleAssistant:
+			if text := msg.Content.Text(); text != "" {
+				sb.WriteString("ASSISTANT:\n" + text + "\n\n")
+			}
+			for _, toolCall := range msg.ToolCalls {
+				sb.WriteString(fmt.Sprintf("TOOL CALL (%s):\n%s\n\n", toolCall.Function.Name, toolCall.Function.Args))
+			}
+		case llm.RoleTool:
+			sb.WriteString(fmt.Sprintf("TOOL RESULT (%s):\n%s\n\n", msg.Name, summarizeToolResult(msg.Content.Text())))
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
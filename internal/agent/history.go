@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"slices"
+
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+)
+
+type History interface {
+	Append(messages ...llm.Message)
+	Messages() []llm.Message
+	Last() (llm.Message, bool)
+	SetLast(msg llm.Message)
+	Truncate(n int)
+	Len() int
+}
+
+var _ History = (*memoryHistory)(nil)
+
+type memoryHistory struct {
+	// the agent guards the history with its own lock, so no locking is needed here
+	messages []llm.Message
+}
+
+func NewMemoryHistory() History {
+	return &memoryHistory{}
+}
+
+func (h *memoryHistory) Append(messages ...llm.Message) {
+	h.messages = append(h.messages, messages...)
+}
+
+func (h *memoryHistory) Messages() []llm.Message {
+	return slices.Clone(h.messages)
+}
+
+func (h *memoryHistory) Last() (llm.Message, bool) {
+	if len(h.messages) == 0 {
+		return llm.Message{}, false
+	}
+	return h.messages[len(h.messages)-1], true
+}
+
+func (h *memoryHistory) SetLast(msg llm.Message) {
+	if len(h.messages) > 0 {
+		h.messages[len(h.messages)-1] = msg
+	}
+}
+
+func (h *memoryHistory) Truncate(n int) {
+	if n < 0 {
+		n = 0
+	}
+	if n < len(h.messages) {
+		// the dropped messages are cleared so that their content can be garbage collected
+		clear(h.messages[n:])
+		h.messages = h.messages[:n]
+	}
+}
+
+func (h *memoryHistory) Len() int {
+	return len(h.messages)
+}
+
+func replaceHistory(h History, messages []llm.Message) {
+	h.Truncate(0)
+	h.Append(messages...)
+}
+
+func updateLastMessage(h History, update func(msg *llm.Message)) {
+	msg, ok := h.Last()
+	if !ok {
+		return
+	}
+	update(&msg)
+	h.SetLast(msg)
+}
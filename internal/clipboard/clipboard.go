@@ -0,0 +1,124 @@
+package clipboard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var (
+	ErrNoClipboard = errors.New("no clipboard tool found, install pbcopy, wl-copy, xclip or xsel")
+	ErrNoImage     = errors.New("the clipboard does not contain an image")
+)
+
+var lookPath = exec.LookPath
+
+func Write(text string) error {
+	command, err := selectCommand(runtime.GOOS, os.Getenv("WAYLAND_DISPLAY") != "", lookPath)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("error running %s: %w: %s", command[0], err, msg)
+		}
+		return fmt.Errorf("error running %s: %w", command[0], err)
+	}
+	return nil
+}
+
+func selectCommand(goos string, wayland bool, lookPath func(string) (string, error)) ([]string, error) {
+	var candidates [][]string
+	switch goos {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip.exe"}}
+	default:
+		// wayland sessions prefer wl-copy, clip.exe covers WSL where neither display server is available
+		x11 := [][]string{{"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+		if wayland {
+			candidates = append([][]string{{"wl-copy"}}, x11...)
+		} else {
+			candidates = append(x11, []string{"wl-copy"})
+		}
+		candidates = append(candidates, []string{"clip.exe"})
+	}
+	for _, candidate := range candidates {
+		if _, err := lookPath(candidate[0]); err == nil {
+			return candidate, nil
+		}
+	}
+	return nil, ErrNoClipboard
+}
+
+func ReadImage() ([]byte, error) {
+	if runtime.GOOS == "darwin" {
+		return readImageDarwin()
+	}
+	command, err := selectImageCommand(runtime.GOOS, os.Getenv("WAYLAND_DISPLAY") != "", lookPath)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	// both tools fail when the clipboard has no image in it, which is the common case for a paste
+	if err := cmd.Run(); err != nil || stdout.Len() == 0 {
+		return nil, ErrNoImage
+	}
+	return stdout.Bytes(), nil
+}
+
+func readImageDarwin() ([]byte, error) {
+	// osascript cannot write binary data to stdout, so the image goes through a temporary file
+	f, err := os.CreateTemp("", "ikm-clipboard-*.png")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file: %w", err)
+	}
+	path := f.Name()
+	f.Close()             //nolint:errcheck
+	defer os.Remove(path) //nolint:errcheck
+	script := []string{
+		"-e", fmt.Sprintf("set f to open for access POSIX file %q with write permission", path),
+		"-e", "write (the clipboard as «class PNGf») to f",
+		"-e", "close access f",
+	}
+	if err := exec.Command("osascript", script...).Run(); err != nil {
+		return nil, ErrNoImage
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading clipboard image: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, ErrNoImage
+	}
+	return data, nil
+}
+
+func selectImageCommand(goos string, wayland bool, lookPath func(string) (string, error)) ([]string, error) {
+	if goos == "windows" {
+		return nil, ErrNoClipboard
+	}
+	wlPaste := []string{"wl-paste", "--no-newline", "--type", "image/png"}
+	xclip := []string{"xclip", "-selection", "clipboard", "-t", "image/png", "-o"}
+	candidates := [][]string{xclip, wlPaste}
+	if wayland {
+		candidates = [][]string{wlPaste, xclip}
+	}
+	for _, candidate := range candidates {
+		if _, err := lookPath(candidate[0]); err == nil {
+			return candidate, nil
+		}
+	}
+	return nil, ErrNoClipboard
+}
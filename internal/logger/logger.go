@@ -12,6 +12,7 @@ import (
 type Logger interface {
 	SetEnabled(enabled bool)
 	SetLevel(level string)
+	SetMaxBytes(maxBytes int64)
 	Debugf(msg string, args ...any)
 	Debugj(msg string, data json.RawMessage)
 	Errorf(msg string, args ...any)
@@ -30,6 +31,7 @@ func NoOp() Logger {
 
 func (n *noOpLogger) SetEnabled(_ bool)                  {}
 func (n *noOpLogger) SetLevel(_ string)                  {}
+func (n *noOpLogger) SetMaxBytes(_ int64)                {}
 func (n *noOpLogger) Debugf(_ string, _ ...any)          {}
 func (n *noOpLogger) Debugj(_ string, _ json.RawMessage) {}
 func (n *noOpLogger) Errorf(_ string, _ ...any)          {}
@@ -39,19 +41,29 @@ func (n *noOpLogger) Errorj(_ string, _ json.RawMessage) {}
 
 var _ Logger = (*logger)(nil)
 
+const loggerMaxRotatedFiles = 5
+
 type logger struct {
-	mux     sync.RWMutex
-	enabled bool
-	level   string
-	file    *os.File
+	mux      sync.RWMutex
+	enabled  bool
+	level    string
+	maxBytes int64
+	size     int64
+	file     *os.File
 }
 
 func New(file *os.File) Logger {
-	return &logger{
+	l := &logger{
 		enabled: true,
 		level:   "error",
 		file:    file,
 	}
+	if file != nil {
+		if info, err := file.Stat(); err == nil {
+			l.size = info.Size()
+		}
+	}
+	return l
 }
 
 func (l *logger) SetEnabled(enabled bool) {
@@ -66,6 +78,12 @@ func (l *logger) SetLevel(level string) {
 	l.level = level
 }
 
+func (l *logger) SetMaxBytes(maxBytes int64) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.maxBytes = maxBytes
+}
+
 func (l *logger) Debugf(msg string, args ...any) {
 	l.logf("debug", msg, args...)
 }
@@ -91,9 +109,9 @@ type logLineData struct {
 
 func (l *logger) logf(level string, msg string, args ...any) {
 	l.mux.RLock()
-	_enabled, _level := l.enabled, l.level
+	_enabled, _level, _file := l.enabled, l.level, l.file
 	l.mux.RUnlock()
-	if !_enabled || l.file == nil {
+	if !_enabled || _file == nil {
 		return
 	}
 	levels := []string{"debug", "error"}
@@ -107,24 +125,19 @@ func (l *logger) logf(level string, msg string, args ...any) {
 	logLineDataBytes, err := json.Marshal(logLineData{
 		Ts:      time.Now().Format(time.RFC3339),
 		Level:   level,
-		Message: fmt.Sprintf(msg, args...),
+		Message: string(Redact([]byte(fmt.Sprintf(msg, args...)))),
 	})
 	if err != nil {
 		panic(fmt.Sprintf("error marshalling log line: %v", err))
 	}
-	if _, err := l.file.Write(append(logLineDataBytes, '\n')); err != nil {
-		panic(fmt.Sprintf("error writing log line: %v", err))
-	}
-	if err := l.file.Sync(); err != nil {
-		panic(fmt.Sprintf("error syncing log file: %v", err))
-	}
+	l.write(append(logLineDataBytes, '\n'))
 }
 
 func (l *logger) logj(level string, msg string, data json.RawMessage) {
 	l.mux.RLock()
-	_enabled, _level := l.enabled, l.level
+	_enabled, _level, _file := l.enabled, l.level, l.file
 	l.mux.RUnlock()
-	if !_enabled || l.file == nil {
+	if !_enabled || _file == nil {
 		return
 	}
 	levels := []string{"debug", "error"}
@@ -139,15 +152,53 @@ func (l *logger) logj(level string, msg string, data json.RawMessage) {
 		Ts:      time.Now().Format(time.RFC3339),
 		Level:   level,
 		Message: msg,
-		Data:    data,
+		Data:    Redact(data),
 	})
 	if err != nil {
 		panic(fmt.Sprintf("error marshalling log line: %v", err))
 	}
-	if _, err := l.file.Write(append(logLineDataBytes, '\n')); err != nil {
+	l.write(append(logLineDataBytes, '\n'))
+}
+
+func (l *logger) write(line []byte) {
+	// writes are serialised so that the file is never swapped in the middle of one
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			panic(fmt.Sprintf("error rotating log file: %v", err))
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
 		panic(fmt.Sprintf("error writing log line: %v", err))
 	}
 	if err := l.file.Sync(); err != nil {
 		panic(fmt.Sprintf("error syncing log file: %v", err))
 	}
 }
+
+func (l *logger) rotate() error {
+	name := l.file.Name()
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("error closing log file: %w", err)
+	}
+	// shift the older files up by one, dropping the oldest one
+	for i := loggerMaxRotatedFiles - 1; i >= 1; i-- {
+		src, dst := fmt.Sprintf("%s.%d", name, i), fmt.Sprintf("%s.%d", name, i+1)
+		if err := os.Rename(src, dst); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error renaming log file: %w", err)
+		}
+	}
+	if err := os.Rename(name, name+".1"); err != nil {
+		return fmt.Errorf("error renaming log file: %w", err)
+	}
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+	l.file = file
+	l.size = 0
+	return nil
+}
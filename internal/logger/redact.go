@@ -0,0 +1,34 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	redactDataURLRegex = regexp.MustCompile(`data:([\w.+-]+/[\w.+-]+);base64,[A-Za-z0-9+/]+=*`)
+	redactBase64Regex  = regexp.MustCompile(`[A-Za-z0-9+/]{256,}=*`)
+	redactBearerRegex  = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	redactAPIKeyRegex  = regexp.MustCompile(`(?i)((?:x-api-key|api[_-]?key)\\?"?\s*[:=]\s*\\?"?)[^"\\\s,}]+`)
+	redactSecretRegex  = regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`)
+)
+
+func Redact(data []byte) []byte {
+	// inline files and images are replaced with their size, they only bloat the logs
+	data = redactDataURLRegex.ReplaceAllFunc(data, func(match []byte) []byte {
+		sub := redactDataURLRegex.FindSubmatch(match)
+		return fmt.Appendf(nil, "data:%s;base64,[base64 %d bytes]", sub[1], base64Size(len(match)-len(sub[1])-len("data:;base64,")))
+	})
+	data = redactBase64Regex.ReplaceAllFunc(data, func(match []byte) []byte {
+		return fmt.Appendf(nil, "[base64 %d bytes]", base64Size(len(match)))
+	})
+	// credentials must never end up in the logs
+	data = redactBearerRegex.ReplaceAll(data, []byte("${1}[redacted]"))
+	data = redactAPIKeyRegex.ReplaceAll(data, []byte("${1}[redacted]"))
+	data = redactSecretRegex.ReplaceAll(data, []byte("[redacted]"))
+	return data
+}
+
+func base64Size(n int) int {
+	return n * 3 / 4
+}
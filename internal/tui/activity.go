@@ -0,0 +1,89 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+)
+
+const (
+	activityTickInterval  = 100 * time.Millisecond
+	activityIdleThreshold = 10 * time.Second
+)
+
+var activitySpinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+type activityTickMsg struct {
+	started time.Time
+}
+
+func activityTickCmd(started time.Time) tea.Cmd {
+	return tea.Tick(activityTickInterval, func(time.Time) tea.Msg {
+		return activityTickMsg{started: started}
+	})
+}
+
+func (m *Model) updateActivity(now time.Time) tea.Cmd {
+	if !m.agent.GetIsRunning() {
+		m.activity = turnActivity{}
+		return nil
+	}
+	messages, _ := m.agent.GetHistoryState()
+	output := estimateOutputTokens(messages)
+	var cmd tea.Cmd
+	if m.activity.started.IsZero() {
+		// the output already in the history when the turn starts is not part of its rate
+		m.activity = turnActivity{started: now, baseline: output}
+		cmd = activityTickCmd(now)
+	}
+	m.activity.lastEvent = now
+	m.activity.tokens = max(output-m.activity.baseline, 0)
+	return cmd
+}
+
+func (m Model) renderActivity(now time.Time) string {
+	if m.activity.started.IsZero() {
+		return "working..."
+	}
+	frame := activitySpinnerFrames[m.activity.frame%len(activitySpinnerFrames)]
+	elapsed := now.Sub(m.activity.started)
+	s := fmt.Sprintf("%s working %s", frame, elapsed.Truncate(time.Second))
+	if rate := tokensPerSecond(m.activity.tokens, elapsed); rate > 0 {
+		s += fmt.Sprintf(", ~%.0f tok/s", rate)
+	}
+	if idle := now.Sub(m.activity.lastEvent); idle >= activityIdleThreshold {
+		s += fmt.Sprintf(", no output for %s", idle.Truncate(time.Second))
+	}
+	return s + "..."
+}
+
+func estimateOutputTokens(messages []llm.Message) int {
+	estimator := llm.NewCharTokenEstimator()
+	var tokens int
+	for _, msg := range messages {
+		if msg.Role == llm.RoleAssistant {
+			tokens += estimator.EstimateTokens(msg)
+		}
+	}
+	return tokens
+}
+
+func tokensPerSecond(tokens int, elapsed time.Duration) float64 {
+	// the rate is too noisy to be useful during the first second
+	if tokens <= 0 || elapsed < time.Second {
+		return 0
+	}
+	return float64(tokens) / elapsed.Seconds()
+}
+
+// helper types ------------------------------------------------------------------------------------
+
+type turnActivity struct {
+	started   time.Time
+	lastEvent time.Time
+	baseline  int
+	tokens    int
+	frame     int
+}
@@ -0,0 +1,88 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tidwall/gjson"
+)
+
+// tools that modify the workspace or run commands need the user's approval before they are called
+var toolsRequiringApproval = []string{"bash", "fs_patch", "fs_replace", "fs_write"}
+
+type toolApprovalRequest struct {
+	name  string
+	args  string
+	reply chan bool
+}
+
+type toolApprovalMsg struct {
+	request toolApprovalRequest
+}
+
+var (
+	errToolCallDenied     = errors.New("the user denied this tool call")
+	errToolCallUnattended = errors.New("this tool call needs the user's approval, which is not available in a non-interactive run (started without -yolo)")
+)
+
+type toolApproval struct {
+	yolo       atomic.Bool
+	unattended atomic.Bool
+	required   []string
+	requests   chan toolApprovalRequest
+}
+
+func newToolApproval() *toolApproval {
+	return &toolApproval{requests: make(chan toolApprovalRequest)}
+}
+
+func (a *toolApproval) require(names ...string) {
+	a.required = append(a.required, names...)
+}
+
+func (a *toolApproval) approve(ctx context.Context, name, args string) error {
+	if a.yolo.Load() || !slices.Contains(toolsRequiringApproval, name) && !slices.Contains(a.required, name) {
+		return nil
+	}
+	// dry runs only preview a change, nothing is written
+	if gjson.Get(args, "dry_run").Bool() {
+		return nil
+	}
+	// nobody is around to answer in headless and batch runs
+	if a.unattended.Load() {
+		return errToolCallUnattended
+	}
+	// the reply is buffered so that answering a request whose turn was already cancelled never blocks
+	request := toolApprovalRequest{name: name, args: args, reply: make(chan bool, 1)}
+	select {
+	case a.requests <- request:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case approved := <-request.reply:
+		if !approved {
+			return errToolCallDenied
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func waitToolApprovalCmd(requests <-chan toolApprovalRequest) tea.Cmd {
+	return func() tea.Msg {
+		return toolApprovalMsg{request: <-requests}
+	}
+}
+
+func (m *Model) answerToolApproval(approved bool) tea.Cmd {
+	m.pendingApproval.reply <- approved
+	m.pendingApproval = nil
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+	return waitToolApprovalCmd(m.approval.requests)
+}
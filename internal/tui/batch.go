@@ -0,0 +1,133 @@
+package tui
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/markusylisiurunen/ikm/internal/agent"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+	"github.com/markusylisiurunen/ikm/toolkit/tool"
+)
+
+type batchResult struct {
+	Index    int           `json:"index"`
+	Prompt   string        `json:"prompt"`
+	Answer   string        `json:"answer,omitzero"`
+	Error    string        `json:"error,omitzero"`
+	Usage    llm.Usage     `json:"usage"`
+	Messages []llm.Message `json:"messages"`
+}
+
+type BatchReport struct {
+	Prompts   int       `json:"prompts"`
+	Completed int       `json:"completed"`
+	Failed    int       `json:"failed"`
+	Skipped   int       `json:"skipped"`
+	Usage     llm.Usage `json:"usage"`
+}
+
+func (m Model) RunBatch(ctx context.Context, prompts []string, outDir string, maxCost float64) (BatchReport, error) {
+	report := BatchReport{Prompts: len(prompts)}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return report, fmt.Errorf("error creating output folder: %w", err)
+	}
+	// the interactive subscription is not consumed in batch mode and would block the agent
+	m.unsubscribe()
+	// nobody is around to approve tool calls in batch mode, so they are denied unless -yolo was passed
+	m.approval.unattended.Store(true)
+	for i, prompt := range prompts {
+		if ctx.Err() != nil {
+			report.Skipped += len(prompts) - i
+			break
+		}
+		if maxCost > 0 && report.Usage.TotalCost >= maxCost {
+			m.logger.Errorf("batch cost budget of %.4f € exhausted, skipping %d prompts", maxCost, len(prompts)-i)
+			report.Skipped += len(prompts) - i
+			break
+		}
+		result := m.runBatchPrompt(ctx, i+1, prompt)
+		report.Usage.PromptTokens += result.Usage.PromptTokens
+		report.Usage.CompletionTokens += result.Usage.CompletionTokens
+		report.Usage.TotalCost += result.Usage.TotalCost
+		if result.Error != "" {
+			report.Failed++
+		} else {
+			report.Completed++
+		}
+		if err := writeBatchResult(outDir, result); err != nil {
+			return report, err
+		}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return report, fmt.Errorf("error marshalling batch report: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "report.json"), data, 0644); err != nil {
+		return report, fmt.Errorf("error writing batch report: %w", err)
+	}
+	return report, nil
+}
+
+func (m Model) runBatchPrompt(ctx context.Context, index int, prompt string) batchResult {
+	// every prompt runs as an independent conversation
+	if err := m.agent.Reset(); err != nil {
+		m.logger.Errorf("batch prompt %d failed: %v", index, err)
+		return batchResult{Index: index, Prompt: prompt, Error: err.Error()}
+	}
+	tool.ClearTodoList()
+	subscription, unsubscribe := m.agent.Subscribe()
+	var errs []error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range subscription {
+			switch e := event.(type) {
+			case *agent.ErrorEvent:
+				errs = append(errs, e.Err)
+			case *agent.CompactionEvent:
+				if e.Err != nil {
+					errs = append(errs, fmt.Errorf("failed to compact the conversation: %w", e.Err))
+				}
+			}
+		}
+	}()
+	m.agent.Run(ctx, prompt)
+	unsubscribe()
+	<-done
+	messages, usage := m.agent.GetHistoryState()
+	result := batchResult{Index: index, Prompt: prompt, Usage: usage, Messages: messages}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == llm.RoleAssistant && len(messages[i].ToolCalls) == 0 {
+			result.Answer = messages[i].Content.Text()
+			break
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		m.logger.Errorf("batch prompt %d failed: %v", index, err)
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func writeBatchResult(outDir string, result batchResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling batch result: %w", err)
+	}
+	name := fmt.Sprintf("%03d", result.Index)
+	if err := os.WriteFile(filepath.Join(outDir, name+".json"), data, 0644); err != nil {
+		return fmt.Errorf("error writing batch result: %w", err)
+	}
+	markdown := fmt.Sprintf("# Prompt\n\n%s\n\n# Answer\n\n%s\n", result.Prompt, result.Answer)
+	if result.Error != "" {
+		markdown += fmt.Sprintf("\n# Error\n\n%s\n", result.Error)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, name+".md"), []byte(markdown), 0644); err != nil {
+		return fmt.Errorf("error writing batch result: %w", err)
+	}
+	return nil
+}
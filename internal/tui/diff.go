@@ -0,0 +1,80 @@
+package tui
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const sessionDiffTimeout = 10 * time.Second
+
+func getSessionDiff(files []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sessionDiffTimeout)
+	defer cancel()
+	// the changes are compared to the last commit, a repository without commits only has the working tree changes
+	diff, err := runGit(ctx, append([]string{"diff", "--no-color", "HEAD", "--"}, files...)...)
+	if err != nil {
+		diff, err = runGit(ctx, append([]string{"diff", "--no-color", "--"}, files...)...)
+		if err != nil {
+			return "", err
+		}
+	}
+	// new files are not known to git yet, they are diffed against an empty file instead
+	untracked, err := runGit(ctx, append([]string{"ls-files", "--others", "--exclude-standard", "--"}, files...)...)
+	if err != nil {
+		return "", err
+	}
+	for file := range strings.SplitSeq(strings.TrimSpace(untracked), "\n") {
+		if file == "" {
+			continue
+		}
+		fileDiff, err := runGit(ctx, "diff", "--no-color", "--no-index", "--", "/dev/null", file)
+		if err != nil {
+			return "", err
+		}
+		diff += fileDiff
+	}
+	return strings.TrimRight(diff, "\n"), nil
+}
+
+func runGit(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	// `git diff --no-index` exits with 1 when the files differ
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error running git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func colorizeDiff(diff string) string {
+	lines := strings.Split(diff, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			lines[i] = color.New(color.Bold).Sprint(line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = color.New(color.FgCyan).Sprint(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = color.New(color.FgGreen).Sprint(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = color.New(color.FgRed).Sprint(line)
+		default:
+			lines[i] = color.New(color.Faint).Sprint(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,163 @@
+package tui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+)
+
+type transcriptMessage_ToolCall struct {
+	FuncName string          `json:"func_name"`
+	FuncArgs json.RawMessage `json:"func_args"`
+}
+
+type transcriptMessage struct {
+	Role      string                       `json:"role"`
+	Text      string                       `json:"text,omitzero"`
+	Result    any                          `json:"result,omitzero"`
+	ToolCalls []transcriptMessage_ToolCall `json:"tool_calls,omitzero"`
+}
+
+type transcriptUsage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalCost        float64 `json:"total_cost"`
+}
+
+type transcriptExport struct {
+	Messages []transcriptMessage `json:"messages"`
+	Usage    transcriptUsage     `json:"usage"`
+}
+
+func buildTranscriptExport(messages []llm.Message, usage llm.Usage) transcriptExport {
+	return transcriptExport{
+		Messages: buildTranscript(messages),
+		Usage: transcriptUsage{
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalCost:        usage.TotalCost,
+		},
+	}
+}
+
+func buildTranscript(messages []llm.Message) []transcriptMessage {
+	var transcript []transcriptMessage
+	for _, msg := range messages {
+		switch msg.Role {
+		case llm.RoleSystem:
+			transcript = append(transcript, transcriptMessage{
+				Role: "system",
+				Text: msg.Content.Text(),
+			})
+		case llm.RoleAssistant:
+			var toolCalls []transcriptMessage_ToolCall
+			for _, call := range msg.ToolCalls {
+				toolCalls = append(toolCalls, transcriptMessage_ToolCall{
+					FuncName: call.Function.Name,
+					FuncArgs: json.RawMessage(call.Function.Args),
+				})
+			}
+			transcript = append(transcript, transcriptMessage{
+				Role:      "assistant",
+				Text:      msg.Content.Text(),
+				ToolCalls: toolCalls,
+			})
+		case llm.RoleTool:
+			var result any = msg.Content.Text()
+			if json.Valid([]byte(msg.Content.Text())) {
+				result = json.RawMessage(msg.Content.Text())
+			}
+			transcript = append(transcript, transcriptMessage{
+				Role:   "tool",
+				Result: result,
+			})
+		case llm.RoleUser:
+			transcript = append(transcript, transcriptMessage{
+				Role: "user",
+				Text: msg.Content.Text(),
+			})
+		}
+	}
+	return transcript
+}
+
+func renderTranscriptMarkdown(transcript []transcriptMessage) string {
+	var b strings.Builder
+	b.WriteString("# Conversation\n")
+	for _, msg := range transcript {
+		switch msg.Role {
+		case "system":
+			// the system prompt is long and the same for every conversation, so it is tucked away
+			b.WriteString("\n<details>\n<summary>System prompt</summary>\n\n")
+			b.WriteString(strings.TrimSpace(msg.Text) + "\n")
+			b.WriteString("\n</details>\n")
+		case "user":
+			b.WriteString("\n## User\n\n")
+			b.WriteString(strings.TrimSpace(msg.Text) + "\n")
+		case "assistant":
+			b.WriteString("\n## Assistant\n")
+			if text := strings.TrimSpace(msg.Text); text != "" {
+				b.WriteString("\n" + text + "\n")
+			}
+			for _, call := range msg.ToolCalls {
+				b.WriteString(fmt.Sprintf("\n**Tool call:** `%s`\n\n", call.FuncName))
+				b.WriteString(fencedMarkdown("json", formatTranscriptJSON(call.FuncArgs)))
+			}
+		case "tool":
+			b.WriteString("\n<details>\n<summary>Tool result</summary>\n\n")
+			switch result := msg.Result.(type) {
+			case json.RawMessage:
+				b.WriteString(fencedMarkdown("json", formatTranscriptJSON(result)))
+			case string:
+				b.WriteString(fencedMarkdown("", result))
+			}
+			b.WriteString("\n</details>\n")
+		}
+	}
+	return b.String()
+}
+
+func formatTranscriptJSON(data json.RawMessage) string {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return string(data)
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return string(data)
+	}
+	return string(b)
+}
+
+func fencedMarkdown(lang, content string) string {
+	// the fence must be longer than any backtick run in the content or the block would end early
+	longest, run := 0, 0
+	for _, r := range content {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", max(3, longest+1))
+	return fence + lang + "\n" + strings.TrimRight(content, "\n") + "\n" + fence + "\n"
+}
+
+func getExportPath(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("export file name is required")
+	}
+	path := filepath.Clean(args[0])
+	if filepath.IsAbs(path) || path == "." || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("export file must be inside the working directory: %s", args[0])
+	}
+	if filepath.Ext(path) == "" {
+		path += ".md"
+	}
+	return path, nil
+}
@@ -0,0 +1,73 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/markusylisiurunen/ikm/internal/agent"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+)
+
+func (m Model) RunPrompt(ctx context.Context, prompt string, w io.Writer) (llm.Usage, error) {
+	// the interactive subscription is not consumed in headless mode and would block the agent
+	m.unsubscribe()
+	// nobody is around to approve tool calls in headless mode, so they are denied unless -yolo was passed
+	m.approval.unattended.Store(true)
+	subscription, unsubscribe := m.agent.Subscribe()
+	var (
+		errs    []error
+		printed string
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range subscription {
+			switch e := event.(type) {
+			case *agent.ErrorEvent:
+				errs = append(errs, e.Err)
+			case *agent.CompactionEvent:
+				if e.Err != nil {
+					errs = append(errs, fmt.Errorf("failed to compact the conversation: %w", e.Err))
+				}
+			case *agent.MaxTurnsReachedEvent:
+				errs = append(errs, fmt.Errorf("reached max tool-call turns (%d) before the answer was complete", e.MaxTurns))
+			case *agent.TruncatedEvent:
+				errs = append(errs, errors.New("response truncated (hit max tokens)"))
+			case *agent.CostBudgetExceededEvent:
+				errs = append(errs, fmt.Errorf("cost budget of %.3f € exceeded (spent %.3f €)", e.Budget, e.Cost))
+			case *agent.ChangeEvent:
+				// stream only the newly appended assistant text
+				messages, _ := m.agent.GetHistoryState()
+				text := getAssistantText(messages)
+				if strings.HasPrefix(text, printed) {
+					fmt.Fprint(w, text[len(printed):]) //nolint:errcheck
+					printed = text
+				}
+			}
+		}
+	}()
+	m.agent.Run(ctx, prompt)
+	unsubscribe()
+	<-done
+	if printed != "" && !strings.HasSuffix(printed, "\n") {
+		fmt.Fprintln(w) //nolint:errcheck
+	}
+	_, usage := m.agent.GetHistoryState()
+	return usage, errors.Join(errs...)
+}
+
+func getAssistantText(messages []llm.Message) string {
+	var parts []string
+	for _, msg := range messages {
+		if msg.Role != llm.RoleAssistant {
+			continue
+		}
+		if text := msg.Content.Text(); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
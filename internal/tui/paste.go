@@ -0,0 +1,38 @@
+package tui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/markusylisiurunen/ikm/internal/clipboard"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+	"github.com/markusylisiurunen/ikm/toolkit/tool"
+)
+
+type clipboardImageMsg struct {
+	part llm.ImageContentPart
+	err  error
+}
+
+func pasteImageCmd() tea.Cmd {
+	return func() tea.Msg {
+		data, err := clipboard.ReadImage()
+		if err != nil {
+			return clipboardImageMsg{err: err}
+		}
+		// the clipboard tools are asked for PNG data, whatever the original format was
+		part, err := tool.NewImageContentPart(data)
+		return clipboardImageMsg{part: part, err: err}
+	}
+}
+
+func renderUserContent(content llm.ContentParts) string {
+	var s string
+	for _, part := range content {
+		switch p := part.(type) {
+		case llm.TextContentPart:
+			s += p.Text
+		case llm.ImageContentPart:
+			s += " [image attached]"
+		}
+	}
+	return s
+}
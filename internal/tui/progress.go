@@ -0,0 +1,47 @@
+package tui
+
+import (
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// progress updates are coalesced so that a fast stream does not re-render the view on every delta
+const toolProgressRenderInterval = 250 * time.Millisecond
+
+type toolProgressMsg struct{}
+
+type toolProgress struct {
+	mux       sync.Mutex
+	generated map[string]int
+	updates   chan struct{}
+}
+
+func newToolProgress() *toolProgress {
+	return &toolProgress{generated: make(map[string]int), updates: make(chan struct{}, 1)}
+}
+
+func (p *toolProgress) report(toolCallID string, generated int) {
+	p.mux.Lock()
+	p.generated[toolCallID] = generated
+	p.mux.Unlock()
+	select {
+	case p.updates <- struct{}{}:
+	default:
+	}
+}
+
+func (p *toolProgress) get(toolCallID string) int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.generated[toolCallID]
+}
+
+func waitToolProgressCmd(updates <-chan struct{}) tea.Cmd {
+	return func() tea.Msg {
+		<-updates
+		time.Sleep(toolProgressRenderInterval)
+		return toolProgressMsg{}
+	}
+}
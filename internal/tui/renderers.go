@@ -0,0 +1,75 @@
+package tui
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+	"github.com/tidwall/gjson"
+)
+
+type toolRenderers struct {
+	mux       sync.Mutex
+	renderers map[string]llm.ToolRenderer
+}
+
+func newToolRenderers() *toolRenderers {
+	return &toolRenderers{renderers: make(map[string]llm.ToolRenderer)}
+}
+
+func (r *toolRenderers) add(t llm.Tool) {
+	renderer, ok := t.(llm.ToolRenderer)
+	if !ok {
+		return
+	}
+	name, _, _ := t.Spec()
+	r.mux.Lock()
+	r.renderers[name] = renderer
+	r.mux.Unlock()
+}
+
+func (r *toolRenderers) get(name string) (llm.ToolRenderer, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	renderer, ok := r.renderers[name]
+	return renderer, ok
+}
+
+func (m Model) renderToolWithRenderer(renderer llm.ToolRenderer, args string) string {
+	// the tool decides what to show, the lines are indented and truncated like the built-in fields
+	rendered := strings.TrimRight(renderer.Render(args, max(m.getRenderWidth()-2, 0)), "\n")
+	if rendered == "" {
+		return ""
+	}
+	var lines []string
+	for line := range strings.SplitSeq(rendered, "\n") {
+		lines = append(lines, color.New(color.Faint).Sprint(m.truncateLine("  "+line)))
+	}
+	return "\n" + strings.Join(lines, "\n")
+}
+
+func (m Model) renderToolArgs(args string) string {
+	// tools without a renderer of their own get their top-level arguments listed in the order they were given
+	if !gjson.Valid(args) {
+		return ""
+	}
+	var parts []string
+	gjson.Parse(args).ForEach(func(key, value gjson.Result) bool {
+		text := value.String()
+		if value.Type == gjson.JSON {
+			text = value.Raw
+		}
+		if first, _, multiline := strings.Cut(text, "\n"); multiline {
+			text = first + " ..."
+		}
+		if text != "" {
+			parts = append(parts, m.renderToolField(strings.ReplaceAll(key.String(), "_", " "), text))
+		}
+		return true
+	})
+	if len(parts) == 0 {
+		return ""
+	}
+	return "\n" + strings.Join(parts, "\n")
+}
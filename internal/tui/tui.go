@@ -6,9 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"time"
 
 	"slices"
 
@@ -19,15 +24,31 @@ import (
 	"github.com/markusylisiurunen/glamour"
 	"github.com/markusylisiurunen/glamour/styles"
 	"github.com/markusylisiurunen/ikm/internal/agent"
+	"github.com/markusylisiurunen/ikm/internal/clipboard"
 	"github.com/markusylisiurunen/ikm/internal/logger"
 	"github.com/markusylisiurunen/ikm/toolkit/llm"
 	"github.com/markusylisiurunen/ikm/toolkit/tool"
 	"github.com/tidwall/gjson"
 )
 
+const (
+	minRenderWidth = 20
+	maxRenderWidth = 500
+	// how long quitting waits for a cancelled turn to wind down, e.g. for a file write to finish
+	shutdownGracePeriod = 3 * time.Second
+	// the llm tool and the task sub-agents mostly use fast models, a request hanging for minutes is not worth waiting for
+	llmToolHTTPTimeout  = 120 * time.Second
+	taskToolHTTPTimeout = 180 * time.Second
+)
+
 type agentMsg struct {
-	err  error
-	done bool
+	err        error
+	compaction *agent.CompactionEvent
+	maxTurns   int
+	costBudget float64
+	cost       float64
+	truncated  bool
+	done       bool
 }
 
 func waitAgentCmd(subscription <-chan agent.Event) tea.Cmd {
@@ -39,12 +60,38 @@ func waitAgentCmd(subscription <-chan agent.Event) tea.Cmd {
 		switch event := event.(type) {
 		case *agent.ErrorEvent:
 			return agentMsg{err: event.Err}
+		case *agent.MaxTurnsReachedEvent:
+			return agentMsg{maxTurns: event.MaxTurns}
+		case *agent.CostBudgetExceededEvent:
+			return agentMsg{costBudget: event.Budget, cost: event.Cost}
+		case *agent.TruncatedEvent:
+			return agentMsg{truncated: true}
+		case *agent.CompactionEvent:
+			return agentMsg{compaction: event}
 		default:
 			return agentMsg{}
 		}
 	}
 }
 
+type shutdownMsg struct{}
+
+func shutdownCmd(a *agent.Agent) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+		defer cancel()
+		_ = a.Wait(ctx)
+		return shutdownMsg{}
+	}
+}
+
+// the instructions of the working directory, the ones closest to it take precedence over the rest
+const instructionsPath = ".ikm/instructions.md"
+
+type systemEditedMsg struct {
+	err error
+}
+
 type model_Mode struct {
 	name   string
 	system func() string
@@ -54,28 +101,67 @@ type Model struct {
 	logger          logger.Logger
 	runInBashDocker func(context.Context, string) (int, string, string, error)
 
-	anthropicKey  string
-	openRouterKey string
-	openAIKey     string
+	anthropicKey     string
+	openRouterKey    string
+	openAIKey        string
+	mistralKey       string
+	geminiKey        string
+	anthropicBaseURL string
+	openAIBaseURL    string
+	ollamaBaseURL    string
+	openAISummary    string
 
-	model string
+	pricing llm.PricingTable
+
+	model            string
+	openRouterModels []llm.OpenRouterModel
 
 	fastButCapableModel    string
 	thoroughButCostlyModel string
 
-	viewport  viewport.Model
-	textinput textinput.Model
+	viewport    viewport.Model
+	textinput   textinput.Model
+	renderWidth int
+	verbose     bool
+	thinking    bool
+	placeholder string
+	banner      bool
 
 	mode            model_Mode
 	modes           []model_Mode
 	disabledTools   []string
+	mcpTools        []llm.Tool
+	logsTool        bool
+	bashTimeout     time.Duration
+	bashWritableDir string
+	bashNetworkCmds []string
+	bashAllow       []*regexp.Regexp
+	bashDeny        []*regexp.Regexp
+	testCommand     string
+	testTimeout     time.Duration
+	webAllowedHosts []string
+	webDeniedHosts  []string
+	fsRoots         []string
 	reasoningEffort uint8
+	agentOptions    []agent.Option
 	agent           *agent.Agent
 	subscription    <-chan agent.Event
 	unsubscribe     func()
+	approval        *toolApproval
+	progress        *toolProgress
+	renderers       *toolRenderers
+	pendingApproval *toolApprovalRequest
+	attachments     []llm.ContentPart
+	sessionName     string
+	activity        turnActivity
 
 	cancelFunc context.CancelFunc
 	errorMsg   string
+	refusalMsg string
+	infoMsg    string
+	diffMsg    string
+	canceled   bool
+	quitting   bool
 }
 
 type modelOption func(*Model)
@@ -111,6 +197,10 @@ func WithSetDefaultMode(name string) modelOption {
 
 func WithSetDefaultModel(model string) modelOption {
 	return func(m *Model) {
+		if isOllamaModel(model) {
+			m.model = model
+			return
+		}
 		for _, id := range m.listModels() {
 			if m.getModelSlug(id) == model {
 				m.model = id
@@ -126,12 +216,138 @@ func WithDisabledTools(tools []string) modelOption {
 	}
 }
 
+func WithMCPTools(tools []llm.Tool) modelOption {
+	return func(m *Model) {
+		m.mcpTools = append(m.mcpTools, tools...)
+	}
+}
+
+func WithLogsTool(enabled bool) modelOption {
+	return func(m *Model) {
+		m.logsTool = enabled
+	}
+}
+
+func WithBashTimeout(timeout time.Duration) modelOption {
+	return func(m *Model) {
+		m.bashTimeout = timeout
+	}
+}
+
+func WithBashSandbox(writableDir string, networkCmds []string) modelOption {
+	return func(m *Model) {
+		m.bashWritableDir = writableDir
+		m.bashNetworkCmds = networkCmds
+	}
+}
+
+func WithBashPolicy(allow, deny []*regexp.Regexp) modelOption {
+	return func(m *Model) {
+		m.bashAllow = allow
+		m.bashDeny = deny
+	}
+}
+
+func WithTestCommand(command string, timeout time.Duration) modelOption {
+	return func(m *Model) {
+		m.testCommand = command
+		m.testTimeout = timeout
+	}
+}
+
+func WithFSRoots(roots []string) modelOption {
+	return func(m *Model) {
+		m.fsRoots = roots
+	}
+}
+
+func WithWebHosts(allowed, denied []string) modelOption {
+	return func(m *Model) {
+		m.webAllowedHosts = allowed
+		m.webDeniedHosts = denied
+	}
+}
+
+func WithPlaceholder(placeholder string) modelOption {
+	return func(m *Model) {
+		if placeholder != "" {
+			m.placeholder = placeholder
+		}
+	}
+}
+
+func WithBanner(banner bool) modelOption {
+	return func(m *Model) {
+		m.banner = banner
+	}
+}
+
+func WithYolo(yolo bool) modelOption {
+	return func(m *Model) {
+		m.approval.yolo.Store(yolo)
+	}
+}
+
 func WithReasoningEffort(effort uint8) modelOption {
 	return func(m *Model) {
 		m.reasoningEffort = effort
 	}
 }
 
+func WithGeminiKey(key string) modelOption {
+	return func(m *Model) {
+		m.geminiKey = key
+	}
+}
+
+func WithMistralKey(key string) modelOption {
+	return func(m *Model) {
+		m.mistralKey = key
+	}
+}
+
+func WithAnthropicBaseURL(baseURL string) modelOption {
+	return func(m *Model) {
+		m.anthropicBaseURL = baseURL
+	}
+}
+
+func WithOpenAIBaseURL(baseURL string) modelOption {
+	return func(m *Model) {
+		m.openAIBaseURL = baseURL
+	}
+}
+
+func WithOllamaBaseURL(baseURL string) modelOption {
+	return func(m *Model) {
+		m.ollamaBaseURL = baseURL
+	}
+}
+
+func WithOpenAIReasoningSummary(summary string) modelOption {
+	return func(m *Model) {
+		m.openAISummary = summary
+	}
+}
+
+func WithPricingTable(pricing llm.PricingTable) modelOption {
+	return func(m *Model) {
+		m.pricing = pricing
+	}
+}
+
+func WithOpenRouterModels(models []llm.OpenRouterModel) modelOption {
+	return func(m *Model) {
+		m.openRouterModels = models
+	}
+}
+
+func WithAgentOptions(opts ...agent.Option) modelOption {
+	return func(m *Model) {
+		m.agentOptions = append(m.agentOptions, opts...)
+	}
+}
+
 func Initial(
 	logger logger.Logger,
 	anthropicKey string,
@@ -147,10 +363,19 @@ func Initial(
 		openRouterKey:   openRouterKey,
 		openAIKey:       openAIKey,
 		reasoningEffort: 2, // default to medium effort
+		placeholder:     "ask anything",
+		approval:        newToolApproval(),
+		progress:        newToolProgress(),
+		renderers:       newToolRenderers(),
 	}
 	for _, opt := range opts {
 		opt(&m)
 	}
+	// the MCP tools are provided by third-party servers and may do anything, so they always require approval
+	for _, t := range m.mcpTools {
+		name, _, _ := t.Spec()
+		m.approval.require(name)
+	}
 	if m.mode.name == "" || len(m.modes) == 0 {
 		panic("no modes defined or default mode not set")
 	}
@@ -161,10 +386,14 @@ func Initial(
 	m.fastButCapableModel = "google/gemini-2.5-flash"
 	m.thoroughButCostlyModel = "anthropic/claude-sonnet-4"
 	// init the agent
-	m.agent = agent.New(logger, []llm.Tool{})
+	compactionModel := llm.NewOpenRouter(logger, m.openRouterKey, m.fastButCapableModel)
+	m.agent = agent.New(logger, []llm.Tool{},
+		append([]agent.Option{agent.WithCompactionModel(compactionModel)}, m.agentOptions...)...)
 	if err := m.configureModel(m.model); err != nil {
 		m.logger.Errorf("failed to configure model %s: %v", m.model, err)
 		m.errorMsg = fmt.Sprintf("failed to configure model %s: %v", m.model, err)
+	} else if !m.modelSupportsTools(m.model) {
+		m.infoMsg = fmt.Sprintf("%s does not support tools, it is used without them.", m.getModelSlug(m.model))
 	}
 	m.agent.SetSystem(m.mode.system)
 	m.subscription, m.unsubscribe = m.agent.Subscribe()
@@ -180,7 +409,7 @@ func Initial(
 	// init the textinput
 	ti := textinput.New()
 	ti.Prompt = "\u276F "
-	ti.Placeholder = "ask anything"
+	ti.Placeholder = m.placeholder
 	ti.Focus()
 	ti.CharLimit = 4096
 	m.textinput = ti
@@ -192,7 +421,11 @@ func (m Model) isToolDisabled(toolName string) bool {
 }
 
 func (m Model) Init() tea.Cmd {
-	return tea.Batch(waitAgentCmd(m.subscription))
+	return tea.Batch(
+		waitAgentCmd(m.subscription),
+		waitToolApprovalCmd(m.approval.requests),
+		waitToolProgressCmd(m.progress.updates),
+	)
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -200,46 +433,197 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.done {
 			return m, nil
 		}
+		tick := m.updateActivity(time.Now())
 		if msg.err != nil {
-			if !errors.Is(msg.err, context.Canceled) {
+			var refusalErr *llm.RefusalError
+			if errors.As(msg.err, &refusalErr) {
+				m.refusalMsg = refusalErr.Message
+				if m.refusalMsg == "" {
+					m.refusalMsg = fmt.Sprintf("stop reason: %s", refusalErr.Reason)
+				}
+			} else if errors.Is(msg.err, context.Canceled) {
+				// the user stopped the turn, so it is not reported as an error
+				m.canceled = true
+			} else {
 				m.logger.Errorf(msg.err.Error())
 				m.errorMsg = msg.err.Error()
 			}
 			m.viewport.SetContent(m.renderContent())
 			m.viewport.GotoBottom()
-			return m, waitAgentCmd(m.subscription)
+			return m, tea.Batch(waitAgentCmd(m.subscription), tick)
+		}
+		if msg.compaction != nil {
+			switch {
+			case errors.Is(msg.compaction.Err, context.Canceled):
+				m.infoMsg = ""
+				m.canceled = true
+			case msg.compaction.Err != nil:
+				m.infoMsg = ""
+				m.errorMsg = fmt.Sprintf("failed to compact the conversation: %v", msg.compaction.Err)
+			default:
+				m.infoMsg = fmt.Sprintf("compacted %d messages into a summary.", msg.compaction.Messages)
+			}
+			m.viewport.SetContent(m.renderContent())
+			m.viewport.GotoBottom()
+			return m, tea.Batch(waitAgentCmd(m.subscription), tick)
+		}
+		if msg.maxTurns > 0 {
+			m.infoMsg = fmt.Sprintf("reached max tool-call turns (%d), send a message to let the agent continue", msg.maxTurns)
+			m.viewport.SetContent(m.renderContent())
+			m.viewport.GotoBottom()
+			return m, tea.Batch(waitAgentCmd(m.subscription), tick)
+		}
+		if msg.truncated {
+			m.infoMsg = "response truncated (hit max tokens), use /continue to let the model carry on"
+			m.viewport.SetContent(m.renderContent())
+			m.viewport.GotoBottom()
+			return m, tea.Batch(waitAgentCmd(m.subscription), tick)
+		}
+		if msg.costBudget > 0 {
+			m.errorMsg = fmt.Sprintf("cost budget of %.3f € exceeded (spent %.3f €), the agent was stopped", msg.costBudget, msg.cost)
+			m.viewport.SetContent(m.renderContent())
+			m.viewport.GotoBottom()
+			return m, tea.Batch(waitAgentCmd(m.subscription), tick)
 		}
 		atBottom := m.viewport.AtBottom()
 		m.viewport.SetContent(m.renderContent())
 		if atBottom {
 			m.viewport.GotoBottom()
 		}
-		return m, waitAgentCmd(m.subscription)
+		return m, tea.Batch(waitAgentCmd(m.subscription), tick)
 	}
 	switch msg := msg.(type) {
+	case systemEditedMsg:
+		if msg.err != nil {
+			m.logger.Errorf("failed to edit the instructions: %v", msg.err)
+			m.errorMsg = fmt.Sprintf("failed to edit the instructions: %v", msg.err)
+			m.viewport.SetContent(m.renderContent())
+			m.viewport.GotoBottom()
+			return m, nil
+		}
+		m.agent.SetSystem(m.mode.system)
+		m.showSystemPrompt()
+		return m, nil
+	case activityTickMsg:
+		// ticks of an earlier turn stop here, each turn runs its own
+		if !msg.started.Equal(m.activity.started) {
+			return m, nil
+		}
+		// the agent does not report the end of a turn, so the ticks are what notice it
+		if !m.agent.GetIsRunning() {
+			m.activity = turnActivity{}
+			return m, nil
+		}
+		m.activity.frame++
+		return m, activityTickCmd(msg.started)
+	case toolProgressMsg:
+		if !m.activity.started.IsZero() {
+			m.activity.lastEvent = time.Now()
+		}
+		atBottom := m.viewport.AtBottom()
+		m.viewport.SetContent(m.renderContent())
+		if atBottom {
+			m.viewport.GotoBottom()
+		}
+		return m, waitToolProgressCmd(m.progress.updates)
+	case clipboardImageMsg:
+		if errors.Is(msg.err, clipboard.ErrNoImage) {
+			// plain text pastes end up here, the text input has already taken care of them
+			return m, nil
+		}
+		if errors.Is(msg.err, clipboard.ErrNoClipboard) {
+			// without a tool to read images (e.g. on Windows) every paste is a plain text paste
+			m.logger.Debugf("skipped reading an image from the clipboard: %v", msg.err)
+			return m, nil
+		}
+		if msg.err != nil {
+			m.logger.Errorf("failed to paste image: %v", msg.err)
+			m.errorMsg = fmt.Sprintf("failed to paste image: %v", msg.err)
+		} else {
+			m.attachments = append(m.attachments, msg.part)
+		}
+		m.viewport.SetContent(m.renderContent())
+		m.viewport.GotoBottom()
+		return m, nil
+	case shutdownMsg:
+		return m.quit()
+	case toolApprovalMsg:
+		m.pendingApproval = &msg.request
+		m.viewport.SetContent(m.renderContent())
+		m.viewport.GotoBottom()
+		return m, nil
 	case tea.KeyMsg:
+		if m.pendingApproval != nil && m.textinput.Value() == "" && msg.Type == tea.KeyRunes {
+			switch string(msg.Runes) {
+			case "y":
+				return m, m.answerToolApproval(true)
+			case "n":
+				return m, m.answerToolApproval(false)
+			case "a":
+				m.approval.yolo.Store(true)
+				m.infoMsg = "yolo mode on: tool calls run without approval."
+				return m, m.answerToolApproval(true)
+			}
+		}
 		if msg.Type == tea.KeyCtrlC {
-			if m.unsubscribe != nil {
-				m.unsubscribe()
+			// a second ctrl+c quits without waiting for the turn to wind down
+			if m.quitting || !m.agent.GetIsRunning() {
+				return m.quit()
+			}
+			m.quitting = true
+			if m.cancelFunc != nil {
+				m.cancelFunc()
+				m.cancelFunc = nil
+			}
+			m.infoMsg = "quitting once the current turn has stopped, ctrl+c again to quit now."
+			m.viewport.SetContent(m.renderContent())
+			m.viewport.GotoBottom()
+			var cmd tea.Cmd
+			if m.pendingApproval != nil {
+				cmd = m.answerToolApproval(false)
 			}
-			return m, tea.Quit
+			return m, tea.Batch(cmd, shutdownCmd(m.agent))
+		}
+		if msg.Type == tea.KeyCtrlV {
+			var cmd tea.Cmd
+			m.textinput, cmd = m.textinput.Update(msg)
+			return m, tea.Batch(cmd, pasteImageCmd())
 		}
 		if msg.Type == tea.KeyEsc {
+			if !m.agent.GetIsRunning() && len(m.attachments) > 0 {
+				m.attachments = nil
+				return m, nil
+			}
 			if m.agent.GetIsRunning() && m.cancelFunc != nil {
 				m.cancelFunc()
 				m.cancelFunc = nil
+				m.canceled = true
+				m.viewport.SetContent(m.renderContent())
+				m.viewport.GotoBottom()
+				if m.pendingApproval != nil {
+					return m, m.answerToolApproval(false)
+				}
 				return m, nil
 			}
 		}
 		if msg.Type == tea.KeyEnter {
 			if strings.HasPrefix(m.textinput.Value(), "/") {
-				m.handleSlashCommand()
-				return m, nil
+				return m, m.handleSlashCommand()
 			}
 			m.errorMsg = ""
+			m.refusalMsg = ""
+			m.canceled = false
+			m.infoMsg = ""
+			m.diffMsg = ""
 			ctx, cancel := context.WithCancel(context.Background())
 			m.cancelFunc = cancel
-			m.agent.Send(ctx, m.textinput.Value())
+			if len(m.attachments) > 0 {
+				content := append(llm.ContentParts{llm.NewTextContentPart(m.textinput.Value())}, m.attachments...)
+				m.agent.SendContent(ctx, content)
+				m.attachments = nil
+			} else {
+				m.agent.Send(ctx, m.textinput.Value())
+			}
 			m.textinput.Reset()
 			return m, nil
 		}
@@ -267,27 +651,63 @@ func (m Model) View() string {
 	return s
 }
 
+func (m Model) quit() (tea.Model, tea.Cmd) {
+	if m.unsubscribe != nil {
+		m.unsubscribe()
+		m.unsubscribe = nil
+	}
+	return m, tea.Quit
+}
+
 func (m Model) renderContent() string {
 	var s string
 	messages, _ := m.agent.GetHistoryState()
+	toolResults := make(map[string]llm.Message)
+	if m.verbose {
+		for _, msg := range messages {
+			if msg.Role == llm.RoleTool {
+				toolResults[msg.ToolCallID] = msg
+			}
+		}
+	}
+	// the banner is shown until the conversation starts
+	if m.banner && !slices.ContainsFunc(messages, func(msg llm.Message) bool { return msg.Role != llm.RoleSystem }) {
+		s += m.renderBanner()
+	}
 	for i, msg := range messages {
 		if msg.Role == llm.RoleUser {
 			if i > 0 {
 				s += "\n\n"
 			}
-			content := wrapWithPrefix("\u203A "+msg.Content.Text(), "", m.viewport.Width)
+			content := wrapWithPrefix("\u203A "+renderUserContent(msg.Content), "", m.getRenderWidth())
 			s += color.New(color.Faint).Sprint(strings.TrimSpace(content))
 		}
 		if msg.Role == llm.RoleAssistant {
 			if i > 0 {
 				s += "\n\n"
 			}
+			var thinking string
+			if m.thinking {
+				for _, part := range msg.Content {
+					if p, ok := part.(llm.ThinkingContentPart); ok && strings.TrimSpace(p.Thinking) != "" {
+						thinking += strings.TrimSpace(p.Thinking) + "\n\n"
+					}
+				}
+				thinking = strings.TrimSpace(thinking)
+				if thinking != "" {
+					wrapped := wrapWithPrefix(thinking, "", m.getRenderWidth())
+					s += color.New(color.Faint, color.Italic).Sprint(wrapped)
+				}
+			}
 			content := msg.Content.Text()
 			if content != "" {
+				if thinking != "" {
+					s += "\n\n"
+				}
 				s += m.renderMarkdown(content)
 			}
 			for idx, call := range msg.ToolCalls {
-				if content != "" || idx > 0 {
+				if content != "" || thinking != "" || idx > 0 {
 					s += "\n\n"
 				}
 				var circleColor *color.Color
@@ -297,40 +717,76 @@ func (m Model) renderContent() string {
 					circleColor = color.New(color.FgGreen)
 				}
 				s += circleColor.Sprint("\u25CF") + color.New(color.Bold).Sprintf(" %s", call.Function.Name)
-				switch call.Function.Name {
-				case "bash":
-					s += m.renderToolBash(call.Function.Args)
-				case "fs_list":
-					s += m.renderToolFSList(call.Function.Args)
-				case "fs_read":
-					s += m.renderToolFSRead(call.Function.Args)
-				case "fs_replace":
-					s += m.renderToolFSReplace(call.Function.Args)
-				case "fs_write":
-					s += m.renderToolFSWrite(call.Function.Args)
-				case "llm":
-					s += m.renderToolLLM(call.Function.Args)
-				case "task":
-					s += m.renderToolTask(call.Function.Args)
-				case "think":
-					s += m.renderToolThink(call.Function.Args)
-				case "todo_read":
-					s += m.renderToolTodoRead(call.Function.Args)
-				case "todo_write":
-					s += m.renderToolTodoWrite(call.Function.Args)
+				if renderer, ok := m.renderers.get(call.Function.Name); ok {
+					s += m.renderToolWithRenderer(renderer, call.Function.Args)
+				} else {
+					s += m.renderToolCall(call)
+				}
+				if m.agent.IsToolCallInFlight(call.ID) {
+					if generated := m.progress.get(call.ID); generated > 0 {
+						s += color.New(color.Faint).Sprintf("\n    generated %d characters so far", generated)
+					}
+				}
+				if result, ok := toolResults[call.ID]; ok {
+					s += m.renderToolResult(call, result.Content.Text())
 				}
 			}
 		}
 	}
+	if m.canceled {
+		if s != "" {
+			s += "\n\n"
+		}
+		s += color.New(color.Faint).Sprint("canceled")
+	}
+	if m.pendingApproval != nil {
+		if s != "" {
+			s += "\n\n"
+		}
+		s += m.renderToolApproval(*m.pendingApproval)
+	}
 	if m.errorMsg != "" {
 		if s != "" {
 			s += "\n\n"
 		}
 		s += m.renderError(m.errorMsg)
 	}
+	if m.refusalMsg != "" {
+		if s != "" {
+			s += "\n\n"
+		}
+		s += m.renderRefusal(m.refusalMsg)
+	}
+	if m.infoMsg != "" {
+		if s != "" {
+			s += "\n\n"
+		}
+		s += color.New(color.Faint).Sprint(m.infoMsg)
+	}
+	if m.diffMsg != "" {
+		if s != "" {
+			s += "\n\n"
+		}
+		s += m.diffMsg
+	}
 	return s
 }
 
+func (m Model) renderBanner() string {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	return color.New(color.Faint).Sprintf("ikm %s, %s, %s mode", version, m.model, m.mode.name)
+}
+
+func (m Model) getRenderWidth() int {
+	if m.renderWidth > 0 && m.renderWidth < m.viewport.Width {
+		return m.renderWidth
+	}
+	return m.viewport.Width
+}
+
 func (m Model) renderMarkdown(content string) string {
 	var margin uint = 0
 	dark := styles.DarkStyleConfig
@@ -342,13 +798,34 @@ func (m Model) renderMarkdown(content string) string {
 	dark.Code.Suffix = ""
 	renderer, _ := glamour.NewTermRenderer(
 		glamour.WithStyles(dark),
-		glamour.WithWordWrap(m.viewport.Width),
+		glamour.WithWordWrap(m.getRenderWidth()),
 	)
 	markdown, _ := renderer.Render(strings.TrimSpace(content))
 	return strings.TrimSpace(markdown)
 }
 
 func (m Model) renderError(errorMsg string) string {
+	return m.renderBox(errorMsg, color.FgRed)
+}
+
+func (m Model) renderRefusal(refusalMsg string) string {
+	return m.renderBox("The model declined to answer.\n\n"+refusalMsg, color.FgYellow)
+}
+
+func (m Model) renderToolApproval(request toolApprovalRequest) string {
+	var target string
+	switch request.name {
+	case "bash":
+		target = gjson.Get(request.args, "command").String()
+	case "fs_patch", "fs_replace", "fs_write":
+		target = gjson.Get(request.args, "path").String()
+	default:
+		target = request.args
+	}
+	return m.renderBox(fmt.Sprintf("Allow %s?\n\n%s\n\n[y] allow  [n] deny  [a] allow all (yolo)", request.name, target), color.FgYellow)
+}
+
+func (m Model) renderBox(text string, attr color.Attribute) string {
 	const (
 		borderBottomLeft  = "┗"
 		borderBottomRight = "┛"
@@ -359,31 +836,31 @@ func (m Model) renderError(errorMsg string) string {
 		padding           = 2
 	)
 	// calculate usable width
-	maxContentWidth := max(m.viewport.Width-2*padding-2, 10)
-	wrappedLines := strings.Split(wrapWithPrefix(errorMsg, "", maxContentWidth), "\n")
+	maxContentWidth := max(m.getRenderWidth()-2*padding-2, 10)
+	wrappedLines := strings.Split(wrapWithPrefix(text, "", maxContentWidth), "\n")
 	var result strings.Builder
-	boxWidth := m.viewport.Width - 2
+	boxWidth := m.getRenderWidth() - 2
 	// top border
-	result.WriteString(color.New(color.FgRed, color.Bold).Sprint(borderTopLeft))
-	result.WriteString(color.New(color.FgRed, color.Bold).Sprint(strings.Repeat(borderHorizontal, boxWidth)))
-	result.WriteString(color.New(color.FgRed, color.Bold).Sprintf(borderTopRight))
+	result.WriteString(color.New(attr, color.Bold).Sprint(borderTopLeft))
+	result.WriteString(color.New(attr, color.Bold).Sprint(strings.Repeat(borderHorizontal, boxWidth)))
+	result.WriteString(color.New(attr, color.Bold).Sprintf(borderTopRight))
 	result.WriteString("\n")
 	// content lines
 	for _, line := range wrappedLines {
-		result.WriteString(color.New(color.FgRed, color.Bold).Sprint(borderVertical + " "))
-		result.WriteString(color.New(color.FgRed).Sprint(line))
+		result.WriteString(color.New(attr, color.Bold).Sprint(borderVertical + " "))
+		result.WriteString(color.New(attr).Sprint(line))
 		// add padding to align right border
 		paddingSize := boxWidth - len(line) - 2
 		if paddingSize > 0 {
 			result.WriteString(strings.Repeat(" ", paddingSize))
 		}
-		result.WriteString(color.New(color.FgRed, color.Bold).Sprint(" " + borderVertical))
+		result.WriteString(color.New(attr, color.Bold).Sprint(" " + borderVertical))
 		result.WriteString("\n")
 	}
 	// bottom border
-	result.WriteString(color.New(color.FgRed, color.Bold).Sprint(borderBottomLeft))
-	result.WriteString(color.New(color.FgRed, color.Bold).Sprint(strings.Repeat(borderHorizontal, boxWidth)))
-	result.WriteString(color.New(color.FgRed, color.Bold).Sprint(borderBottomRight))
+	result.WriteString(color.New(attr, color.Bold).Sprint(borderBottomLeft))
+	result.WriteString(color.New(attr, color.Bold).Sprint(strings.Repeat(borderHorizontal, boxWidth)))
+	result.WriteString(color.New(attr, color.Bold).Sprint(borderBottomRight))
 	return result.String()
 }
 
@@ -392,16 +869,7 @@ func (m Model) renderToolField(key, value string) string {
 		return ""
 	}
 	line := "  " + key + ": " + value
-	maxWidth := m.viewport.Width
-	if len(line) <= maxWidth {
-		return color.New(color.Faint).Sprint(line)
-	}
-	const ellipsis = "..."
-	if maxWidth <= len(ellipsis) {
-		return color.New(color.Faint).Sprint(ellipsis[:maxWidth])
-	}
-	truncated := line[:maxWidth-len(ellipsis)] + ellipsis
-	return color.New(color.Faint).Sprint(truncated)
+	return color.New(color.Faint).Sprint(truncateLine(line, m.getRenderWidth()))
 }
 
 func (m Model) renderToolFields(fields map[string]string) string {
@@ -416,6 +884,7 @@ func (m Model) renderToolFields(fields map[string]string) string {
 		"old string",
 		"new string",
 		"replace all",
+		"regex",
 		"model",
 		"system prompt",
 		"user prompt",
@@ -436,6 +905,121 @@ func (m Model) renderToolFields(fields map[string]string) string {
 	return "\n" + strings.Join(parts, "\n")
 }
 
+func (m Model) renderToolResult(call llm.ToolCall, result string) string {
+	const maxPreviewLines = 5
+	preview := func(text string) string {
+		lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+		var out []string
+		for i, line := range lines {
+			if i >= maxPreviewLines {
+				out = append(out, fmt.Sprintf("    (%d more lines)", len(lines)-maxPreviewLines))
+				break
+			}
+			out = append(out, m.truncateLine("    "+line))
+		}
+		return strings.Join(out, "\n")
+	}
+	var parts []string
+	if !gjson.Valid(result) {
+		parts = append(parts, color.New(color.Faint).Sprint(preview(result)))
+	} else if errorMsg := gjson.Get(result, "error").String(); errorMsg != "" {
+		parts = append(parts, color.New(color.FgRed).Sprint(preview("error: "+errorMsg)))
+	} else {
+		switch call.Function.Name {
+		case "bash":
+			parts = append(parts, color.New(color.Faint).Sprintf("    exit code: %d", gjson.Get(result, "exit_code").Int()))
+			if stdout := gjson.Get(result, "stdout").String(); stdout != "" {
+				parts = append(parts, color.New(color.Faint).Sprint(preview(stdout)))
+			}
+			if stderr := gjson.Get(result, "stderr").String(); stderr != "" {
+				parts = append(parts, color.New(color.FgRed).Sprint(preview(stderr)))
+			}
+		case "fs_write", "fs_replace":
+			switch {
+			case gjson.Get(result, "dry_run").Bool():
+				parts = append(parts, color.New(color.Faint).Sprint("    dry run, nothing written"))
+				if diff := gjson.Get(result, "diff").String(); diff != "" {
+					parts = append(parts, color.New(color.Faint).Sprint(preview(diff)))
+				}
+			case call.Function.Name == "fs_write":
+				content := gjson.Get(call.Function.Args, "content").String()
+				parts = append(parts, color.New(color.Faint).Sprintf("    wrote %d bytes", len(content)))
+			default:
+				parts = append(parts, color.New(color.Faint).Sprint("    replaced"))
+			}
+		case "fs_patch":
+			parts = append(parts, color.New(color.Faint).Sprintf("    applied %d hunks", len(gjson.Get(result, "applied").Array())))
+		case "test":
+			parts = append(parts, color.New(color.Faint).Sprintf("    %d passed, %d failed, %d skipped",
+				gjson.Get(result, "passed").Int(), gjson.Get(result, "failed").Int(), gjson.Get(result, "skipped").Int()))
+			var failures []string
+			for _, test := range gjson.Get(result, "failed_tests").Array() {
+				failures = append(failures, test.Get("name").String())
+			}
+			for _, line := range gjson.Get(result, "build_errors").Array() {
+				failures = append(failures, line.String())
+			}
+			if len(failures) > 0 {
+				parts = append(parts, color.New(color.FgRed).Sprint(preview(strings.Join(failures, "\n"))))
+			}
+		case "fs_list":
+			files := gjson.Get(result, "files").Array()
+			names := make([]string, len(files))
+			for i, file := range files {
+				names[i] = file.String()
+			}
+			parts = append(parts, color.New(color.Faint).Sprint(preview(strings.Join(names, "\n"))))
+		default:
+			text := result
+			for _, key := range []string{"content", "answer", "report"} {
+				if value := gjson.Get(result, key); value.Exists() {
+					text = value.String()
+					break
+				}
+			}
+			parts = append(parts, color.New(color.Faint).Sprint(preview(text)))
+		}
+	}
+	return "\n" + strings.Join(parts, "\n")
+}
+
+func (m Model) truncateLine(line string) string {
+	return truncateLine(line, m.getRenderWidth())
+}
+
+func (m Model) renderToolCall(call llm.ToolCall) string {
+	switch call.Function.Name {
+	case "bash":
+		return m.renderToolBash(call.Function.Args)
+	case "fs_list":
+		return m.renderToolFSList(call.Function.Args)
+	case "fs_patch":
+		return m.renderToolFSPatch(call.Function.Args)
+	case "fs_read":
+		return m.renderToolFSRead(call.Function.Args)
+	case "fs_replace":
+		return m.renderToolFSReplace(call.Function.Args)
+	case "fs_write":
+		return m.renderToolFSWrite(call.Function.Args)
+	case "llm":
+		return m.renderToolLLM(call.Function.Args)
+	case "task":
+		return m.renderToolTask(call.Function.Args)
+	case "test":
+		return m.renderToolTest(call.Function.Args)
+	case "think":
+		return m.renderToolThink(call.Function.Args)
+	case "todo_read":
+		return m.renderToolTodoRead(call.Function.Args)
+	case "todo_write":
+		return m.renderToolTodoWrite(call.Function.Args)
+	case "web_fetch":
+		return m.renderToolWebFetch(call.Function.Args)
+	default:
+		return m.renderToolArgs(call.Function.Args)
+	}
+}
+
 func (m Model) renderToolBash(args string) string {
 	cmd := gjson.Get(args, "command").String()
 	if cmd == "" {
@@ -456,6 +1040,30 @@ func (m Model) renderToolFSList(args string) string {
 	return m.renderToolFields(map[string]string{"path": path})
 }
 
+func (m Model) renderToolFSPatch(args string) string {
+	path := gjson.Get(args, "path").String()
+	diff := gjson.Get(args, "diff").String()
+	if path == "" || diff == "" {
+		return ""
+	}
+	var hunks, added, removed int
+	for line := range strings.SplitSeq(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			hunks++
+		case strings.HasPrefix(line, "+++ "), strings.HasPrefix(line, "--- "):
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return m.renderToolFields(map[string]string{
+		"path":  path,
+		"hunks": fmt.Sprintf("%d (+%d, -%d lines)", hunks, added, removed),
+	})
+}
+
 func (m Model) renderToolFSRead(args string) string {
 	path := gjson.Get(args, "path").String()
 	if path == "" {
@@ -493,6 +1101,12 @@ func (m Model) renderToolFSReplace(args string) string {
 	} else {
 		fields["replace all"] = "false"
 	}
+	if gjson.Get(args, "regex").Bool() {
+		fields["regex"] = "true"
+	}
+	if gjson.Get(args, "dry_run").Bool() {
+		fields["dry run"] = "true"
+	}
 	return m.renderToolFields(fields)
 }
 
@@ -506,6 +1120,9 @@ func (m Model) renderToolFSWrite(args string) string {
 	if content != "" {
 		fields["content"] = fmt.Sprintf("%d bytes", len(content))
 	}
+	if gjson.Get(args, "dry_run").Bool() {
+		fields["dry run"] = "true"
+	}
 	return m.renderToolFields(fields)
 }
 
@@ -516,6 +1133,9 @@ func (m Model) renderToolLLM(args string) string {
 		return ""
 	}
 	fields := map[string]string{"model": model}
+	if effort := gjson.Get(args, "reasoning_effort").String(); effort != "" {
+		fields["reasoning effort"] = effort
+	}
 	if systemPrompt := gjson.Get(args, "system_prompt").String(); systemPrompt != "" {
 		systemPrompt = strings.TrimSpace(systemPrompt)
 		wordCount := len(strings.Fields(systemPrompt))
@@ -548,6 +1168,16 @@ func (m Model) renderToolTask(args string) string {
 	}
 	agentCount := len(agentsData.Array())
 	fields["agents"] = fmt.Sprintf("%d", agentCount)
+	if context := strings.TrimSpace(gjson.Get(args, "context").String()); context != "" {
+		fields["context"] = fmt.Sprintf("%d words", len(strings.Fields(context)))
+	}
+	var fileCount int
+	for _, agent := range agentsData.Array() {
+		fileCount += len(agent.Get("files").Array())
+	}
+	if fileCount > 0 {
+		fields["files"] = fmt.Sprintf("%d", fileCount)
+	}
 	return m.renderToolFields(fields)
 }
 
@@ -558,7 +1188,7 @@ func (m Model) renderToolThink(args string) string {
 	}
 	var s string
 	s += "\n"
-	s += color.New(color.Faint).Sprint(wrapWithPrefix(thought, "  ", m.viewport.Width))
+	s += color.New(color.Faint).Sprint(wrapWithPrefix(thought, "  ", m.getRenderWidth()))
 	return s
 }
 
@@ -587,10 +1217,7 @@ func (m Model) renderToolTodoWrite(args string) string {
 		default:
 			checkbox = "[ ]"
 		}
-		todoLine := fmt.Sprintf("  %s %s", checkbox, content)
-		if len(todoLine) > m.viewport.Width {
-			todoLine = todoLine[:m.viewport.Width-3] + "..."
-		}
+		todoLine := truncateLine(fmt.Sprintf("  %s %s", checkbox, content), m.getRenderWidth())
 		switch status {
 		case "completed":
 			todoLine = color.New(color.FgGreen).Sprint(todoLine)
@@ -605,6 +1232,22 @@ func (m Model) renderToolTodoWrite(args string) string {
 	return "\n" + strings.Join(todos, "\n")
 }
 
+func (m Model) renderToolTest(args string) string {
+	extra := gjson.Get(args, "args").String()
+	if extra == "" {
+		return ""
+	}
+	return m.renderToolFields(map[string]string{"args": extra})
+}
+
+func (m Model) renderToolWebFetch(args string) string {
+	url := gjson.Get(args, "url").String()
+	if url == "" {
+		return ""
+	}
+	return m.renderToolFields(map[string]string{"url": url})
+}
+
 func (m Model) renderFooter() string {
 	if value := m.textinput.Value(); strings.HasPrefix(value, "/") {
 		for _, cmd := range m.listSlashCommands() {
@@ -626,9 +1269,23 @@ func (m Model) renderFooter() string {
 	meta += fmt.Sprintf("%s, ", m.mode.name)
 	meta += fmt.Sprintf("%s, ", m.getModelSlug(m.model))
 	meta += fmt.Sprintf("cost: %.3f €, ", usage.TotalCost)
-	meta += fmt.Sprintf("tokens: %d", usage.PromptTokens+usage.CompletionTokens)
+	meta += fmt.Sprintf("tokens: %d in, %d out", usage.PromptTokens, usage.CompletionTokens)
+	if n := len(m.agent.EditedFiles()); n == 1 {
+		meta += ", 1 file edited"
+	} else if n > 1 {
+		meta += fmt.Sprintf(", %d files edited", n)
+	}
+	var attached string
+	if n := len(m.attachments); n == 1 {
+		attached = "[image attached] "
+	} else if n > 1 {
+		attached = fmt.Sprintf("[%d images attached] ", n)
+	}
 	if isRunning {
-		return "working... (" + meta + ")"
+		return attached + m.renderActivity(time.Now()) + " (" + meta + ")"
+	}
+	if attached != "" {
+		return attached + "esc to remove. (" + meta + ")"
 	}
 	return "ctrl+c to quit. (" + meta + ")"
 }
@@ -636,7 +1293,7 @@ func (m Model) renderFooter() string {
 // available models --------------------------------------------------------------------------------
 
 func (m Model) listModels() []string {
-	return []string{
+	models := []string{
 		"anthropic/claude-opus-4",
 		"anthropic/claude-sonnet-4",
 		"google/gemini-2.5-flash",
@@ -649,9 +1306,103 @@ func (m Model) listModels() []string {
 		"openai/o4-mini",
 		"qwen/qwen3-32b",
 	}
+	// the curated models above keep their tuned configuration, the rest of the catalog is appended
+	for _, catalogModel := range m.openRouterModels {
+		if !slices.Contains(models, catalogModel.ID) {
+			models = append(models, catalogModel.ID)
+		}
+	}
+	return models
+}
+
+func (m Model) getLLMToolModels() map[string]string {
+	// the llm tool calls models through OpenRouter, so local models are not available to it
+	models := make(map[string]string)
+	for _, model := range m.listModels() {
+		slug := m.getModelSlug(model)
+		if isOllamaModel(model) || slug == "" {
+			continue
+		}
+		if _, ok := models[slug]; !ok {
+			models[slug] = model
+		}
+	}
+	return models
+}
+
+func (m Model) getOpenRouterModel(model string) (llm.OpenRouterModel, bool) {
+	for _, catalogModel := range m.openRouterModels {
+		if catalogModel.ID == model {
+			return catalogModel, true
+		}
+	}
+	return llm.OpenRouterModel{}, false
+}
+
+func (m Model) modelSupportsTools(model string) bool {
+	if catalogModel, ok := m.getOpenRouterModel(model); ok {
+		return catalogModel.SupportsTools()
+	}
+	// the curated and local models are not in the catalog, they are all used with tools
+	return true
+}
+
+func (m Model) getModelName(model string) string {
+	if catalogModel, ok := m.getOpenRouterModel(model); ok && catalogModel.Name != "" {
+		return catalogModel.Name
+	}
+	return m.getModelSlug(model)
+}
+
+// context windows of the curated models, used when the OpenRouter catalog is not available
+var staticModelContextLengths = map[string]int{
+	"anthropic/claude-opus-4":   200_000,
+	"anthropic/claude-sonnet-4": 200_000,
+	"google/gemini-2.5-flash":   1_048_576,
+	"google/gemini-2.5-pro":     1_048_576,
+	"mistralai/devstral-small":  131_072,
+	"openai/codex-mini":         200_000,
+	"openai/gpt-4.1":            1_047_576,
+	"openai/gpt-4.1-mini":       1_047_576,
+	"openai/o3":                 200_000,
+	"openai/o4-mini":            200_000,
+	"qwen/qwen3-32b":            32_768,
+}
+
+func (m Model) getModelDetails(model string) string {
+	contextLength := staticModelContextLengths[model]
+	var pricing llm.Pricing
+	if catalogModel, ok := m.getOpenRouterModel(model); ok {
+		if catalogModel.ContextLength > 0 {
+			contextLength = catalogModel.ContextLength
+		}
+		pricing = catalogModel.Pricing
+	}
+	var details []string
+	if contextLength > 0 {
+		details = append(details, formatContextLength(contextLength)+" context")
+	}
+	if pricing.Input > 0 || pricing.Output > 0 {
+		details = append(details, fmt.Sprintf("$%.2f in / $%.2f out per 1M tokens", pricing.Input, pricing.Output))
+	}
+	return strings.Join(details, ", ")
+}
+
+func formatContextLength(tokens int) string {
+	if tokens >= 1_000_000 {
+		return fmt.Sprintf("%.0fM", float64(tokens)/1_000_000)
+	}
+	return fmt.Sprintf("%.0fk", float64(tokens)/1_000)
+}
+
+func isOllamaModel(model string) bool {
+	return strings.HasPrefix(model, "ollama/") && len(model) > len("ollama/")
 }
 
 func (m Model) getModelSlug(model string) string {
+	if isOllamaModel(model) {
+		return model
+	}
 	switch model {
 	case "anthropic/claude-opus-4":
 		return "claude-opus-4"
@@ -676,6 +1427,10 @@ func (m Model) getModelSlug(model string) string {
 	case "qwen/qwen3-32b":
 		return "qwen3-32b"
 	default:
+		if _, ok := m.getOpenRouterModel(model); ok {
+			_, slug, _ := strings.Cut(model, "/")
+			return slug
+		}
 		return ""
 	}
 }
@@ -685,9 +1440,28 @@ func (m Model) getModelSlug(model string) string {
 func (m Model) listSlashCommands() []string {
 	return []string{
 		"clear",
+		"compact",
+		"continue",
 		"copy",
+		"diff",
+		"export",
+		"files",
+		"load",
 		"mode",
 		"model",
+		"new",
+		"reasoning",
+		"save",
+		"sessions",
+		"system",
+		"thinking",
+		"todo",
+		"tokens",
+		"tweak",
+		"undo",
+		"verbose",
+		"width",
+		"yolo",
 	}
 }
 
@@ -695,8 +1469,20 @@ func (m Model) getSlashCommandHelp(cmd string, args []string) string {
 	switch cmd {
 	case "clear":
 		return "clears the conversation history."
+	case "compact":
+		return "summarizes the older half of the conversation to free up context."
+	case "continue":
+		return "asks the model to carry on from where its truncated response was cut off."
 	case "copy":
 		return "copies a message or messages to the clipboard: default, index-based or all."
+	case "diff":
+		return "shows the uncommitted changes to the files edited this session."
+	case "export":
+		return "writes the conversation as Markdown to a file in the working directory."
+	case "files":
+		return "lists the files edited this session."
+	case "load":
+		return "loads a saved session from .ikm/sessions by name."
 	case "mode":
 		names := make([]string, len(m.modes))
 		for i, mode := range m.modes {
@@ -706,101 +1492,169 @@ func (m Model) getSlashCommandHelp(cmd string, args []string) string {
 	case "model":
 		var slugs []string
 		for _, id := range m.listModels() {
-			slug := m.getModelSlug(id)
-			if len(args) > 0 && !strings.HasPrefix(slug, args[0]) {
+			slug, name := m.getModelSlug(id), m.getModelName(id)
+			if len(args) > 0 && !strings.HasPrefix(slug, args[0]) &&
+				!strings.HasPrefix(strings.ToLower(name), strings.ToLower(args[0])) {
 				continue
 			}
+			if name != slug {
+				slug = fmt.Sprintf("%s (%s)", slug, name)
+			}
+			if details := m.getModelDetails(id); details != "" {
+				slug += ": " + details
+			}
 			slugs = append(slugs, slug)
 		}
-		return strings.Join(slugs, ", ")
+		return strings.Join(slugs, "; ")
+	case "new":
+		return "saves the current session to .ikm/sessions by name and starts a new conversation."
+	case "reasoning":
+		return fmt.Sprintf("sets the reasoning effort to 0 (off), 1 (low), 2 (medium) or 3 (high) (current: %d).", m.reasoningEffort)
+	case "save":
+		return "saves the current session to .ikm/sessions by name."
+	case "sessions":
+		return "lists the saved sessions, or switches to one by name."
+	case "system":
+		return "shows the system prompt of the current mode, or opens .ikm/instructions.md in $EDITOR with `edit`."
+	case "thinking":
+		return "toggles showing the model's thinking above its answers."
+	case "todo":
+		return "shows the current todo list, or clears it with `clear`."
+	case "tokens":
+		return "shows an estimate of the tokens used by each message in the context."
+	case "tweak":
+		return "re-sends the last message once with `temperature <n>`, `effort <low|medium|high>` or `max-tokens <n>`."
+	case "undo":
+		return "removes the last message and everything after it."
+	case "verbose":
+		return "toggles showing tool results below the tool calls."
+	case "width":
+		current := "auto"
+		if m.renderWidth > 0 {
+			current = strconv.Itoa(m.renderWidth)
+		}
+		return fmt.Sprintf("sets the render wrap width to %d-%d columns or auto (current: %s).",
+			minRenderWidth, maxRenderWidth, current)
+	case "yolo":
+		return "toggles running file edits and bash commands without asking for approval."
 	default:
 		return ""
 	}
 }
 
-func (m *Model) handleSlashCommand() {
+func (m *Model) handleSlashCommand() tea.Cmd {
 	defer m.textinput.Reset()
 	fields := strings.Fields(m.textinput.Value())
 	if len(fields) == 0 {
-		return
+		return nil
 	}
+	// a diff is only shown until the next command
+	m.diffMsg = ""
 	switch fields[0] {
 	case "/clear":
 		m.handleClearSlashCommand()
+	case "/compact":
+		m.handleCompactSlashCommand()
+	case "/continue":
+		m.handleContinueSlashCommand()
 	case "/copy":
 		m.handleCopySlashCommand(fields[1:])
+	case "/diff":
+		m.handleDiffSlashCommand()
+	case "/export":
+		m.handleExportSlashCommand(fields[1:])
+	case "/files":
+		m.handleFilesSlashCommand()
+	case "/load":
+		m.handleLoadSlashCommand(fields[1:])
 	case "/mode":
 		m.handleModeSlashCommand(fields[1:])
 	case "/model":
 		m.handleModelSlashCommand(fields[1:])
+	case "/new":
+		m.handleNewSlashCommand(fields[1:])
+	case "/reasoning":
+		m.handleReasoningSlashCommand(fields[1:])
+	case "/save":
+		m.handleSaveSlashCommand(fields[1:])
+	case "/sessions":
+		m.handleSessionsSlashCommand(fields[1:])
+	case "/system":
+		return m.handleSystemSlashCommand(fields[1:])
+	case "/thinking":
+		m.handleThinkingSlashCommand()
+	case "/todo":
+		m.handleTodoSlashCommand(fields[1:])
+	case "/tokens":
+		m.handleTokensSlashCommand()
+	case "/tweak":
+		m.handleTweakSlashCommand(fields[1:])
+	case "/undo":
+		m.handleUndoSlashCommand()
+	case "/verbose":
+		m.handleVerboseSlashCommand()
+	case "/width":
+		m.handleWidthSlashCommand(fields[1:])
+	case "/yolo":
+		m.handleYoloSlashCommand()
 	}
+	return nil
 }
 
 func (m *Model) handleClearSlashCommand() {
-	m.agent.Reset()
+	if err := m.agent.Reset(); err != nil {
+		m.errorMsg = err.Error()
+		return
+	}
+	m.sessionName = ""
+	m.attachments = nil
+	m.errorMsg = ""
+	m.refusalMsg = ""
+	m.canceled = false
+	m.infoMsg = ""
+	m.diffMsg = ""
+}
+
+func (m *Model) handleCompactSlashCommand() {
+	if m.agent.GetIsRunning() {
+		return
+	}
+	m.errorMsg = ""
+	m.infoMsg = "compacting the conversation..."
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelFunc = cancel
+	go func() {
+		if err := m.agent.Compact(ctx); err != nil {
+			m.logger.Errorf("failed to compact the conversation: %v", err)
+		}
+	}()
+}
+
+func (m *Model) handleContinueSlashCommand() {
+	ctx, cancel := context.WithCancel(context.Background())
+	if !m.agent.Continue(ctx) {
+		cancel()
+		m.errorMsg = "nothing to continue, the last response was not truncated"
+		m.viewport.SetContent(m.renderContent())
+		m.viewport.GotoBottom()
+		return
+	}
+	m.cancelFunc = cancel
 	m.errorMsg = ""
+	m.refusalMsg = ""
+	m.canceled = false
+	m.infoMsg = ""
 }
 
 func (m *Model) handleCopySlashCommand(args []string) {
-	messages, _ := m.agent.GetHistoryState()
+	messages, usage := m.agent.GetHistoryState()
 	if len(args) > 0 && args[0] == "all" {
-		type jsonMessage_ToolCall struct {
-			FuncName string          `json:"func_name"`
-			FuncArgs json.RawMessage `json:"func_args"`
-		}
-		type jsonMessage struct {
-			Role      string                 `json:"role"`
-			Text      string                 `json:"text,omitzero"`
-			Result    any                    `json:"result,omitzero"`
-			ToolCalls []jsonMessage_ToolCall `json:"tool_calls,omitzero"`
-		}
-		var jsonMessages []jsonMessage
-		for _, msg := range messages {
-			switch msg.Role {
-			case llm.RoleSystem:
-				jsonMessages = append(jsonMessages, jsonMessage{
-					Role: "system",
-					Text: msg.Content.Text(),
-				})
-			case llm.RoleAssistant:
-				var toolCalls []jsonMessage_ToolCall
-				for _, call := range msg.ToolCalls {
-					toolCalls = append(toolCalls, jsonMessage_ToolCall{
-						FuncName: call.Function.Name,
-						FuncArgs: json.RawMessage(call.Function.Args),
-					})
-				}
-				jsonMessages = append(jsonMessages, jsonMessage{
-					Role:      "assistant",
-					Text:      msg.Content.Text(),
-					ToolCalls: toolCalls,
-				})
-			case llm.RoleTool:
-				var result any = msg.Content.Text()
-				if json.Valid([]byte(msg.Content.Text())) {
-					result = json.RawMessage(msg.Content.Text())
-				}
-				jsonMessages = append(jsonMessages, jsonMessage{
-					Role:   "tool",
-					Result: result,
-				})
-			case llm.RoleUser:
-				jsonMessages = append(jsonMessages, jsonMessage{
-					Role: "user",
-					Text: msg.Content.Text(),
-				})
-			}
-		}
-		jsonMessagesData, err := json.MarshalIndent(jsonMessages, "", "  ")
+		jsonMessagesData, err := json.MarshalIndent(buildTranscriptExport(messages, usage), "", "  ")
 		if err != nil {
 			m.logger.Errorf("failed to marshal messages to JSON: %v", err)
 			return
 		}
-		cmd := exec.Command("pbcopy")
-		cmd.Stdin = strings.NewReader(string(jsonMessagesData))
-		if err := cmd.Run(); err != nil {
-			m.logger.Errorf("failed to copy to clipboard: %v", err)
-		}
+		m.copyToClipboard(string(jsonMessagesData))
 		return
 	}
 	var assistantMessages []llm.Message
@@ -837,10 +1691,15 @@ func (m *Model) handleCopySlashCommand(args []string) {
 	if content == "" {
 		return
 	}
-	cmd := exec.Command("pbcopy")
-	cmd.Stdin = strings.NewReader(content)
-	if err := cmd.Run(); err != nil {
+	m.copyToClipboard(content)
+}
+
+func (m *Model) copyToClipboard(content string) {
+	if err := clipboard.Write(content); err != nil {
 		m.logger.Errorf("failed to copy to clipboard: %v", err)
+		m.errorMsg = fmt.Sprintf("failed to copy to clipboard: %v", err)
+		m.viewport.SetContent(m.renderContent())
+		m.viewport.GotoBottom()
 	}
 }
 
@@ -858,7 +1717,11 @@ func (m *Model) handleModelSlashCommand(args []string) {
 	if len(args) == 0 {
 		return
 	}
-	for _, id := range m.listModels() {
+	models := m.listModels()
+	if isOllamaModel(args[0]) {
+		models = append(models, args[0])
+	}
+	for _, id := range models {
 		if m.getModelSlug(id) == args[0] {
 			m.model = id
 			if err := m.configureModel(id); err != nil {
@@ -866,12 +1729,440 @@ func (m *Model) handleModelSlashCommand(args []string) {
 				m.errorMsg = fmt.Sprintf("failed to configure model %s: %v", id, err)
 				m.viewport.SetContent(m.renderContent())
 				m.viewport.GotoBottom()
+			} else if !m.modelSupportsTools(id) {
+				m.infoMsg = fmt.Sprintf("%s does not support tools, it is used without them.", args[0])
+				m.viewport.SetContent(m.renderContent())
+				m.viewport.GotoBottom()
 			}
 			return
 		}
 	}
 }
 
+func (m *Model) handleReasoningSlashCommand(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	effort, err := parseReasoningEffort(args[0])
+	if err != nil {
+		m.errorMsg = err.Error()
+	} else {
+		// the model is reconfigured in place, the conversation history is kept
+		previous := m.reasoningEffort
+		m.reasoningEffort = effort
+		if err := m.configureModel(m.model); err != nil {
+			m.reasoningEffort = previous
+			m.logger.Errorf("failed to configure model %s: %v", m.model, err)
+			m.errorMsg = fmt.Sprintf("failed to configure model %s: %v", m.model, err)
+		} else {
+			m.errorMsg = ""
+			m.infoMsg = fmt.Sprintf("reasoning effort set to %d.", effort)
+		}
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func parseReasoningEffort(value string) (uint8, error) {
+	effort, err := strconv.Atoi(value)
+	if err != nil || effort < 0 || effort > 3 {
+		return 0, fmt.Errorf("invalid reasoning effort: %s, must be one of: 0, 1, 2, 3", value)
+	}
+	return uint8(effort), nil
+}
+
+func (m *Model) handleSaveSlashCommand(args []string) {
+	path, err := getSessionPath(args)
+	if err != nil {
+		m.errorMsg = err.Error()
+	} else if err := m.agent.SaveSession(path); err != nil {
+		m.logger.Errorf("failed to save session to %s: %v", path, err)
+		m.errorMsg = fmt.Sprintf("failed to save session: %v", err)
+	} else {
+		m.sessionName = args[0]
+		m.errorMsg = ""
+		m.infoMsg = fmt.Sprintf("session saved to %s.", path)
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleDiffSlashCommand() {
+	files := m.agent.EditedFiles()
+	if len(files) == 0 {
+		m.infoMsg = "no files have been edited this session."
+	} else if diff, err := getSessionDiff(files); err != nil {
+		m.logger.Errorf("failed to get the diff: %v", err)
+		m.errorMsg = fmt.Sprintf("failed to get the diff: %v", err)
+	} else if diff == "" {
+		m.infoMsg = fmt.Sprintf("no uncommitted changes in the %d files edited this session.", len(files))
+	} else {
+		m.infoMsg = ""
+		m.diffMsg = colorizeDiff(diff)
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleFilesSlashCommand() {
+	files := m.agent.EditedFiles()
+	if len(files) == 0 {
+		m.infoMsg = "no files have been edited this session."
+	} else {
+		cwd, _ := os.Getwd()
+		lines := []string{"files edited this session:"}
+		for _, file := range files {
+			if rel, err := filepath.Rel(cwd, file); err == nil && cwd != "" {
+				file = rel
+			}
+			lines = append(lines, "  "+file)
+		}
+		m.infoMsg = strings.Join(lines, "\n")
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleExportSlashCommand(args []string) {
+	messages, _ := m.agent.GetHistoryState()
+	path, err := getExportPath(args)
+	if err != nil {
+		m.errorMsg = err.Error()
+	} else if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		m.logger.Errorf("failed to create directory for %s: %v", path, err)
+		m.errorMsg = fmt.Sprintf("failed to export conversation: %v", err)
+	} else if err := os.WriteFile(path, []byte(renderTranscriptMarkdown(buildTranscript(messages))), 0644); err != nil {
+		m.logger.Errorf("failed to export conversation to %s: %v", path, err)
+		m.errorMsg = fmt.Sprintf("failed to export conversation: %v", err)
+	} else {
+		m.errorMsg = ""
+		m.infoMsg = fmt.Sprintf("conversation exported to %s.", path)
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleLoadSlashCommand(args []string) {
+	path, err := getSessionPath(args)
+	if err != nil {
+		m.errorMsg = err.Error()
+	} else if err := m.agent.LoadSession(path); err != nil {
+		m.logger.Errorf("failed to load session from %s: %v", path, err)
+		m.errorMsg = fmt.Sprintf("failed to load session: %v", err)
+	} else {
+		m.sessionName = args[0]
+		m.errorMsg = ""
+		m.canceled = false
+		m.infoMsg = fmt.Sprintf("session loaded from %s.", path)
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleNewSlashCommand(args []string) {
+	path, err := getSessionPath(args)
+	if err != nil {
+		m.errorMsg = err.Error()
+	} else if m.agent.GetIsRunning() {
+		m.errorMsg = "cannot start a new conversation while the agent is running"
+	} else if err := m.agent.SaveSession(path); err != nil {
+		m.logger.Errorf("failed to save session to %s: %v", path, err)
+		m.errorMsg = fmt.Sprintf("failed to save session: %v", err)
+	} else {
+		m.handleClearSlashCommand()
+		m.infoMsg = fmt.Sprintf("session saved to %s, started a new conversation.", path)
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleSessionsSlashCommand(args []string) {
+	if len(args) == 0 {
+		names, err := listSessions()
+		if err != nil {
+			m.logger.Errorf("failed to list sessions: %v", err)
+			m.errorMsg = fmt.Sprintf("failed to list sessions: %v", err)
+		} else if len(names) == 0 {
+			m.infoMsg = "no saved sessions."
+		} else {
+			lines := []string{"saved sessions:"}
+			for _, name := range names {
+				if name == m.sessionName {
+					name += " (current)"
+				}
+				lines = append(lines, "  "+name)
+			}
+			m.infoMsg = strings.Join(lines, "\n")
+		}
+		m.viewport.SetContent(m.renderContent())
+		m.viewport.GotoBottom()
+		return
+	}
+	path, err := getSessionPath(args)
+	if err != nil {
+		m.errorMsg = err.Error()
+		m.viewport.SetContent(m.renderContent())
+		m.viewport.GotoBottom()
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		m.errorMsg = fmt.Sprintf("no saved session named %s", args[0])
+		m.viewport.SetContent(m.renderContent())
+		m.viewport.GotoBottom()
+		return
+	}
+	// the conversation being left is saved first so that switching back to it later loses nothing
+	if m.sessionName != "" && m.sessionName != args[0] && !m.agent.GetIsRunning() {
+		current, _ := getSessionPath([]string{m.sessionName})
+		if err := m.agent.SaveSession(current); err != nil {
+			m.logger.Errorf("failed to save session to %s: %v", current, err)
+			m.errorMsg = fmt.Sprintf("failed to save session: %v", err)
+			m.viewport.SetContent(m.renderContent())
+			m.viewport.GotoBottom()
+			return
+		}
+	}
+	m.handleLoadSlashCommand(args)
+}
+
+func (m *Model) handleSystemSlashCommand(args []string) tea.Cmd {
+	if len(args) == 0 || args[0] != "edit" {
+		m.showSystemPrompt()
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(instructionsPath), 0755); err != nil {
+		m.logger.Errorf("failed to create directory for %s: %v", instructionsPath, err)
+		m.errorMsg = fmt.Sprintf("failed to edit the instructions: %v", err)
+		m.viewport.SetContent(m.renderContent())
+		m.viewport.GotoBottom()
+		return nil
+	}
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	// the editor may come with arguments of its own, e.g. `code --wait`
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], instructionsPath)...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return systemEditedMsg{err: err}
+	})
+}
+
+func (m *Model) showSystemPrompt() {
+	// the prompt is resolved again every time, the modes read the instructions when they are called
+	system := strings.TrimSpace(m.mode.system())
+	m.errorMsg = ""
+	m.infoMsg = wrapWithPrefix(fmt.Sprintf("system prompt of the %s mode:\n\n%s", m.mode.name, system), "", m.getRenderWidth())
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func listSessions() ([]string, error) {
+	entries, err := os.ReadDir(".ikm/sessions")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading sessions folder: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func getSessionPath(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("session name is required")
+	}
+	name := args[0]
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid session name: %s", name)
+	}
+	return filepath.Join(".ikm/sessions", name+".json"), nil
+}
+
+func (m *Model) handleTokensSlashCommand() {
+	messages, _ := m.agent.GetHistoryState()
+	estimator := llm.NewCharTokenEstimator()
+	lines := []string{"estimated tokens per message:"}
+	total := 0
+	if m.mode.system != nil {
+		tokens := estimator.EstimateTokens(llm.Message{
+			Role:    llm.RoleSystem,
+			Content: llm.ContentParts{llm.NewTextContentPart(m.mode.system())},
+		})
+		total += tokens
+		lines = append(lines, fmt.Sprintf("  %8d  system", tokens))
+	}
+	for i, msg := range messages {
+		tokens := estimator.EstimateTokens(msg)
+		total += tokens
+		label := string(msg.Role)
+		switch {
+		case msg.Role == llm.RoleTool && msg.Name != "":
+			label += " (" + msg.Name + ")"
+		case len(msg.ToolCalls) > 0:
+			label += fmt.Sprintf(" (%d tool calls)", len(msg.ToolCalls))
+		}
+		lines = append(lines, fmt.Sprintf("  %8d  #%d %s", tokens, i+1, label))
+	}
+	lines = append(lines, fmt.Sprintf("  %8d  total", total))
+	m.infoMsg = strings.Join(lines, "\n")
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleTodoSlashCommand(args []string) {
+	if len(args) > 0 && args[0] == "clear" {
+		tool.ClearTodoList()
+		m.infoMsg = "todo list cleared."
+	} else {
+		todoList := tool.GetTodoList()
+		if len(todoList.Items) == 0 {
+			m.infoMsg = "todo list is empty."
+		} else {
+			lines := []string{"todo list:"}
+			for _, item := range todoList.Items {
+				var checkbox string
+				switch item.Status {
+				case "completed":
+					checkbox = "[x]"
+				case "in_progress":
+					checkbox = "[~]"
+				default:
+					checkbox = "[ ]"
+				}
+				lines = append(lines, wrapWithPrefix(fmt.Sprintf("%s %s", checkbox, item.Content), "  ", m.getRenderWidth()))
+			}
+			m.infoMsg = strings.Join(lines, "\n")
+		}
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleTweakSlashCommand(args []string) {
+	if len(args) < 2 || m.agent.GetIsRunning() {
+		return
+	}
+	var option llm.StreamOption
+	switch args[0] {
+	case "temperature":
+		temperature, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || temperature < 0 || temperature > 2 {
+			m.errorMsg = fmt.Sprintf("invalid temperature: %s", args[1])
+			return
+		}
+		option = llm.WithTemperature(temperature)
+	case "effort":
+		switch args[1] {
+		case "low":
+			option = llm.WithReasoningEffortLow()
+		case "medium":
+			option = llm.WithReasoningEffortMedium()
+		case "high":
+			option = llm.WithReasoningEffortHigh()
+		default:
+			m.errorMsg = fmt.Sprintf("invalid effort: %s", args[1])
+			return
+		}
+	case "max-tokens":
+		maxTokens, err := strconv.Atoi(args[1])
+		if err != nil || maxTokens <= 0 {
+			m.errorMsg = fmt.Sprintf("invalid max tokens: %s", args[1])
+			return
+		}
+		option = llm.WithMaxTokens(maxTokens)
+	default:
+		m.errorMsg = fmt.Sprintf("unknown tweak parameter: %s", args[0])
+		return
+	}
+	// the previous answer is replaced and the override only applies to this single send
+	content, ok := m.agent.Rewind()
+	if !ok {
+		m.errorMsg = "no previous message to tweak"
+		return
+	}
+	m.errorMsg = ""
+	m.refusalMsg = ""
+	m.canceled = false
+	m.infoMsg = fmt.Sprintf("re-sent the last message with %s set to %s.", args[0], args[1])
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelFunc = cancel
+	m.agent.SendContent(ctx, content, option)
+}
+
+func (m *Model) handleThinkingSlashCommand() {
+	m.thinking = !m.thinking
+	if m.thinking {
+		m.infoMsg = "thinking is shown."
+	} else {
+		m.infoMsg = "thinking is hidden."
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleUndoSlashCommand() {
+	if !m.agent.PopLastExchange() {
+		return
+	}
+	m.errorMsg = ""
+	m.refusalMsg = ""
+	m.canceled = false
+	m.infoMsg = ""
+	m.diffMsg = ""
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleVerboseSlashCommand() {
+	m.verbose = !m.verbose
+	if m.verbose {
+		m.infoMsg = "tool results are shown."
+	} else {
+		m.infoMsg = "tool results are hidden."
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
+func (m *Model) handleWidthSlashCommand(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	if args[0] == "auto" {
+		m.renderWidth = 0
+	} else {
+		width, err := strconv.Atoi(args[0])
+		if err != nil {
+			return
+		}
+		m.renderWidth = min(max(width, minRenderWidth), maxRenderWidth)
+	}
+	m.viewport.SetContent(m.renderContent())
+	if m.viewport.PastBottom() {
+		m.viewport.GotoBottom()
+	}
+}
+
+func (m *Model) handleYoloSlashCommand() {
+	yolo := !m.approval.yolo.Load()
+	m.approval.yolo.Store(yolo)
+	if yolo {
+		m.infoMsg = "yolo mode on: tool calls run without approval."
+	} else {
+		m.infoMsg = "yolo mode off: file edits and bash commands need approval."
+	}
+	m.viewport.SetContent(m.renderContent())
+	m.viewport.GotoBottom()
+}
+
 func (m Model) configureModel(modelName string) error {
 	var (
 		model         llm.Model
@@ -880,49 +2171,69 @@ func (m Model) configureModel(modelName string) error {
 	switch modelName {
 	case "anthropic/claude-opus-4":
 		model = llm.NewAnthropic(m.logger, m.anthropicKey, "claude-opus-4-20240620",
+			llm.WithAnthropicBaseURL(m.anthropicBaseURL),
 			llm.WithAnthropicCacheEnabled(),
+			llm.WithAnthropicPricing(m.pricing),
 		)
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 			llm.WithTemperature(1.0),
 			m.getReasoningEffortOption(),
 		}
 	case "anthropic/claude-sonnet-4":
 		model = llm.NewAnthropic(m.logger, m.anthropicKey, "claude-sonnet-4-20250514",
+			llm.WithAnthropicBaseURL(m.anthropicBaseURL),
 			llm.WithAnthropicCacheEnabled(),
+			llm.WithAnthropicPricing(m.pricing),
 		)
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 			llm.WithTemperature(1.0),
 			m.getReasoningEffortOption(),
 		}
 	case "google/gemini-2.5-flash":
-		model = llm.NewOpenRouter(m.logger, m.openRouterKey, modelName) // NOTE: supports implicit caching
+		model = m.newGoogleModel(modelName)
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 			llm.WithTemperature(0.7),
 			m.getReasoningEffortOption(),
 		}
 	case "google/gemini-2.5-pro":
-		model = llm.NewOpenRouter(m.logger, m.openRouterKey, modelName) // NOTE: supports implicit caching
+		model = m.newGoogleModel(modelName)
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 			llm.WithTemperature(0.7),
 			m.getReasoningMaxTokensOption(32_768, 256),
 		}
 	case "mistralai/devstral-small":
-		model = llm.NewOpenRouter(m.logger, m.openRouterKey, modelName,
-			llm.WithOpenRouterCacheEnabled(),
-			llm.WithOpenRouterOrderProviders([]string{"Mistral"}, false),
-			llm.WithOpenRouterRequestTransform(llm.NewOpenRouterHexadecimalToolCallIDRequestTransform()),
-		)
+		if m.mistralKey != "" {
+			model = llm.NewMistral(m.logger, m.mistralKey, "devstral-small-2505",
+				llm.WithMistralPricing(m.pricing),
+			)
+		} else {
+			model = llm.NewOpenRouter(m.logger, m.openRouterKey, modelName,
+				llm.WithOpenRouterCacheEnabled(),
+				llm.WithOpenRouterOrderProviders([]string{"Mistral"}, false),
+				llm.WithOpenRouterRequestTransform(llm.NewOpenRouterHexadecimalToolCallIDRequestTransform()),
+			)
+		}
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 		}
 	case "openai/codex-mini":
-		model = llm.NewOpenAI(m.logger, m.openAIKey, "codex-mini-latest")
+		model = llm.NewOpenAI(m.logger, m.openAIKey, "codex-mini-latest",
+			llm.WithOpenAIBaseURL(m.openAIBaseURL),
+			llm.WithOpenAIPricing(m.pricing),
+			llm.WithOpenAIReasoningSummary(m.openAISummary),
+		)
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 			llm.WithTemperature(0.7),
 			m.getReasoningEffortOption(),
 		}
@@ -930,82 +2241,186 @@ func (m Model) configureModel(modelName string) error {
 		model = llm.NewOpenRouter(m.logger, m.openRouterKey, modelName)
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 			llm.WithTemperature(0.7),
 		}
 	case "openai/gpt-4.1-mini":
 		model = llm.NewOpenRouter(m.logger, m.openRouterKey, modelName)
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 			llm.WithTemperature(0.7),
 		}
 	case "openai/o3":
-		model = llm.NewOpenAI(m.logger, m.openAIKey, "o3")
+		model = llm.NewOpenAI(m.logger, m.openAIKey, "o3",
+			llm.WithOpenAIBaseURL(m.openAIBaseURL),
+			llm.WithOpenAIPricing(m.pricing),
+			llm.WithOpenAIReasoningSummary(m.openAISummary),
+		)
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 			m.getReasoningEffortOption(),
 		}
 	case "openai/o4-mini":
-		model = llm.NewOpenAI(m.logger, m.openAIKey, "o4-mini")
+		model = llm.NewOpenAI(m.logger, m.openAIKey, "o4-mini",
+			llm.WithOpenAIBaseURL(m.openAIBaseURL),
+			llm.WithOpenAIPricing(m.pricing),
+			llm.WithOpenAIReasoningSummary(m.openAISummary),
+		)
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 			m.getReasoningEffortOption(),
 		}
 	case "qwen/qwen3-32b":
 		model = llm.NewOpenRouter(m.logger, m.openRouterKey, modelName,
 			llm.WithOpenRouterCacheEnabled(),
 			llm.WithOpenRouterOrderProviders([]string{"Cerebras"}, false),
+			llm.WithOpenRouterRequestTransform(llm.NewOpenRouterOmitToolNameRequestTransform()),
 		)
 		streamOptions = []llm.StreamOption{
 			llm.WithMaxTokens(8_192), // NOTE: the context window is only 32,768 tokens, so the output tokens must be significantly lower
+			llm.WithMaxTokensFromContext(staticModelContextLengths[modelName]),
 			m.getReasoningEffortOption(),
 		}
 	default:
-		return fmt.Errorf("unknown model: %s", modelName)
+		if isOllamaModel(modelName) {
+			if m.ollamaBaseURL == "" {
+				return errors.New("OLLAMA_BASE_URL environment variable is not set")
+			}
+			// Ollama exposes an OpenAI-compatible chat completions endpoint
+			model = llm.NewOpenRouter(m.logger, "", strings.TrimPrefix(modelName, "ollama/"),
+				llm.WithOpenRouterBaseURL(strings.TrimSuffix(m.ollamaBaseURL, "/")+"/v1"),
+				llm.WithOpenRouterCompatibleAPI(),
+			)
+			streamOptions = []llm.StreamOption{
+				llm.WithMaxTokens(8_192),
+				llm.WithTemperature(0.7),
+			}
+			break
+		}
+		catalogModel, ok := m.getOpenRouterModel(modelName)
+		if !ok {
+			return fmt.Errorf("unknown model: %s", modelName)
+		}
+		model = llm.NewOpenRouter(m.logger, m.openRouterKey, modelName)
+		streamOptions = []llm.StreamOption{
+			llm.WithMaxTokens(32_768),
+			llm.WithMaxTokensFromContext(catalogModel.ContextLength),
+			llm.WithTemperature(0.7),
+		}
+	}
+	streamOptions = append(streamOptions, llm.WithToolApprover(m.approval.approve))
+	// sending tools to a model that cannot call them fails the whole request, so it goes without
+	if m.modelSupportsTools(modelName) {
+		m.registerTools(model)
+	} else {
+		m.logger.Debugf("skipped all tools for a model without tool support: %s", modelName)
 	}
-	m.registerTools(model)
 	m.agent.SetModel(model, streamOptions...)
 	return nil
 }
 
+func (m Model) newGoogleModel(modelName string) llm.Model {
+	// Google is called directly when there is a key for it, which avoids the OpenRouter markup and hop
+	if m.geminiKey != "" {
+		return llm.NewGemini(m.logger, m.geminiKey, strings.TrimPrefix(modelName, "google/"),
+			llm.WithGeminiPricing(m.pricing),
+		)
+	}
+	return llm.NewOpenRouter(m.logger, m.openRouterKey, modelName) // NOTE: supports implicit caching
+}
+
 func (m Model) registerTools(model llm.Model) {
 	if !m.isToolDisabled("bash") {
-		model.Register(tool.NewBash(m.runInBashDocker).SetLogger(m.logger))
+		m.registerTool(model, tool.NewBash(m.runInBashDocker,
+			tool.WithBashTimeout(m.bashTimeout),
+			tool.WithBashSandbox(m.bashWritableDir, m.bashNetworkCmds),
+			tool.WithBashPolicy(m.bashAllow, m.bashDeny),
+		).SetLogger(m.logger))
 	} else {
 		m.logger.Debugf("skipped disabled tool: bash")
 	}
 	if !m.isToolDisabled("fs") {
-		model.Register(tool.NewFSList().SetLogger(m.logger))
-		model.Register(tool.NewFSRead().SetLogger(m.logger))
-		model.Register(tool.NewFSReplace().SetLogger(m.logger))
-		model.Register(tool.NewFSWrite().SetLogger(m.logger))
+		m.registerTool(model, tool.NewFSList(tool.WithFSListRoots(m.fsRoots)).SetLogger(m.logger))
+		m.registerTool(model, tool.NewFSPatch(tool.WithFSPatchRecorder(m.agent.RecordEditedFile)).SetLogger(m.logger))
+		m.registerTool(model, tool.NewFSRead(tool.WithFSReadRoots(m.fsRoots)).SetLogger(m.logger))
+		m.registerTool(model, tool.NewFSReplace(tool.WithFSReplaceRecorder(m.agent.RecordEditedFile)).SetLogger(m.logger))
+		m.registerTool(model, tool.NewFSWrite(tool.WithFSWriteRecorder(m.agent.RecordEditedFile)).SetLogger(m.logger))
 	} else {
 		m.logger.Debugf("skipped disabled tool: fs")
 	}
 	if !m.isToolDisabled("llm") {
-		model.Register(tool.NewLLM(m.openRouterKey).SetLogger(m.logger))
+		m.registerTool(model, tool.NewLLM(m.openRouterKey,
+			tool.WithLLMModels(m.getLLMToolModels()),
+			tool.WithLLMProgress(m.progress.report),
+			tool.WithLLMHTTPTimeout(llmToolHTTPTimeout),
+		).SetLogger(m.logger))
 	} else {
 		m.logger.Debugf("skipped disabled tool: llm")
 	}
+	// the logs tool is opt-in, the logs hold whole requests and responses
+	if m.logsTool && !m.isToolDisabled("logs") {
+		m.registerTool(model, tool.NewLogs().SetLogger(m.logger))
+	}
 	if !m.isToolDisabled("task") {
-		model.Register(tool.NewTask(
+		m.registerTool(model, tool.NewTask(
 			m.runInBashDocker,
 			m.openRouterKey,
 			m.fastButCapableModel, m.thoroughButCostlyModel,
+			tool.WithTaskProgress(m.progress.report),
+			tool.WithTaskEditRecorder(m.agent.RecordEditedFile),
+			tool.WithTaskToolApprover(m.approval.approve),
+			tool.WithTaskHTTPTimeout(taskToolHTTPTimeout),
+			tool.WithTaskBashOptions(
+				tool.WithBashTimeout(m.bashTimeout),
+				tool.WithBashSandbox(m.bashWritableDir, m.bashNetworkCmds),
+				tool.WithBashPolicy(m.bashAllow, m.bashDeny),
+			),
 		).SetLogger(m.logger))
 	} else {
 		m.logger.Debugf("skipped disabled tool: task")
 	}
+	if !m.isToolDisabled("test") {
+		m.registerTool(model, tool.NewTest(m.runInBashDocker,
+			tool.WithTestCommand(m.testCommand),
+			tool.WithTestTimeout(m.testTimeout),
+		).SetLogger(m.logger))
+	} else {
+		m.logger.Debugf("skipped disabled tool: test")
+	}
 	if !m.isToolDisabled("think") {
-		model.Register(tool.NewThink().SetLogger(m.logger))
+		m.registerTool(model, tool.NewThink().SetLogger(m.logger))
 	} else {
 		m.logger.Debugf("skipped disabled tool: think")
 	}
 	if !m.isToolDisabled("todo") {
-		model.Register(tool.NewTodoRead().SetLogger(m.logger))
-		model.Register(tool.NewTodoWrite().SetLogger(m.logger))
+		m.registerTool(model, tool.NewTodoRead().SetLogger(m.logger))
+		m.registerTool(model, tool.NewTodoWrite().SetLogger(m.logger))
 	} else {
 		m.logger.Debugf("skipped disabled tool: todo")
 	}
+	if !m.isToolDisabled("web") {
+		m.registerTool(model, tool.NewWebFetch(
+			tool.WithWebFetchAllowedHosts(m.webAllowedHosts),
+			tool.WithWebFetchDeniedHosts(m.webDeniedHosts),
+		).SetLogger(m.logger))
+	} else {
+		m.logger.Debugf("skipped disabled tool: web")
+	}
+	if !m.isToolDisabled("mcp") {
+		for _, t := range m.mcpTools {
+			m.registerTool(model, t)
+		}
+	} else {
+		m.logger.Debugf("skipped disabled tool: mcp")
+	}
+}
+
+func (m Model) registerTool(model llm.Model, t llm.Tool) {
+	model.Register(t)
+	m.renderers.add(t)
 }
 
 func (m Model) getReasoningEffortOption() llm.StreamOption {
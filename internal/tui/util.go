@@ -3,6 +3,8 @@ package tui
 import (
 	"strings"
 	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
 )
 
 func wrapLine(s string, width int) []string {
@@ -76,3 +78,29 @@ func wrapWithPrefix(s string, prefix string, width int) string {
 	}
 	return strings.Join(lines, "\n")
 }
+
+func truncateLine(s string, width int) string {
+	const ellipsis = "..."
+	// wide characters (e.g. CJK or emoji) take two columns of the terminal
+	if runewidth.StringWidth(s) <= width {
+		return s
+	}
+	if width <= len(ellipsis) {
+		return ellipsis[:max(width, 0)]
+	}
+	limit := width - len(ellipsis)
+	cut, used := 0, 0
+	for i, r := range s {
+		w := runewidth.RuneWidth(r)
+		if used+w > limit {
+			break
+		}
+		used += w
+		cut = i + utf8.RuneLen(r)
+	}
+	// the line is cut at the last word boundary unless that would throw away more than half of it
+	if i := strings.LastIndex(s[:cut+1], " "); i >= 0 && runewidth.StringWidth(s[:i]) > limit/2 {
+		return strings.TrimRight(s[:i], " ") + ellipsis
+	}
+	return s[:cut] + ellipsis
+}
@@ -1,7 +1,6 @@
 package llm
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -19,15 +18,71 @@ import (
 
 var _ Model = (*Anthropic)(nil)
 
+const anthropicResponseFormatInstruction = `You must respond with a single JSON object that conforms to the following JSON schema. Do not include any text, explanation or markdown code fences before or after the JSON object.
+
+<schema>
+%s
+</schema>`
+
+// server tools are run by Anthropic itself, the names map to the versioned tool types
+var anthropicServerToolTypes = map[string]string{
+	"web_search": "web_search_20250305",
+}
+
+// the output limits are matched by model id prefix, the longest matching prefix wins
+var anthropicMaxOutputTokens = map[string]int{
+	"claude-opus-4":     32_000,
+	"claude-sonnet-4":   64_000,
+	"claude-3-7-sonnet": 64_000,
+	"claude-3-5-sonnet": 8_192,
+	"claude-3-5-haiku":  8_192,
+	"claude-3-opus":     4_096,
+	"claude-3-haiku":    4_096,
+}
+
+// unknown models get a limit every current model supports
+const anthropicDefaultMaxOutputTokens = 8_192
+
+const anthropicMinThinkingBudget = 1024
+
 type AnthropicOption func(*Anthropic)
 
 type Anthropic struct {
-	logger logger.Logger
-	token  string
-	model  string
-	tools  []Tool
-	cache  bool
-	usage  *anthropic_Response_Usage
+	logger  logger.Logger
+	baseURL string
+	version string
+	betas   []string
+	token   string
+	model   string
+	tools   []Tool
+	cache   bool
+	pricing PricingTable
+	timeout time.Duration
+
+	serverTools []map[string]any
+}
+
+func WithAnthropicBaseURL(baseURL string) AnthropicOption {
+	return func(a *Anthropic) {
+		if baseURL != "" {
+			a.baseURL = strings.TrimSuffix(baseURL, "/")
+		}
+	}
+}
+
+func WithAnthropicVersion(version string) AnthropicOption {
+	return func(a *Anthropic) {
+		if version != "" {
+			a.version = version
+		}
+	}
+}
+
+func WithAnthropicBeta(betas ...string) AnthropicOption {
+	return func(a *Anthropic) {
+		// the default betas are replaced, passing none leaves the header out
+		a.betas = betas
+	}
 }
 
 func WithAnthropicCacheEnabled() AnthropicOption {
@@ -36,8 +91,53 @@ func WithAnthropicCacheEnabled() AnthropicOption {
 	}
 }
 
+func WithAnthropicCacheDisabled() AnthropicOption {
+	return func(a *Anthropic) {
+		// caching is off by default, this undoes an earlier WithAnthropicCacheEnabled in the same options
+		a.cache = false
+	}
+}
+
+func WithAnthropicPricing(pricing PricingTable) AnthropicOption {
+	return func(a *Anthropic) {
+		a.pricing = pricing
+	}
+}
+
+func WithAnthropicHTTPTimeout(timeout time.Duration) AnthropicOption {
+	return func(a *Anthropic) {
+		if timeout > 0 {
+			a.timeout = timeout
+		}
+	}
+}
+
+func WithAnthropicServerTool(name string, config map[string]any) AnthropicOption {
+	return func(a *Anthropic) {
+		tool := map[string]any{}
+		for key, value := range config {
+			tool[key] = value
+		}
+		tool["name"] = name
+		if toolType, ok := anthropicServerToolTypes[name]; ok {
+			tool["type"] = toolType
+		} else if _, ok := tool["type"]; !ok {
+			tool["type"] = name
+		}
+		a.serverTools = append(a.serverTools, tool)
+	}
+}
+
 func NewAnthropic(logger logger.Logger, token, model string, opts ...AnthropicOption) *Anthropic {
-	a := &Anthropic{logger: logger, token: token, model: model}
+	a := &Anthropic{
+		logger:  logger,
+		baseURL: "https://api.anthropic.com",
+		version: "2023-06-01",
+		betas:   []string{"interleaved-thinking-2025-05-14"},
+		token:   token,
+		model:   model,
+		timeout: defaultHTTPTimeout,
+	}
 	for _, opt := range opts {
 		opt(a)
 	}
@@ -67,19 +167,38 @@ func (a *Anthropic) streamTurns(ctx context.Context, messages []Message, config
 				return
 			default:
 			}
-			out := tee(a.streamTurn(ctx, cloned, config), ch)
-			builder := newMessageBuilder()
-			for event := range out {
-				builder.process(event)
-			}
-			messages, _, err := builder.result()
-			if err != nil {
-				ch <- &ErrorEvent{Err: fmt.Errorf("error processing events: %w", err)}
-				return
-			}
-			if len(messages) != 1 {
-				ch <- &ErrorEvent{Err: fmt.Errorf("expected exactly one message, got %d", len(messages))}
-				return
+			var messages []Message
+			for attempt := 0; ; attempt++ {
+				// the turn state is per request, concurrent streams of the same model must not share it
+				state := &anthropic_TurnState{}
+				out := tee(a.streamTurn(ctx, withEmptyResponseNudge(cloned, attempt), config, state), ch)
+				builder := newMessageBuilder()
+				for event := range out {
+					builder.process(event)
+				}
+				var err error
+				messages, _, err = builder.result()
+				if err != nil {
+					ch <- &ErrorEvent{Err: fmt.Errorf("error processing events: %w", err)}
+					return
+				}
+				// only an attempt that produced nothing is retried, anything it did produce is already with the caller
+				if isEmptyResponse(messages) {
+					if attempt < config.emptyResponseRetries {
+						a.logger.Errorf("Anthropic returned an empty response (stop reason %q), retrying the turn", state.stopReason)
+						continue
+					}
+					ch <- &ErrorEvent{Err: ErrEmptyResponse}
+					return
+				}
+				if len(messages) != 1 {
+					ch <- &ErrorEvent{Err: fmt.Errorf("expected exactly one message, got %d", len(messages))}
+					return
+				}
+				if state.stopReason == "tool_use" && len(messages[0].ToolCalls) == 0 {
+					a.logger.Errorf("Anthropic stopped for tool use but no tool calls were captured")
+				}
+				break
 			}
 			if len(messages[0].ToolCalls) == 0 {
 				return
@@ -88,6 +207,7 @@ func (a *Anthropic) streamTurns(ctx context.Context, messages []Message, config
 			if len(messages[0].ToolCalls) > 0 {
 				toolResultEvents := make([]*ToolResultEvent, len(messages[0].ToolCalls))
 				g, gctx := errgroup.WithContext(ctx)
+				g.SetLimit(config.toolConcurrency)
 				for idx, toolCall := range messages[0].ToolCalls {
 					g.Go(func() error {
 						var tool Tool
@@ -100,7 +220,17 @@ func (a *Anthropic) streamTurns(ctx context.Context, messages []Message, config
 						if tool == nil {
 							return fmt.Errorf("tool %s not found", toolCall.Function.Name)
 						}
-						result, err := tool.Call(gctx, toolCall.Function.Args)
+						// arguments that do not match the schema are sent back to the model before anyone is asked to approve them
+						_, _, schema := tool.Spec()
+						if err := ValidateToolArgs(schema, toolCall.Function.Args); err != nil {
+							toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: invalidToolCallResult(err)}
+							return nil
+						}
+						if err := config.approveToolCall(gctx, toolCall.Function.Name, toolCall.Function.Args); err != nil {
+							toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: deniedToolCallResult(err)}
+							return nil
+						}
+						result, err := tool.Call(withToolCallID(gctx, toolCall.ID), toolCall.Function.Args)
 						toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: result, Error: err}
 						return nil
 					})
@@ -128,15 +258,19 @@ func (a *Anthropic) streamTurns(ctx context.Context, messages []Message, config
 					cloned = append(cloned, msg)
 				}
 			}
-			if turn >= config.maxTurns-1 || (config.stopCondition != nil && config.stopCondition(turn, cloned)) {
+			if config.stopCondition != nil && config.stopCondition(turn, cloned) {
+				return
+			}
+			if turn >= config.maxTurns-1 {
+				// the model still has tool results to respond to, let the caller know why the loop stopped
+				ch <- &MaxTurnsReachedEvent{MaxTurns: config.maxTurns}
 				return
 			}
 		}
 	}()
 	return ch
 }
-func (a *Anthropic) streamTurn(ctx context.Context, messages []Message, config streamConfig) <-chan Event {
-	a.usage = nil
+func (a *Anthropic) streamTurn(ctx context.Context, messages []Message, config streamConfig, state *anthropic_TurnState) <-chan Event {
 	ch := make(chan Event)
 	go func() {
 		defer close(ch)
@@ -155,12 +289,14 @@ func (a *Anthropic) streamTurn(ctx context.Context, messages []Message, config s
 			}
 			return
 		}
+		if a.shouldPrefillJSON(config) {
+			// the prefill is not echoed back, so it is emitted here to keep the output valid JSON
+			ch <- &ContentDeltaEvent{Content: "{"}
+		}
 		toolCallBuffer := make([]*ToolUseEvent, 32)
-		var currentEvent string
-		var currentData string
-		reader := bufio.NewReader(resp.Body)
+		reader := newSSEReader(resp.Body)
 		for {
-			line, err := reader.ReadString('\n')
+			event, err := reader.next()
 			select {
 			case <-ctx.Done():
 				ch <- &ErrorEvent{Err: ctx.Err()}
@@ -173,29 +309,23 @@ func (a *Anthropic) streamTurn(ctx context.Context, messages []Message, config s
 				ch <- &ErrorEvent{Err: fmt.Errorf("error reading stream: %w", err)}
 				return
 			}
-			line = strings.TrimSpace(line)
-			if line == "" {
-				if currentEvent != "" && currentData != "" {
-					a.processSSEEvent(currentEvent, currentData, ch, toolCallBuffer)
-				}
-				currentEvent = ""
-				currentData = ""
-				continue
-			}
-			if after, ok := strings.CutPrefix(line, "event: "); ok {
-				currentEvent = after
-			} else if after, ok := strings.CutPrefix(line, "data: "); ok {
-				currentData = after
+			if event.name != "" && event.data != "" {
+				a.processSSEEvent(event.name, event.data, ch, toolCallBuffer, state)
 			}
 		}
-		if currentEvent != "" && currentData != "" {
-			a.processSSEEvent(currentEvent, currentData, ch, toolCallBuffer)
+		if state.stopReason == "refusal" {
+			ch <- &ErrorEvent{Err: &RefusalError{Reason: state.stopReason}}
 		}
 	}()
 	return ch
 }
 
 func (a *Anthropic) request(ctx context.Context, messages []Message, config streamConfig) (*http.Response, error) {
+	config = config.withContextBudget(messages)
+	if limit := anthropicMaxOutputTokensFor(a.model); config.maxTokens > limit {
+		a.logger.Debugf("max tokens %d is over the limit of %s, clamping to %d", config.maxTokens, a.model, limit)
+		config.maxTokens = limit
+	}
 	payload := anthropic_Request{
 		MaxTokens:   config.maxTokens,
 		Messages:    []anthropic_Message{},
@@ -218,42 +348,62 @@ func (a *Anthropic) request(ctx context.Context, messages []Message, config stre
 		payload.Messages = append(payload.Messages, m)
 	}
 	a.injectCacheControl(payload.Messages)
-	if config.reasoningEffort > 0 {
+	// an explicit thinking budget takes precedence over the one derived from the effort
+	var budget int
+	if config.reasoningMaxTokens > 0 {
+		budget = int(config.reasoningMaxTokens)
+	} else if config.reasoningEffort > 0 {
 		switch config.reasoningEffort {
 		case 1:
-			payload.Thinking = &anthropic_Request_Thinking{
-				Type:         "enabled",
-				BudgetTokens: int(math.Round(0.2 * float64(config.maxTokens))),
-			}
+			budget = int(math.Round(0.2 * float64(config.maxTokens)))
 		case 2:
-			payload.Thinking = &anthropic_Request_Thinking{
-				Type:         "enabled",
-				BudgetTokens: int(math.Round(0.5 * float64(config.maxTokens))),
-			}
+			budget = int(math.Round(0.5 * float64(config.maxTokens)))
 		case 3:
-			payload.Thinking = &anthropic_Request_Thinking{
-				Type:         "enabled",
-				BudgetTokens: int(math.Round(0.8 * float64(config.maxTokens))),
-			}
+			budget = int(math.Round(0.8 * float64(config.maxTokens)))
 		default:
 			a.logger.Errorf("invalid reasoning effort: %d, must be 1, 2, or 3", config.reasoningEffort)
 		}
-	} else if config.reasoningMaxTokens > 0 {
-		payload.Thinking = &anthropic_Request_Thinking{
-			Type:         "enabled",
-			BudgetTokens: int(config.reasoningMaxTokens),
+	}
+	// Anthropic requires a budget of at least 1024 tokens that still leaves room for the answer
+	if budget > 0 && config.maxTokens <= anthropicMinThinkingBudget {
+		a.logger.Debugf("max tokens %d is too low for thinking, disabling it", config.maxTokens)
+	} else if budget > 0 {
+		if clamped := max(min(budget, config.maxTokens-1), anthropicMinThinkingBudget); clamped != budget {
+			a.logger.Debugf("thinking budget %d is out of range for max tokens %d, using %d", budget, config.maxTokens, clamped)
+			budget = clamped
 		}
+		payload.Thinking = &anthropic_Request_Thinking{Type: "enabled", BudgetTokens: budget}
 	}
-	if len(a.tools) > 0 {
-		payload.Tools = make([]anthropic_Request_Tool, len(a.tools))
-		for i, tool := range a.tools {
-			name, description, inputSchema := tool.Spec()
-			payload.Tools[i] = anthropic_Request_Tool{
-				Name:        name,
-				Description: description,
-				InputSchema: json.RawMessage(inputSchema),
-			}
+	if config.topP != nil || config.frequencyPenalty != nil || config.presencePenalty != nil {
+		a.logger.Debugf("top_p and frequency and presence penalties are not supported by Anthropic, ignoring")
+	}
+	if config.seed != nil {
+		a.logger.Debugf("seed is not supported by Anthropic, ignoring")
+	}
+	if len(config.responseFormat) > 0 {
+		// Anthropic has no structured output mode, so it is emulated with an instruction and a prefill
+		instruction := fmt.Sprintf(anthropicResponseFormatInstruction, string(config.responseFormat))
+		if payload.System != "" {
+			payload.System += "\n\n"
 		}
+		payload.System += instruction
+		if a.shouldPrefillJSON(config) {
+			payload.Messages = append(payload.Messages, anthropic_Message{
+				Role:    "assistant",
+				Content: []any{anthropic_Message_Text{Type: "text", Text: "{"}},
+			})
+		}
+	}
+	for _, tool := range a.tools {
+		name, description, inputSchema := tool.Spec()
+		payload.Tools = append(payload.Tools, anthropic_Request_Tool{
+			Name:        name,
+			Description: description,
+			InputSchema: json.RawMessage(inputSchema),
+		})
+	}
+	for _, tool := range a.serverTools {
+		payload.Tools = append(payload.Tools, tool)
 	}
 	var data bytes.Buffer
 	encoder := json.NewEncoder(&data)
@@ -263,19 +413,27 @@ func (a *Anthropic) request(ctx context.Context, messages []Message, config stre
 	}
 	a.logger.Debugj("Anthropic request payload", data.Bytes())
 	req, err := http.NewRequestWithContext(ctx,
-		http.MethodPost, "https://api.anthropic.com/v1/messages", &data)
+		http.MethodPost, a.baseURL+"/v1/messages", &data)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
-	req.Header.Set("anthropic-beta", "interleaved-thinking-2025-05-14")
-	req.Header.Set("anthropic-version", "2023-06-01")
+	if len(a.betas) > 0 {
+		req.Header.Set("anthropic-beta", strings.Join(a.betas, ","))
+	}
+	req.Header.Set("anthropic-version", a.version)
 	req.Header.Set("content-type", "application/json")
 	req.Header.Set("x-api-key", a.token)
-	client := &http.Client{Timeout: 300 * time.Second /* 5 min */}
+	client := &http.Client{Timeout: a.timeout}
 	return client.Do(req)
 }
 
-func (a *Anthropic) processSSEEvent(event, data string, ch chan<- Event, toolCallBuffer []*ToolUseEvent) {
+func (a *Anthropic) shouldPrefillJSON(config streamConfig) bool {
+	// a prefilled response can neither use extended thinking nor call tools
+	return len(config.responseFormat) > 0 && len(a.tools) == 0 && len(a.serverTools) == 0 &&
+		config.reasoningEffort == 0 && config.reasoningMaxTokens == 0
+}
+
+func (a *Anthropic) processSSEEvent(event, data string, ch chan<- Event, toolCallBuffer []*ToolUseEvent, state *anthropic_TurnState) {
 	switch event {
 	case "message_start":
 		var msgStart anthropic_Response_MessageStart
@@ -289,7 +447,7 @@ func (a *Anthropic) processSSEEvent(event, data string, ch chan<- Event, toolCal
 				usage.CacheReadInputTokens,
 				float64(usage.CacheReadInputTokens)/float64(usage.InputTokens+usage.CacheCreationInputTokens+usage.CacheReadInputTokens)*100.0,
 			)
-			a.usage = usage
+			state.usage = usage
 		}
 	case "content_block_start":
 		var blockStart anthropic_Response_ContentBlockStart
@@ -297,7 +455,18 @@ func (a *Anthropic) processSSEEvent(event, data string, ch chan<- Event, toolCal
 			a.logger.Errorf("failed to parse content_block_start: %v", err)
 			return
 		}
-		if blockStart.ContentBlock.Type == "tool_use" {
+		switch blockStart.ContentBlock.Type {
+		case "server_tool_use":
+			// server tools are run by Anthropic, their input is only collected to show what was done
+			state.serverToolUse = &ToolUseEvent{
+				ID:       blockStart.ContentBlock.ID,
+				FuncName: blockStart.ContentBlock.Name,
+			}
+		case "web_search_tool_result":
+			if content := formatAnthropicWebSearchResult(blockStart.ContentBlock.Content); content != "" {
+				ch <- &ContentDeltaEvent{Content: content}
+			}
+		case "tool_use":
 			for i := range toolCallBuffer {
 				if toolCallBuffer[i] == nil {
 					toolCallBuffer[i] = &ToolUseEvent{
@@ -320,6 +489,8 @@ func (a *Anthropic) processSSEEvent(event, data string, ch chan<- Event, toolCal
 			ch <- &ContentDeltaEvent{
 				Content: blockDelta.Delta.Text,
 			}
+		} else if blockDelta.Delta.Type == "input_json_delta" && blockDelta.Delta.PartialJSON != "" && state.serverToolUse != nil {
+			state.serverToolUse.FuncArgs += blockDelta.Delta.PartialJSON
 		} else if blockDelta.Delta.Type == "input_json_delta" && blockDelta.Delta.PartialJSON != "" {
 			lastNonNilToolBufferIndex := -1
 			for i, toolCall := range toolCallBuffer {
@@ -339,15 +510,23 @@ func (a *Anthropic) processSSEEvent(event, data string, ch chan<- Event, toolCal
 			ch <- &ThinkingDeltaEvent{Signature: blockDelta.Delta.Signature}
 		}
 	case "content_block_stop":
-		return
+		if state.serverToolUse != nil {
+			if content := formatAnthropicServerToolUse(*state.serverToolUse); content != "" {
+				ch <- &ContentDeltaEvent{Content: content}
+			}
+			state.serverToolUse = nil
+		}
 	case "message_delta":
 		var msgDelta anthropic_Response_MessageDelta
 		if err := json.Unmarshal([]byte(data), &msgDelta); err != nil {
 			a.logger.Errorf("failed to parse message_delta: %v", err)
 			return
 		}
-		if a.usage != nil && msgDelta.Usage != nil {
-			a.usage.OutputTokens += msgDelta.Usage.OutputTokens
+		if state.usage != nil && msgDelta.Usage != nil {
+			state.usage.OutputTokens += msgDelta.Usage.OutputTokens
+		}
+		if stopReason, ok := msgDelta.Delta["stop_reason"].(string); ok {
+			state.stopReason = stopReason
 		}
 	case "ping":
 		return
@@ -358,11 +537,11 @@ func (a *Anthropic) processSSEEvent(event, data string, ch chan<- Event, toolCal
 			}
 			ch <- toolCall
 		}
-		if a.usage != nil {
+		if state.usage != nil {
 			ch <- &UsageEvent{Usage: Usage{
-				PromptTokens:     a.usage.InputTokens + a.usage.CacheCreationInputTokens + a.usage.CacheReadInputTokens,
-				CompletionTokens: a.usage.OutputTokens,
-				TotalCost:        a.estimateCost(*a.usage),
+				PromptTokens:     state.usage.InputTokens + state.usage.CacheCreationInputTokens + state.usage.CacheReadInputTokens,
+				CompletionTokens: state.usage.OutputTokens,
+				TotalCost:        a.estimateCost(*state.usage),
 			}}
 		}
 	default:
@@ -370,6 +549,48 @@ func (a *Anthropic) processSSEEvent(event, data string, ch chan<- Event, toolCal
 	}
 }
 
+func formatAnthropicServerToolUse(toolUse ToolUseEvent) string {
+	switch toolUse.FuncName {
+	case "web_search":
+		var input struct {
+			Query string `json:"query"`
+		}
+		if err := json.Unmarshal([]byte(toolUse.FuncArgs), &input); err != nil || input.Query == "" {
+			return ""
+		}
+		return fmt.Sprintf("\n\n*Searching the web for \"%s\"*\n\n", input.Query)
+	default:
+		return fmt.Sprintf("\n\n*Using the %s tool*\n\n", toolUse.FuncName)
+	}
+}
+
+func formatAnthropicWebSearchResult(content json.RawMessage) string {
+	// the content is a list of results on success and a single error object on failure
+	var results []anthropic_Response_WebSearchResult
+	if err := json.Unmarshal(content, &results); err != nil {
+		var searchErr anthropic_Response_WebSearchResult
+		if err := json.Unmarshal(content, &searchErr); err == nil && searchErr.ErrorCode != "" {
+			return fmt.Sprintf("*Web search failed: %s*\n\n", searchErr.ErrorCode)
+		}
+		return ""
+	}
+	var b strings.Builder
+	for _, result := range results {
+		if result.Type != "web_search_result" || result.URL == "" {
+			continue
+		}
+		title := result.Title
+		if title == "" {
+			title = result.URL
+		}
+		fmt.Fprintf(&b, "- [%s](%s)\n", title, result.URL)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "Sources:\n\n" + b.String() + "\n"
+}
+
 func (a *Anthropic) injectCacheControl(messages []anthropic_Message) {
 	if !a.cache {
 		return
@@ -416,13 +637,25 @@ func (a *Anthropic) injectCacheControl(messages []anthropic_Message) {
 	}
 }
 
+func anthropicMaxOutputTokensFor(model string) int {
+	limit, matched := anthropicDefaultMaxOutputTokens, ""
+	for prefix, tokens := range anthropicMaxOutputTokens {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			limit, matched = tokens, prefix
+		}
+	}
+	return limit
+}
+
 func (a *Anthropic) generationConfig(opts ...StreamOption) streamConfig {
 	c := streamConfig{
-		maxTokens:          8192,
-		maxTurns:           1,
-		reasoningEffort:    0,
-		reasoningMaxTokens: 0,
-		temperature:        1.0,
+		maxTokens:            8192,
+		maxTurns:             1,
+		emptyResponseRetries: 1,
+		toolConcurrency:      defaultToolConcurrency,
+		reasoningEffort:      0,
+		reasoningMaxTokens:   0,
+		temperature:          1.0,
 	}
 	for _, opt := range opts {
 		if opt != nil {
@@ -454,7 +687,17 @@ func (a *Anthropic) estimateCost(usage anthropic_Response_Usage) float64 {
 		},
 	}
 	var cost *costConfig
-	if c, ok := costs[a.model]; ok {
+	if p, ok := a.pricing[a.model]; ok {
+		cost = &costConfig{
+			inputTokens:      p.Input,
+			cacheReadTokens:  p.Cached,
+			cacheWriteTokens: p.CacheWrite,
+			outputTokens:     p.Output,
+		}
+		if cost.cacheWriteTokens == 0 {
+			cost.cacheWriteTokens = 1.25 * p.Input
+		}
+	} else if c, ok := costs[a.model]; ok {
 		cost = &c
 	} else {
 		a.logger.Errorf("no cost information available for model %s, using intentionally high default (2x Opus) values", a.model)
@@ -535,6 +778,10 @@ func (m *anthropic_Message) from(msg Message) error {
 		for _, part := range msg.Content {
 			switch p := part.(type) {
 			case ThinkingContentPart:
+				// unsigned thinking (e.g. from an interrupted turn or another provider) is rejected by Anthropic
+				if p.Thinking == "" || p.Signature == "" {
+					continue
+				}
 				m.Content = append(m.Content, anthropic_Message_Thinking{
 					Type:      "thinking",
 					Thinking:  p.Thinking,
@@ -589,7 +836,13 @@ type anthropic_Request struct {
 	System      string                      `json:"system,omitzero"`
 	Temperature float64                     `json:"temperature"`
 	Thinking    *anthropic_Request_Thinking `json:"thinking,omitzero"`
-	Tools       []anthropic_Request_Tool    `json:"tools,omitzero"`
+	Tools       []any                       `json:"tools,omitzero"`
+}
+
+type anthropic_TurnState struct {
+	usage         *anthropic_Response_Usage
+	stopReason    string
+	serverToolUse *ToolUseEvent
 }
 
 // responses
@@ -614,9 +867,19 @@ type anthropic_Response_MessageStart struct {
 }
 
 type anthropic_Response_ContentBlock struct {
-	Type string `json:"type"`
-	ID   string `json:"id,omitempty"`
-	Name string `json:"name,omitempty"`
+	Type      string          `json:"type"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+}
+
+type anthropic_Response_WebSearchResult struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	URL       string `json:"url"`
+	PageAge   string `json:"page_age"`
+	ErrorCode string `json:"error_code"`
 }
 
 type anthropic_Response_ContentBlockStart struct {
@@ -2,23 +2,78 @@ package llm
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+)
+
+var ErrEmptyResponse = errors.New("model returned an empty response")
+
+type StreamErrorKind string
+
+const (
+	StreamErrorKindUnknown             StreamErrorKind = ""
+	StreamErrorKindInsufficientCredits StreamErrorKind = "insufficient_credits"
+	StreamErrorKindModeration          StreamErrorKind = "moderation"
+	StreamErrorKindProviderDown        StreamErrorKind = "provider_down"
+	StreamErrorKindRateLimited         StreamErrorKind = "rate_limited"
 )
 
 type StreamError struct {
+	Kind     StreamErrorKind
 	Code     int
 	Message  string
+	Provider string
+	Reasons  []string
 	Metadata map[string]any
 }
 
 func (e StreamError) Error() string {
-	meta := []byte("null")
-	if e.Metadata != nil {
-		b, err := json.Marshal(e.Metadata)
-		if err != nil {
-			panic(err)
+	switch e.Kind {
+	case StreamErrorKindInsufficientCredits:
+		return "out of credits, top up at https://openrouter.ai/settings/credits"
+	case StreamErrorKindModeration:
+		if len(e.Reasons) > 0 {
+			return fmt.Sprintf("the input was flagged by moderation (%s), rephrase it or choose another model", strings.Join(e.Reasons, ", "))
 		}
-		meta = b
+		return "the input was flagged by moderation, rephrase it or choose another model"
+	case StreamErrorKindProviderDown:
+		if e.Provider != "" {
+			return fmt.Sprintf("%s is unavailable at the moment, try again later or choose another model", e.Provider)
+		}
+		return "the provider is unavailable at the moment, try again later or choose another model"
+	case StreamErrorKindRateLimited:
+		if e.Provider != "" {
+			return fmt.Sprintf("rate limited by %s, wait a moment and try again", e.Provider)
+		}
+		return "rate limited, wait a moment and try again"
+	}
+	if e.Provider != "" {
+		return fmt.Sprintf("%s (%d, %s)", e.Message, e.Code, e.Provider)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+func (e StreamError) RawMetadata() string {
+	// the metadata is left out of the message, it is available here for debugging
+	if e.Metadata == nil {
+		return "null"
+	}
+	b, err := json.Marshal(e.Metadata)
+	if err != nil {
+		return fmt.Sprintf("%v", e.Metadata)
+	}
+	return string(b)
+}
+
+type RefusalError struct {
+	Reason  string
+	Message string
+}
+
+func (e RefusalError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("the model declined to answer (%s): %s", e.Reason, e.Message)
 	}
-	return fmt.Sprintf("%s (%d): %s", e.Message, e.Code, meta)
+	return fmt.Sprintf("the model declined to answer (%s)", e.Reason)
 }
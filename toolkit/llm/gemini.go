@@ -0,0 +1,619 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/markusylisiurunen/ikm/internal/logger"
+	"golang.org/x/sync/errgroup"
+)
+
+var _ Model = (*Gemini)(nil)
+
+// these finish reasons mean that Google stopped the answer for policy reasons
+var geminiRefusalFinishReasons = []string{
+	"BLOCKLIST", "IMAGE_SAFETY", "PROHIBITED_CONTENT", "RECITATION", "SAFETY", "SPII",
+}
+
+type GeminiOption func(*Gemini)
+
+type Gemini struct {
+	logger  logger.Logger
+	baseURL string
+	token   string
+	model   string
+	tools   []Tool
+	pricing PricingTable
+	timeout time.Duration
+}
+
+func WithGeminiBaseURL(baseURL string) GeminiOption {
+	return func(g *Gemini) {
+		if baseURL != "" {
+			g.baseURL = strings.TrimSuffix(baseURL, "/")
+		}
+	}
+}
+
+func WithGeminiPricing(pricing PricingTable) GeminiOption {
+	return func(g *Gemini) {
+		g.pricing = pricing
+	}
+}
+
+func WithGeminiHTTPTimeout(timeout time.Duration) GeminiOption {
+	return func(g *Gemini) {
+		if timeout > 0 {
+			g.timeout = timeout
+		}
+	}
+}
+
+func NewGemini(logger logger.Logger, token, model string, opts ...GeminiOption) *Gemini {
+	g := &Gemini{
+		logger:  logger,
+		baseURL: "https://generativelanguage.googleapis.com/v1beta",
+		token:   token,
+		model:   model,
+		timeout: defaultHTTPTimeout,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+func (g *Gemini) Register(tool Tool) {
+	if tool != nil {
+		g.tools = append(g.tools, tool)
+	}
+}
+
+func (g *Gemini) Stream(ctx context.Context, messages []Message, opts ...StreamOption) <-chan Event {
+	config := g.generationConfig(opts...)
+	return g.streamTurns(ctx, messages, config)
+}
+func (g *Gemini) streamTurns(ctx context.Context, messages []Message, config streamConfig) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		cloned := make([]Message, len(messages))
+		copy(cloned, messages)
+		for turn := range config.maxTurns {
+			select {
+			case <-ctx.Done():
+				ch <- &ErrorEvent{Err: ctx.Err()}
+				return
+			default:
+			}
+			var messages []Message
+			for attempt := 0; ; attempt++ {
+				out := tee(g.streamTurn(ctx, withEmptyResponseNudge(cloned, attempt), config), ch)
+				builder := newMessageBuilder()
+				for event := range out {
+					builder.process(event)
+				}
+				var err error
+				messages, _, err = builder.result()
+				if err != nil {
+					ch <- &ErrorEvent{Err: fmt.Errorf("error processing events: %w", err)}
+					return
+				}
+				if isEmptyResponse(messages) {
+					if attempt < config.emptyResponseRetries {
+						g.logger.Errorf("Gemini returned an empty response, retrying the turn")
+						continue
+					}
+					ch <- &ErrorEvent{Err: ErrEmptyResponse}
+					return
+				}
+				if len(messages) != 1 {
+					ch <- &ErrorEvent{Err: fmt.Errorf("expected exactly one message, got %d", len(messages))}
+					return
+				}
+				break
+			}
+			if len(messages[0].ToolCalls) == 0 {
+				return
+			}
+			cloned = append(cloned, messages[0])
+			if len(messages[0].ToolCalls) > 0 {
+				toolResultEvents := make([]*ToolResultEvent, len(messages[0].ToolCalls))
+				eg, gctx := errgroup.WithContext(ctx)
+				eg.SetLimit(config.toolConcurrency)
+				for idx, toolCall := range messages[0].ToolCalls {
+					eg.Go(func() error {
+						var tool Tool
+						for _, t := range g.tools {
+							if name, _, _ := t.Spec(); name == toolCall.Function.Name {
+								tool = t
+								break
+							}
+						}
+						if tool == nil {
+							return fmt.Errorf("tool %s not found", toolCall.Function.Name)
+						}
+						// arguments that do not match the schema are sent back to the model before anyone is asked to approve them
+						_, _, schema := tool.Spec()
+						if err := ValidateToolArgs(schema, toolCall.Function.Args); err != nil {
+							toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: invalidToolCallResult(err)}
+							return nil
+						}
+						if err := config.approveToolCall(gctx, toolCall.Function.Name, toolCall.Function.Args); err != nil {
+							toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: deniedToolCallResult(err)}
+							return nil
+						}
+						result, err := tool.Call(withToolCallID(gctx, toolCall.ID), toolCall.Function.Args)
+						toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: result, Error: err}
+						return nil
+					})
+				}
+				if err := eg.Wait(); err != nil {
+					ch <- &ErrorEvent{Err: fmt.Errorf("error executing tool calls: %w", err)}
+					return
+				}
+				for idx, event := range toolResultEvents {
+					if event == nil {
+						ch <- &ErrorEvent{Err: fmt.Errorf("tool call %d result is nil", idx)}
+						return
+					}
+					ch <- event
+					msg := Message{
+						Role:       RoleTool,
+						Name:       messages[0].ToolCalls[idx].Function.Name,
+						ToolCallID: messages[0].ToolCalls[idx].ID,
+					}
+					if event.Error != nil {
+						msg.Content = ContentParts{NewTextContentPart("Error: " + event.Error.Error())}
+					} else {
+						msg.Content = ContentParts{NewTextContentPart(event.Result)}
+					}
+					cloned = append(cloned, msg)
+				}
+			}
+			if config.stopCondition != nil && config.stopCondition(turn, cloned) {
+				return
+			}
+			if turn >= config.maxTurns-1 {
+				// the model still has tool results to respond to, let the caller know why the loop stopped
+				ch <- &MaxTurnsReachedEvent{MaxTurns: config.maxTurns}
+				return
+			}
+		}
+	}()
+	return ch
+}
+func (g *Gemini) streamTurn(ctx context.Context, messages []Message, config streamConfig) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		resp, err := g.request(ctx, messages, config)
+		if err != nil {
+			ch <- &ErrorEvent{Err: err}
+			return
+		}
+		defer resp.Body.Close() //nolint:errcheck
+		if resp.StatusCode != http.StatusOK {
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				ch <- &ErrorEvent{Err: fmt.Errorf("error reading response body: %w", err)}
+				return
+			}
+			var chunk gemini_Chunk
+			if err := json.Unmarshal(body, &chunk); err == nil && chunk.Error != nil {
+				ch <- &ErrorEvent{Err: fmt.Errorf("non-ok status (%d) from Gemini: %s: %s",
+					resp.StatusCode, chunk.Error.Status, chunk.Error.Message)}
+			} else {
+				ch <- &ErrorEvent{Err: fmt.Errorf("non-ok status (%d) from Gemini: %s", resp.StatusCode, string(body))}
+			}
+			return
+		}
+		var (
+			toolCalls    []*ToolUseEvent
+			usage        *gemini_Chunk_UsageMetadata
+			finishReason string
+			blockReason  string
+		)
+		reader := newSSEReader(resp.Body)
+		for {
+			event, err := reader.next()
+			select {
+			case <-ctx.Done():
+				ch <- &ErrorEvent{Err: ctx.Err()}
+				return
+			default:
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				ch <- &ErrorEvent{Err: fmt.Errorf("error reading stream: %w", err)}
+				return
+			}
+			raw := strings.TrimSpace(event.data)
+			if raw == "" {
+				continue
+			}
+			var chunk gemini_Chunk
+			if err := json.Unmarshal([]byte(raw), &chunk); err != nil {
+				g.logger.Errorf("failed to parse Gemini chunk: %v", err)
+				continue
+			}
+			if chunk.Error != nil {
+				ch <- &ErrorEvent{Err: fmt.Errorf("error from Gemini: %s: %s", chunk.Error.Status, chunk.Error.Message)}
+				return
+			}
+			// the usage metadata is cumulative, only the last one is reported
+			if chunk.UsageMetadata != nil {
+				usage = chunk.UsageMetadata
+			}
+			if chunk.PromptFeedback != nil && chunk.PromptFeedback.BlockReason != "" {
+				blockReason = chunk.PromptFeedback.BlockReason
+			}
+			if len(chunk.Candidates) == 0 {
+				continue
+			}
+			candidate := chunk.Candidates[0]
+			if candidate.FinishReason != "" {
+				finishReason = candidate.FinishReason
+			}
+			if candidate.Content == nil {
+				continue
+			}
+			for _, part := range candidate.Content.Parts {
+				switch {
+				case part.FunctionCall != nil:
+					// function calls arrive whole, Gemini does not always give them an ID
+					id := part.FunctionCall.ID
+					if id == "" {
+						id = fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), len(toolCalls))
+					}
+					args := "{}"
+					if len(part.FunctionCall.Args) > 0 {
+						args = string(part.FunctionCall.Args)
+					}
+					toolCalls = append(toolCalls, &ToolUseEvent{
+						ID:       id,
+						Index:    len(toolCalls),
+						FuncName: part.FunctionCall.Name,
+						FuncArgs: args,
+					})
+				case part.Thought:
+					if part.Text != "" {
+						ch <- &ThinkingDeltaEvent{Thinking: part.Text}
+					}
+				case part.Text != "":
+					ch <- &ContentDeltaEvent{Content: part.Text}
+				}
+			}
+		}
+		for _, toolCall := range toolCalls {
+			ch <- toolCall
+		}
+		if usage != nil {
+			g.logger.Debugf("Gemini usage: %d prompt tokens (cached %d), %d completion tokens, %d thinking tokens",
+				usage.PromptTokenCount,
+				usage.CachedContentTokenCount,
+				usage.CandidatesTokenCount,
+				usage.ThoughtsTokenCount,
+			)
+			ch <- &UsageEvent{Usage: Usage{
+				PromptTokens:     usage.PromptTokenCount,
+				CompletionTokens: usage.CandidatesTokenCount + usage.ThoughtsTokenCount,
+				TotalCost:        g.estimateCost(*usage),
+			}}
+		}
+		if blockReason != "" {
+			ch <- &ErrorEvent{Err: &RefusalError{Reason: blockReason}}
+		} else if slices.Contains(geminiRefusalFinishReasons, finishReason) {
+			ch <- &ErrorEvent{Err: &RefusalError{Reason: finishReason}}
+		}
+	}()
+	return ch
+}
+
+func (g *Gemini) request(ctx context.Context, messages []Message, config streamConfig) (*http.Response, error) {
+	config = config.withContextBudget(messages)
+	payload := gemini_Request{
+		Contents: []gemini_Content{},
+		GenerationConfig: gemini_Request_GenerationConfig{
+			FrequencyPenalty: config.frequencyPenalty,
+			MaxOutputTokens:  config.maxTokens,
+			PresencePenalty:  config.presencePenalty,
+			Seed:             config.seed,
+			Temperature:      config.temperature,
+			TopP:             config.topP,
+		},
+	}
+	for _, msg := range messages {
+		if msg.Role == RoleSystem {
+			payload.SystemInstruction = &gemini_Content{Parts: []gemini_Part{{Text: msg.Content.Text()}}}
+			continue
+		}
+		var c gemini_Content
+		if err := c.from(msg); err != nil {
+			return nil, fmt.Errorf("error converting message: %w", err)
+		}
+		// parallel tool results must be sent back together, so consecutive contents of the same role are merged
+		if n := len(payload.Contents); n > 0 && payload.Contents[n-1].Role == c.Role {
+			payload.Contents[n-1].Parts = append(payload.Contents[n-1].Parts, c.Parts...)
+			continue
+		}
+		payload.Contents = append(payload.Contents, c)
+	}
+	if config.reasoningMaxTokens > 0 {
+		payload.GenerationConfig.ThinkingConfig = &gemini_Request_ThinkingConfig{
+			IncludeThoughts: true,
+			ThinkingBudget:  int(config.reasoningMaxTokens),
+		}
+	} else if config.reasoningEffort > 0 {
+		fractions := map[uint8]float64{1: 0.2, 2: 0.5, 3: 0.8}
+		payload.GenerationConfig.ThinkingConfig = &gemini_Request_ThinkingConfig{
+			IncludeThoughts: true,
+			ThinkingBudget:  int(math.Round(fractions[config.reasoningEffort] * float64(config.maxTokens))),
+		}
+	}
+	if len(config.responseFormat) > 0 {
+		payload.GenerationConfig.ResponseMimeType = "application/json"
+		payload.GenerationConfig.ResponseJSONSchema = config.responseFormat
+	}
+	if len(g.tools) > 0 {
+		declarations := make([]gemini_Request_FunctionDeclaration, len(g.tools))
+		for i, tool := range g.tools {
+			name, description, parameters := tool.Spec()
+			declarations[i] = gemini_Request_FunctionDeclaration{
+				Name:                 name,
+				Description:          description,
+				ParametersJSONSchema: parameters,
+			}
+		}
+		payload.Tools = []gemini_Request_Tool{{FunctionDeclarations: declarations}}
+	}
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(payload); err != nil {
+		return nil, fmt.Errorf("error marshalling request: %w", err)
+	}
+	g.logger.Debugj("Gemini request payload", data.Bytes())
+	endpoint := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", g.baseURL, url.PathEscape(g.model))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &data)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("x-goog-api-key", g.token)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: g.timeout}
+	return client.Do(req)
+}
+
+func (g *Gemini) generationConfig(opts ...StreamOption) streamConfig {
+	c := streamConfig{
+		maxTokens:            8192,
+		maxTurns:             1,
+		emptyResponseRetries: 1,
+		toolConcurrency:      defaultToolConcurrency,
+		reasoningEffort:      0,
+		reasoningMaxTokens:   0,
+		temperature:          1.0,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&c)
+		}
+	}
+	return c
+}
+
+func (g *Gemini) estimateCost(usage gemini_Chunk_UsageMetadata) float64 {
+	type costConfig struct {
+		inputTokens       float64
+		cachedInputTokens float64
+		outputTokens      float64
+	}
+	costs := map[string]costConfig{
+		"gemini-2.5-flash": {
+			inputTokens:       0.3,
+			cachedInputTokens: 0.075,
+			outputTokens:      2.5,
+		},
+		"gemini-2.5-pro": {
+			inputTokens:       1.25,
+			cachedInputTokens: 0.31,
+			outputTokens:      10.0,
+		},
+	}
+	var cost *costConfig
+	if p, ok := g.pricing[g.model]; ok {
+		cost = &costConfig{
+			inputTokens:       p.Input,
+			cachedInputTokens: p.Cached,
+			outputTokens:      p.Output,
+		}
+	} else if c, ok := costs[g.model]; ok {
+		cost = &c
+	} else {
+		g.logger.Errorf("no cost information available for model %s, using intentionally high default values", g.model)
+		cost = &costConfig{
+			inputTokens:       10 * costs["gemini-2.5-pro"].inputTokens,
+			cachedInputTokens: 10 * costs["gemini-2.5-pro"].cachedInputTokens,
+			outputTokens:      10 * costs["gemini-2.5-pro"].outputTokens,
+		}
+	}
+	uncachedInputTokens := usage.PromptTokenCount - usage.CachedContentTokenCount
+	// thinking tokens are billed as output tokens
+	outputTokens := usage.CandidatesTokenCount + usage.ThoughtsTokenCount
+	return float64(uncachedInputTokens)/1000000.0*cost.inputTokens +
+		float64(usage.CachedContentTokenCount)/1000000.0*cost.cachedInputTokens +
+		float64(outputTokens)/1000000.0*cost.outputTokens
+}
+
+// helper types ------------------------------------------------------------------------------------
+
+// messages
+type gemini_Part_InlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+type gemini_Part_FunctionCall struct {
+	ID   string          `json:"id,omitzero"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitzero"`
+}
+type gemini_Part_FunctionResponse struct {
+	ID       string         `json:"id,omitzero"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+type gemini_Part struct {
+	Text             string                        `json:"text,omitzero"`
+	Thought          bool                          `json:"thought,omitzero"`
+	InlineData       *gemini_Part_InlineData       `json:"inlineData,omitzero"`
+	FunctionCall     *gemini_Part_FunctionCall     `json:"functionCall,omitzero"`
+	FunctionResponse *gemini_Part_FunctionResponse `json:"functionResponse,omitzero"`
+}
+type gemini_Content struct {
+	Role  string        `json:"role,omitzero"`
+	Parts []gemini_Part `json:"parts"`
+}
+
+func (c *gemini_Content) from(msg Message) error {
+	switch msg.Role {
+	case RoleAssistant:
+		c.Role = "model"
+	case RoleTool:
+		// Gemini wants the result as an object, JSON objects are passed through and anything else is wrapped
+		var response map[string]any
+		if err := json.Unmarshal([]byte(msg.Content.Text()), &response); err != nil || response == nil {
+			response = map[string]any{"result": msg.Content.Text()}
+		}
+		c.Role = "user"
+		c.Parts = []gemini_Part{{FunctionResponse: &gemini_Part_FunctionResponse{
+			Name:     msg.Name,
+			Response: response,
+		}}}
+		return nil
+	case RoleUser:
+		c.Role = "user"
+	default:
+		return fmt.Errorf("unexpected message role: %s", msg.Role)
+	}
+	for _, part := range msg.Content {
+		switch p := part.(type) {
+		case ThinkingContentPart:
+			// thought summaries are not sent back, Gemini keeps its own reasoning state
+			continue
+		case TextContentPart:
+			if p.Text != "" {
+				c.Parts = append(c.Parts, gemini_Part{Text: p.Text})
+			}
+		case ImageContentPart:
+			inlineData, err := newGeminiInlineData(p.ImageURL)
+			if err != nil {
+				return fmt.Errorf("error converting image: %w", err)
+			}
+			c.Parts = append(c.Parts, gemini_Part{InlineData: inlineData})
+		case FileContentPart:
+			inlineData, err := newGeminiInlineData(p.FileData)
+			if err != nil {
+				return fmt.Errorf("error converting file %s: %w", p.FileName, err)
+			}
+			c.Parts = append(c.Parts, gemini_Part{InlineData: inlineData})
+		default:
+			return fmt.Errorf("unexpected content part type: %T", part)
+		}
+	}
+	for _, tc := range msg.ToolCalls {
+		args := "{}"
+		if tc.Function.Args != "" {
+			args = tc.Function.Args
+		}
+		c.Parts = append(c.Parts, gemini_Part{FunctionCall: &gemini_Part_FunctionCall{
+			Name: tc.Function.Name,
+			Args: json.RawMessage(args),
+		}})
+	}
+	// Gemini rejects contents without any parts
+	if len(c.Parts) == 0 {
+		c.Parts = []gemini_Part{{Text: " "}}
+	}
+	return nil
+}
+
+func newGeminiInlineData(dataURL string) (*gemini_Part_InlineData, error) {
+	header, data, ok := strings.Cut(dataURL, ",")
+	if !ok || !strings.HasPrefix(header, "data:") || !strings.HasSuffix(header, ";base64") {
+		return nil, errors.New("only base64 data URLs are supported by Gemini")
+	}
+	mimeType := strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64")
+	return &gemini_Part_InlineData{MimeType: mimeType, Data: data}, nil
+}
+
+// requests
+type gemini_Request_ThinkingConfig struct {
+	IncludeThoughts bool `json:"includeThoughts"`
+	ThinkingBudget  int  `json:"thinkingBudget"`
+}
+type gemini_Request_GenerationConfig struct {
+	FrequencyPenalty   *float64                       `json:"frequencyPenalty,omitempty"`
+	MaxOutputTokens    int                            `json:"maxOutputTokens,omitzero"`
+	PresencePenalty    *float64                       `json:"presencePenalty,omitempty"`
+	ResponseJSONSchema json.RawMessage                `json:"responseJsonSchema,omitzero"`
+	ResponseMimeType   string                         `json:"responseMimeType,omitzero"`
+	Seed               *int                           `json:"seed,omitempty"`
+	Temperature        float64                        `json:"temperature"`
+	ThinkingConfig     *gemini_Request_ThinkingConfig `json:"thinkingConfig,omitzero"`
+	TopP               *float64                       `json:"topP,omitempty"`
+}
+type gemini_Request_FunctionDeclaration struct {
+	Name                 string          `json:"name"`
+	Description          string          `json:"description"`
+	ParametersJSONSchema json.RawMessage `json:"parametersJsonSchema,omitzero"`
+}
+type gemini_Request_Tool struct {
+	FunctionDeclarations []gemini_Request_FunctionDeclaration `json:"functionDeclarations"`
+}
+type gemini_Request struct {
+	Contents          []gemini_Content                `json:"contents"`
+	GenerationConfig  gemini_Request_GenerationConfig `json:"generationConfig"`
+	SystemInstruction *gemini_Content                 `json:"systemInstruction,omitzero"`
+	Tools             []gemini_Request_Tool           `json:"tools,omitzero"`
+}
+
+// stream responses
+type gemini_Chunk_Candidate struct {
+	Content      *gemini_Content `json:"content"`
+	FinishReason string          `json:"finishReason"`
+}
+type gemini_Chunk_PromptFeedback struct {
+	BlockReason string `json:"blockReason"`
+}
+type gemini_Chunk_UsageMetadata struct {
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	PromptTokenCount        int `json:"promptTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+}
+type gemini_Chunk_Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+type gemini_Chunk struct {
+	Candidates     []gemini_Chunk_Candidate     `json:"candidates"`
+	Error          *gemini_Chunk_Error          `json:"error"`
+	ModelVersion   string                       `json:"modelVersion"`
+	PromptFeedback *gemini_Chunk_PromptFeedback `json:"promptFeedback"`
+	UsageMetadata  *gemini_Chunk_UsageMetadata  `json:"usageMetadata"`
+}
@@ -0,0 +1,431 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/markusylisiurunen/ikm/internal/logger"
+	"golang.org/x/sync/errgroup"
+)
+
+var _ Model = (*Mistral)(nil)
+
+type MistralOption func(*Mistral)
+
+type Mistral struct {
+	logger  logger.Logger
+	token   string
+	model   string
+	tools   []Tool
+	pricing PricingTable
+	timeout time.Duration
+}
+
+func WithMistralPricing(pricing PricingTable) MistralOption {
+	return func(m *Mistral) {
+		m.pricing = pricing
+	}
+}
+
+func WithMistralHTTPTimeout(timeout time.Duration) MistralOption {
+	return func(m *Mistral) {
+		if timeout > 0 {
+			m.timeout = timeout
+		}
+	}
+}
+
+func NewMistral(logger logger.Logger, token, model string, opts ...MistralOption) *Mistral {
+	m := &Mistral{logger: logger, token: token, model: model, timeout: defaultHTTPTimeout}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *Mistral) Register(tool Tool) {
+	if tool != nil {
+		m.tools = append(m.tools, tool)
+	}
+}
+
+func (m *Mistral) Stream(ctx context.Context, messages []Message, opts ...StreamOption) <-chan Event {
+	config := m.generationConfig(opts...)
+	return m.streamTurns(ctx, messages, config)
+}
+func (m *Mistral) streamTurns(ctx context.Context, messages []Message, config streamConfig) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		cloned := make([]Message, len(messages))
+		copy(cloned, messages)
+		for turn := range config.maxTurns {
+			select {
+			case <-ctx.Done():
+				ch <- &ErrorEvent{Err: ctx.Err()}
+				return
+			default:
+			}
+			var messages []Message
+			for attempt := 0; ; attempt++ {
+				out := tee(m.streamTurn(ctx, withEmptyResponseNudge(cloned, attempt), config), ch)
+				builder := newMessageBuilder()
+				for event := range out {
+					builder.process(event)
+				}
+				var err error
+				messages, _, err = builder.result()
+				if err != nil {
+					ch <- &ErrorEvent{Err: fmt.Errorf("error processing events: %w", err)}
+					return
+				}
+				if isEmptyResponse(messages) {
+					if attempt < config.emptyResponseRetries {
+						m.logger.Errorf("Mistral returned an empty response, retrying the turn")
+						continue
+					}
+					ch <- &ErrorEvent{Err: ErrEmptyResponse}
+					return
+				}
+				if len(messages) != 1 {
+					ch <- &ErrorEvent{Err: fmt.Errorf("expected exactly one message, got %d", len(messages))}
+					return
+				}
+				break
+			}
+			if len(messages[0].ToolCalls) == 0 {
+				return
+			}
+			cloned = append(cloned, messages[0])
+			if len(messages[0].ToolCalls) > 0 {
+				toolResultEvents := make([]*ToolResultEvent, len(messages[0].ToolCalls))
+				g, gctx := errgroup.WithContext(ctx)
+				g.SetLimit(config.toolConcurrency)
+				for idx, toolCall := range messages[0].ToolCalls {
+					g.Go(func() error {
+						var tool Tool
+						for _, t := range m.tools {
+							if name, _, _ := t.Spec(); name == toolCall.Function.Name {
+								tool = t
+								break
+							}
+						}
+						if tool == nil {
+							return fmt.Errorf("tool %s not found", toolCall.Function.Name)
+						}
+						// arguments that do not match the schema are sent back to the model before anyone is asked to approve them
+						_, _, schema := tool.Spec()
+						if err := ValidateToolArgs(schema, toolCall.Function.Args); err != nil {
+							toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: invalidToolCallResult(err)}
+							return nil
+						}
+						if err := config.approveToolCall(gctx, toolCall.Function.Name, toolCall.Function.Args); err != nil {
+							toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: deniedToolCallResult(err)}
+							return nil
+						}
+						result, err := tool.Call(withToolCallID(gctx, toolCall.ID), toolCall.Function.Args)
+						toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: result, Error: err}
+						return nil
+					})
+				}
+				if err := g.Wait(); err != nil {
+					ch <- &ErrorEvent{Err: fmt.Errorf("error executing tool calls: %w", err)}
+					return
+				}
+				for idx, event := range toolResultEvents {
+					if event == nil {
+						ch <- &ErrorEvent{Err: fmt.Errorf("tool call %d result is nil", idx)}
+						return
+					}
+					ch <- event
+					msg := Message{
+						Role:       RoleTool,
+						Name:       messages[0].ToolCalls[idx].Function.Name,
+						ToolCallID: messages[0].ToolCalls[idx].ID,
+					}
+					if event.Error != nil {
+						msg.Content = ContentParts{NewTextContentPart("Error: " + event.Error.Error())}
+					} else {
+						msg.Content = ContentParts{NewTextContentPart(event.Result)}
+					}
+					cloned = append(cloned, msg)
+				}
+			}
+			if config.stopCondition != nil && config.stopCondition(turn, cloned) {
+				return
+			}
+			if turn >= config.maxTurns-1 {
+				// the model still has tool results to respond to, let the caller know why the loop stopped
+				ch <- &MaxTurnsReachedEvent{MaxTurns: config.maxTurns}
+				return
+			}
+		}
+	}()
+	return ch
+}
+func (m *Mistral) streamTurn(ctx context.Context, messages []Message, config streamConfig) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		resp, err := m.request(ctx, messages, config)
+		if err != nil {
+			ch <- &ErrorEvent{Err: err}
+			return
+		}
+		defer resp.Body.Close() //nolint:errcheck
+		if resp.StatusCode != http.StatusOK {
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				ch <- &ErrorEvent{Err: fmt.Errorf("error reading response body: %w", err)}
+			} else {
+				ch <- &ErrorEvent{Err: fmt.Errorf("non-ok status (%d) from Mistral: %s", resp.StatusCode, string(body))}
+			}
+			return
+		}
+		var toolCallBuffer []*ToolUseEvent
+		var finishReason string
+		reader := newSSEReader(resp.Body)
+		for {
+			event, err := reader.next()
+			select {
+			case <-ctx.Done():
+				ch <- &ErrorEvent{Err: ctx.Err()}
+				return
+			default:
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				ch <- &ErrorEvent{Err: fmt.Errorf("error reading stream: %w", err)}
+				return
+			}
+			raw := strings.TrimSpace(event.data)
+			if raw == "" {
+				continue
+			}
+			if raw == "[DONE]" {
+				break
+			}
+			var chunk mistral_Chunk
+			if err := json.Unmarshal([]byte(raw), &chunk); err != nil {
+				m.logger.Errorf("failed to parse Mistral chunk: %v", err)
+				continue
+			}
+			if chunk.Usage != nil {
+				ch <- &UsageEvent{Usage: Usage{
+					PromptTokens:     chunk.Usage.PromptTokens,
+					CompletionTokens: chunk.Usage.CompletionTokens,
+					TotalCost:        m.estimateCost(*chunk.Usage),
+				}}
+			}
+			if len(chunk.Choices) == 0 {
+				continue
+			}
+			choice := chunk.Choices[0]
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+			if choice.Delta == nil {
+				continue
+			}
+			if choice.Delta.Content != "" {
+				ch <- &ContentDeltaEvent{Content: choice.Delta.Content}
+			}
+			for _, toolCall := range choice.Delta.ToolCalls {
+				if toolCall.Function == nil {
+					continue
+				}
+				index := toolCall.Index
+				if index < 0 {
+					m.logger.Errorf("Mistral tool call index out of range: %d", index)
+					continue
+				}
+				if index >= len(toolCallBuffer) {
+					toolCallBuffer = append(toolCallBuffer, make([]*ToolUseEvent, index-len(toolCallBuffer)+1)...)
+				}
+				if toolCallBuffer[index] == nil {
+					toolCallBuffer[index] = &ToolUseEvent{
+						ID:       toolCall.ID,
+						Index:    index,
+						FuncName: toolCall.Function.Name,
+						FuncArgs: toolCall.Function.Arguments,
+					}
+				} else {
+					toolCallBuffer[index].FuncArgs += toolCall.Function.Arguments
+				}
+			}
+		}
+		for _, toolCall := range toolCallBuffer {
+			if toolCall != nil {
+				ch <- toolCall
+			}
+		}
+		if finishReason == "content_filter" {
+			ch <- &ErrorEvent{Err: &RefusalError{Reason: finishReason}}
+		}
+	}()
+	return ch
+}
+
+func (m *Mistral) request(ctx context.Context, messages []Message, config streamConfig) (*http.Response, error) {
+	config = config.withContextBudget(messages)
+	payload := mistral_Request{
+		FrequencyPenalty: config.frequencyPenalty,
+		MaxTokens:        config.maxTokens,
+		Messages:         []openRouter_Message{},
+		Model:            m.model,
+		PresencePenalty:  config.presencePenalty,
+		RandomSeed:       config.seed,
+		Stream:           true,
+		Temperature:      config.temperature,
+		Tools:            nil,
+		TopP:             config.topP,
+	}
+	// Mistral only accepts 9 character alphanumeric tool call IDs
+	idTransform := openRouterHexadecimalToolCallIDRequestTransform{}
+	for _, msg := range messages {
+		var om openRouter_Message
+		if err := om.from(msg); err != nil {
+			return nil, fmt.Errorf("error converting message: %w", err)
+		}
+		idTransform.transformMessage(&om)
+		payload.Messages = append(payload.Messages, om)
+	}
+	if config.reasoningEffort > 0 || config.reasoningMaxTokens > 0 {
+		m.logger.Debugf("reasoning is not supported by Mistral, ignoring")
+	}
+	if len(config.responseFormat) > 0 {
+		payload.ResponseFormat = newOpenRouterResponseFormat(config.responseFormat)
+	}
+	if len(m.tools) > 0 {
+		payload.Tools = make([]openRouter_Request_Tool, len(m.tools))
+		for i, tool := range m.tools {
+			name, description, parameters := tool.Spec()
+			payload.Tools[i] = openRouter_Request_Tool{
+				Type: "function",
+				Function: &openRouter_Request_Tool_Function{
+					Name:        name,
+					Description: description,
+					Parameters:  parameters,
+				},
+			}
+		}
+	}
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(payload); err != nil {
+		return nil, fmt.Errorf("error marshalling request: %w", err)
+	}
+	m.logger.Debugj("Mistral request payload", data.Bytes())
+	req, err := http.NewRequestWithContext(ctx,
+		http.MethodPost, "https://api.mistral.ai/v1/chat/completions", &data)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: m.timeout}
+	return client.Do(req)
+}
+
+func (m *Mistral) generationConfig(opts ...StreamOption) streamConfig {
+	c := streamConfig{
+		maxTokens:            8192,
+		maxTurns:             1,
+		emptyResponseRetries: 1,
+		toolConcurrency:      defaultToolConcurrency,
+		reasoningEffort:      0,
+		reasoningMaxTokens:   0,
+		temperature:          0.7,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&c)
+		}
+	}
+	return c
+}
+
+func (m *Mistral) estimateCost(usage mistral_Chunk_Usage) float64 {
+	type costConfig struct {
+		inputTokens  float64
+		outputTokens float64
+	}
+	costs := map[string]costConfig{
+		"devstral-small-2505": {
+			inputTokens:  0.1,
+			outputTokens: 0.3,
+		},
+		"devstral-medium-2507": {
+			inputTokens:  0.4,
+			outputTokens: 2.0,
+		},
+	}
+	var cost *costConfig
+	if p, ok := m.pricing[m.model]; ok {
+		cost = &costConfig{
+			inputTokens:  p.Input,
+			outputTokens: p.Output,
+		}
+	} else if c, ok := costs[m.model]; ok {
+		cost = &c
+	} else {
+		m.logger.Errorf("no cost information available for model %s, using intentionally high default values", m.model)
+		cost = &costConfig{
+			inputTokens:  10 * costs["devstral-medium-2507"].inputTokens,
+			outputTokens: 10 * costs["devstral-medium-2507"].outputTokens,
+		}
+	}
+	millionInputTokens := float64(usage.PromptTokens) / 1000000.0
+	millionOutputTokens := float64(usage.CompletionTokens) / 1000000.0
+	return millionInputTokens*cost.inputTokens + millionOutputTokens*cost.outputTokens
+}
+
+// helper types ------------------------------------------------------------------------------------
+
+// requests
+type mistral_Request struct {
+	FrequencyPenalty *float64                           `json:"frequency_penalty,omitempty"`
+	MaxTokens        int                                `json:"max_tokens"`
+	Messages         []openRouter_Message               `json:"messages"`
+	Model            string                             `json:"model"`
+	PresencePenalty  *float64                           `json:"presence_penalty,omitempty"`
+	RandomSeed       *int                               `json:"random_seed,omitempty"`
+	ResponseFormat   *openRouter_Request_ResponseFormat `json:"response_format,omitempty"`
+	Stream           bool                               `json:"stream"`
+	Temperature      float64                            `json:"temperature"`
+	Tools            []openRouter_Request_Tool          `json:"tools,omitempty"`
+	TopP             *float64                           `json:"top_p,omitempty"`
+}
+
+// stream responses
+type mistral_Chunk_Choice_Delta struct {
+	Role      string                        `json:"role"`
+	Content   string                        `json:"content"`
+	ToolCalls []openRouter_Message_ToolCall `json:"tool_calls"`
+}
+type mistral_Chunk_Choice struct {
+	Delta        *mistral_Chunk_Choice_Delta `json:"delta"`
+	FinishReason *string                     `json:"finish_reason"`
+}
+
+type mistral_Chunk_Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type mistral_Chunk struct {
+	Choices []mistral_Chunk_Choice `json:"choices"`
+	ID      string                 `json:"id"`
+	Model   string                 `json:"model"`
+	Usage   *mistral_Chunk_Usage   `json:"usage"`
+}
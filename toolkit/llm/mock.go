@@ -0,0 +1,77 @@
+package llm
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+var _ Model = (*Mock)(nil)
+
+type MockOption func(*Mock)
+
+type Mock struct {
+	mux       sync.Mutex
+	script    []Event
+	responder func(messages []Message) []Event
+	tools     []Tool
+	calls     [][]Message
+}
+
+func WithMockResponder(responder func(messages []Message) []Event) MockOption {
+	return func(m *Mock) {
+		m.responder = responder
+	}
+}
+
+func NewMock(script []Event, opts ...MockOption) *Mock {
+	m := &Mock{script: script}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *Mock) Register(tool Tool) {
+	if tool != nil {
+		m.mux.Lock()
+		m.tools = append(m.tools, tool)
+		m.mux.Unlock()
+	}
+}
+
+func (m *Mock) Tools() []Tool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return slices.Clone(m.tools)
+}
+
+func (m *Mock) Calls() [][]Message {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return slices.Clone(m.calls)
+}
+
+func (m *Mock) Stream(ctx context.Context, messages []Message, opts ...StreamOption) <-chan Event {
+	m.mux.Lock()
+	m.calls = append(m.calls, slices.Clone(messages))
+	// the events are replayed as is, tool calls in the script are not executed
+	script := m.script
+	if m.responder != nil {
+		script = m.responder(messages)
+	}
+	m.mux.Unlock()
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		for _, event := range script {
+			select {
+			case <-ctx.Done():
+				ch <- &ErrorEvent{Err: ctx.Err()}
+				return
+			case ch <- event:
+			}
+		}
+	}()
+	return ch
+}
@@ -2,7 +2,11 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
 	"strings"
+	"time"
 )
 
 // events ------------------------------------------------------------------------------------------
@@ -10,8 +14,9 @@ import (
 type Event any
 
 type ThinkingDeltaEvent struct {
-	Thinking  string
-	Signature string
+	Thinking         string
+	Signature        string
+	EncryptedContent string
 }
 
 type ContentDeltaEvent struct {
@@ -35,6 +40,14 @@ type UsageEvent struct {
 	Usage Usage
 }
 
+type FinishEvent struct {
+	Reason string // as reported by the provider, e.g. "stop" or "length"
+}
+
+type MaxTurnsReachedEvent struct {
+	MaxTurns int
+}
+
 type ErrorEvent struct {
 	Err error
 }
@@ -75,6 +88,8 @@ type ThinkingContentPart struct {
 	Type      string
 	Thinking  string
 	Signature string
+	// the encrypted reasoning of OpenAI, kept apart from the signatures of Anthropic which it would not accept
+	EncryptedContent string `json:",omitempty"`
 }
 
 func NewThinkingContentPart(thinking, signature string) ThinkingContentPart {
@@ -140,6 +155,48 @@ func (c ContentParts) Text() string {
 	return sb.String()
 }
 
+func (c *ContentParts) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("error unmarshalling content parts: %w", err)
+	}
+	parts := make(ContentParts, 0, len(raw))
+	for _, item := range raw {
+		var head struct{ Type string }
+		if err := json.Unmarshal(item, &head); err != nil {
+			return fmt.Errorf("error unmarshalling content part: %w", err)
+		}
+		var part ContentPart
+		var err error
+		switch head.Type {
+		case "text":
+			part, err = unmarshalContentPart[TextContentPart](item)
+		case "thinking":
+			part, err = unmarshalContentPart[ThinkingContentPart](item)
+		case "image_url":
+			part, err = unmarshalContentPart[ImageContentPart](item)
+		case "file":
+			part, err = unmarshalContentPart[FileContentPart](item)
+		default:
+			return fmt.Errorf("unknown content part type: %q", head.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("error unmarshalling %s content part: %w", head.Type, err)
+		}
+		parts = append(parts, part)
+	}
+	*c = parts
+	return nil
+}
+
+func unmarshalContentPart[T ContentPart](data []byte) (ContentPart, error) {
+	var part T
+	if err := json.Unmarshal(data, &part); err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
 type Message struct {
 	Role       Role
 	Content    ContentParts
@@ -158,13 +215,36 @@ type Usage struct {
 
 type StopCondition func(turn int, history []Message) bool
 
+type ToolApprover func(ctx context.Context, name, args string) error
+
+// providers use this timeout for their HTTP requests unless configured otherwise
+const defaultHTTPTimeout = 300 * time.Second
+
+// the tool calls of one message run in parallel, but only this many at a time (e.g. bash calls each start a container)
+const defaultToolConcurrency = 4
+
+// a denied tool call is answered with an error result so that the model can adapt instead of failing
+func deniedToolCallResult(err error) string {
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(data)
+}
+
 type streamConfig struct {
-	maxTokens          int
-	maxTurns           int
-	reasoningEffort    uint8
-	reasoningMaxTokens uint
-	stopCondition      StopCondition
-	temperature        float64
+	contextLength        int
+	emptyResponseRetries int
+	frequencyPenalty     *float64
+	maxTokens            int
+	maxTurns             int
+	reasoningEffort      uint8
+	presencePenalty      *float64
+	reasoningMaxTokens   uint
+	responseFormat       json.RawMessage
+	seed                 *int
+	stopCondition        StopCondition
+	temperature          float64
+	toolApprover         ToolApprover
+	toolConcurrency      int
+	topP                 *float64
 }
 
 type StreamOption func(*streamConfig)
@@ -172,6 +252,21 @@ type StreamOption func(*streamConfig)
 func WithMaxTokens(maxTokens int) StreamOption {
 	return func(c *streamConfig) { c.maxTokens = maxTokens }
 }
+func WithEmptyResponseRetries(retries int) StreamOption {
+	return func(c *streamConfig) { c.emptyResponseRetries = max(retries, 0) }
+}
+func WithMaxTokensFromContext(contextLength int) StreamOption {
+	return func(c *streamConfig) { c.contextLength = contextLength }
+}
+func WithToolConcurrency(n int) StreamOption {
+	return func(c *streamConfig) {
+		// a non-positive limit lets all tool calls run at once
+		if n <= 0 {
+			n = -1
+		}
+		c.toolConcurrency = n
+	}
+}
 func WithMaxTurns(maxTurns int) StreamOption {
 	return func(c *streamConfig) { c.maxTurns = maxTurns }
 }
@@ -187,13 +282,92 @@ func WithReasoningEffortHigh() StreamOption {
 func WithReasoningMaxTokens(maxTokens uint) StreamOption {
 	return func(c *streamConfig) { c.reasoningMaxTokens = maxTokens }
 }
+func WithResponseFormatJSON(schema json.RawMessage) StreamOption {
+	return func(c *streamConfig) { c.responseFormat = schema }
+}
 func WithTemperature(temperature float64) StreamOption {
 	return func(c *streamConfig) { c.temperature = temperature }
 }
+func WithTopP(topP float64) StreamOption {
+	return func(c *streamConfig) { c.topP = &topP }
+}
+func WithFrequencyPenalty(penalty float64) StreamOption {
+	return func(c *streamConfig) { c.frequencyPenalty = &penalty }
+}
+func WithPresencePenalty(penalty float64) StreamOption {
+	return func(c *streamConfig) { c.presencePenalty = &penalty }
+}
+func WithSeed(seed int) StreamOption {
+	return func(c *streamConfig) { c.seed = &seed }
+}
+func WithToolApprover(approver ToolApprover) StreamOption {
+	return func(c *streamConfig) { c.toolApprover = approver }
+}
 func WithStopCondition(condition StopCondition) StreamOption {
 	return func(c *streamConfig) { c.stopCondition = condition }
 }
 
+const (
+	contextBudgetMinTokens     = 1_024
+	contextBudgetMarginPercent = 5
+)
+
+func (c streamConfig) approveToolCall(ctx context.Context, name, args string) error {
+	if c.toolApprover == nil {
+		return nil
+	}
+	return c.toolApprover(ctx, name, args)
+}
+
+func (c streamConfig) withContextBudget(messages []Message) streamConfig {
+	if c.contextLength <= 0 {
+		return c
+	}
+	// with a known context window the output budget is whatever the prompt leaves free, never more than max tokens
+	promptTokens := EstimateTokens(NewCharTokenEstimator(), messages)
+	c.maxTokens = contextBudget(c.contextLength, promptTokens, c.maxTokens)
+	return c
+}
+
+func contextBudget(contextLength, promptTokens, maxTokens int) int {
+	// the estimate is rough and tool definitions are not counted, so part of the window is kept as a margin
+	budget := max(contextLength-promptTokens-contextLength*contextBudgetMarginPercent/100, contextBudgetMinTokens)
+	// the floor only applies to the estimate, an explicitly lower max tokens is kept
+	if maxTokens > 0 {
+		return min(budget, maxTokens)
+	}
+	return budget
+}
+
+// an empty response is retried with this message appended, it is never kept in the history
+const emptyResponseNudge = "Your previous response was empty. Continue with the task: respond to the user or call a tool."
+
+func isEmptyResponse(messages []Message) bool {
+	if len(messages) == 0 {
+		return true
+	}
+	if len(messages) != 1 || len(messages[0].ToolCalls) > 0 || strings.TrimSpace(messages[0].Content.Text()) != "" {
+		return false
+	}
+	// a turn that only thought is still a response, only an opaque signature without any thinking is not
+	for _, part := range messages[0].Content {
+		if p, ok := part.(ThinkingContentPart); ok && strings.TrimSpace(p.Thinking) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func withEmptyResponseNudge(messages []Message, attempt int) []Message {
+	if attempt == 0 {
+		return messages
+	}
+	return append(slices.Clone(messages), Message{
+		Role:    RoleUser,
+		Content: ContentParts{NewTextContentPart(emptyResponseNudge)},
+	})
+}
+
 type Model interface {
 	Register(tool Tool)
 	Stream(ctx context.Context, messages []Message, opts ...StreamOption) <-chan Event
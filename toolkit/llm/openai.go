@@ -1,7 +1,6 @@
 package llm
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -22,20 +21,55 @@ var _ Model = (*OpenAI)(nil)
 type OpenAIOption func(*OpenAI)
 
 type OpenAI struct {
-	logger logger.Logger
-	token  string
-	user   string
-	model  string
-	tools  []Tool
-	usage  *openai_Usage
+	logger  logger.Logger
+	baseURL string
+	token   string
+	user    string
+	model   string
+	tools   []Tool
+	pricing PricingTable
+	timeout time.Duration
+	summary string
+	usage   *openai_Usage
+}
+
+func WithOpenAIBaseURL(baseURL string) OpenAIOption {
+	return func(o *OpenAI) {
+		if baseURL != "" {
+			o.baseURL = strings.TrimSuffix(baseURL, "/")
+		}
+	}
+}
+
+func WithOpenAIPricing(pricing PricingTable) OpenAIOption {
+	return func(o *OpenAI) {
+		o.pricing = pricing
+	}
+}
+
+func WithOpenAIHTTPTimeout(timeout time.Duration) OpenAIOption {
+	return func(o *OpenAI) {
+		if timeout > 0 {
+			o.timeout = timeout
+		}
+	}
+}
+
+func WithOpenAIReasoningSummary(summary string) OpenAIOption {
+	return func(o *OpenAI) {
+		// summaries are only generated for verified organizations, so they are not asked for by default
+		o.summary = summary
+	}
 }
 
 func NewOpenAI(logger logger.Logger, token, model string, opts ...OpenAIOption) *OpenAI {
 	o := &OpenAI{
-		logger: logger,
-		token:  token,
-		user:   fmt.Sprintf("%d", time.Now().Unix()),
-		model:  model,
+		logger:  logger,
+		baseURL: "https://api.openai.com/v1",
+		token:   token,
+		user:    fmt.Sprintf("%d", time.Now().Unix()),
+		model:   model,
+		timeout: defaultHTTPTimeout,
 	}
 	for _, opt := range opts {
 		opt(o)
@@ -66,19 +100,32 @@ func (o *OpenAI) streamTurns(ctx context.Context, messages []Message, config str
 				return
 			default:
 			}
-			out := tee(o.streamTurn(ctx, cloned, config), ch)
-			builder := newMessageBuilder()
-			for event := range out {
-				builder.process(event)
-			}
-			messages, _, err := builder.result()
-			if err != nil {
-				ch <- &ErrorEvent{Err: fmt.Errorf("error processing events: %w", err)}
-				return
-			}
-			if len(messages) != 1 {
-				ch <- &ErrorEvent{Err: fmt.Errorf("expected exactly one message, got %d", len(messages))}
-				return
+			var messages []Message
+			for attempt := 0; ; attempt++ {
+				out := tee(o.streamTurn(ctx, withEmptyResponseNudge(cloned, attempt), config), ch)
+				builder := newMessageBuilder()
+				for event := range out {
+					builder.process(event)
+				}
+				var err error
+				messages, _, err = builder.result()
+				if err != nil {
+					ch <- &ErrorEvent{Err: fmt.Errorf("error processing events: %w", err)}
+					return
+				}
+				if isEmptyResponse(messages) {
+					if attempt < config.emptyResponseRetries {
+						o.logger.Errorf("OpenAI returned an empty response, retrying the turn")
+						continue
+					}
+					ch <- &ErrorEvent{Err: ErrEmptyResponse}
+					return
+				}
+				if len(messages) != 1 {
+					ch <- &ErrorEvent{Err: fmt.Errorf("expected exactly one message, got %d", len(messages))}
+					return
+				}
+				break
 			}
 			if len(messages[0].ToolCalls) == 0 {
 				return
@@ -87,6 +134,7 @@ func (o *OpenAI) streamTurns(ctx context.Context, messages []Message, config str
 			if len(messages[0].ToolCalls) > 0 {
 				toolResultEvents := make([]*ToolResultEvent, len(messages[0].ToolCalls))
 				g, gctx := errgroup.WithContext(ctx)
+				g.SetLimit(config.toolConcurrency)
 				for idx, toolCall := range messages[0].ToolCalls {
 					g.Go(func() error {
 						var tool Tool
@@ -99,7 +147,17 @@ func (o *OpenAI) streamTurns(ctx context.Context, messages []Message, config str
 						if tool == nil {
 							return fmt.Errorf("tool %s not found", toolCall.Function.Name)
 						}
-						result, err := tool.Call(gctx, toolCall.Function.Args)
+						// arguments that do not match the schema are sent back to the model before anyone is asked to approve them
+						_, _, schema := tool.Spec()
+						if err := ValidateToolArgs(schema, toolCall.Function.Args); err != nil {
+							toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: invalidToolCallResult(err)}
+							return nil
+						}
+						if err := config.approveToolCall(gctx, toolCall.Function.Name, toolCall.Function.Args); err != nil {
+							toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: deniedToolCallResult(err)}
+							return nil
+						}
+						result, err := tool.Call(withToolCallID(gctx, toolCall.ID), toolCall.Function.Args)
 						toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: result, Error: err}
 						return nil
 					})
@@ -127,7 +185,12 @@ func (o *OpenAI) streamTurns(ctx context.Context, messages []Message, config str
 					cloned = append(cloned, msg)
 				}
 			}
-			if turn >= config.maxTurns-1 || (config.stopCondition != nil && config.stopCondition(turn, cloned)) {
+			if config.stopCondition != nil && config.stopCondition(turn, cloned) {
+				return
+			}
+			if turn >= config.maxTurns-1 {
+				// the model still has tool results to respond to, let the caller know why the loop stopped
+				ch <- &MaxTurnsReachedEvent{MaxTurns: config.maxTurns}
 				return
 			}
 		}
@@ -155,11 +218,9 @@ func (o *OpenAI) streamTurn(ctx context.Context, messages []Message, config stre
 			return
 		}
 		toolCallBuffer := make([]*ToolUseEvent, 32)
-		var currentEvent string
-		var currentData string
-		reader := bufio.NewReader(resp.Body)
+		reader := newSSEReader(resp.Body)
 		for {
-			line, err := reader.ReadString('\n')
+			event, err := reader.next()
 			select {
 			case <-ctx.Done():
 				ch <- &ErrorEvent{Err: ctx.Err()}
@@ -172,29 +233,16 @@ func (o *OpenAI) streamTurn(ctx context.Context, messages []Message, config stre
 				ch <- &ErrorEvent{Err: fmt.Errorf("error reading stream: %w", err)}
 				return
 			}
-			line = strings.TrimSpace(line)
-			if line == "" {
-				if currentEvent != "" && currentData != "" {
-					o.processSSEEvent(currentEvent, currentData, ch, toolCallBuffer)
-				}
-				currentEvent = ""
-				currentData = ""
-				continue
-			}
-			if after, ok := strings.CutPrefix(line, "event: "); ok {
-				currentEvent = after
-			} else if after, ok := strings.CutPrefix(line, "data: "); ok {
-				currentData = after
+			if event.name != "" && event.data != "" {
+				o.processSSEEvent(event.name, event.data, ch, toolCallBuffer)
 			}
 		}
-		if currentEvent != "" && currentData != "" {
-			o.processSSEEvent(currentEvent, currentData, ch, toolCallBuffer)
-		}
 	}()
 	return ch
 }
 
 func (o *OpenAI) request(ctx context.Context, messages []Message, config streamConfig) (*http.Response, error) {
+	config = config.withContextBudget(messages)
 	payload := openai_Request{
 		Include:         []string{"reasoning.encrypted_content"},
 		Input:           []openai_Message{},
@@ -206,6 +254,7 @@ func (o *OpenAI) request(ctx context.Context, messages []Message, config streamC
 		Stream:          true,
 		Temperature:     config.temperature,
 		Tools:           nil,
+		TopP:            config.topP,
 		User:            o.user,
 	}
 	for _, msg := range messages {
@@ -213,42 +262,51 @@ func (o *OpenAI) request(ctx context.Context, messages []Message, config streamC
 			payload.Instructions = msg.Content.Text()
 			continue
 		}
-		msgCopy := msg
-		msgCopyToolCalls := make([]ToolCall, len(msg.ToolCalls))
-		copy(msgCopyToolCalls, msg.ToolCalls)
-		msgCopy.ToolCalls = nil
 		// inject the original message
-		if msgCopy.Content.Text() != "" {
+		if msg.Content.Text() != "" {
 			var rootInput openai_Message
-			if err := rootInput.from(msgCopy); err != nil {
+			if err := rootInput.from(msg); err != nil {
 				return nil, fmt.Errorf("error converting message: %w", err)
 			}
 			payload.Input = append(payload.Input, rootInput)
 		}
-		// inject the potential tool calls
-		for _, toolCall := range msgCopyToolCalls {
-			msgCopy.ToolCalls = []ToolCall{toolCall}
+		// inject the potential tool calls, each as its own input item
+		for _, toolCall := range msg.ToolCalls {
 			var toolInput openai_Message
-			if err := toolInput.from(msgCopy); err != nil {
-				return nil, fmt.Errorf("error converting tool call message: %w", err)
-			}
+			toolInput.fromToolCall(toolCall)
 			payload.Input = append(payload.Input, toolInput)
 		}
 	}
 	if config.reasoningEffort > 0 {
 		switch config.reasoningEffort {
 		case 1:
-			payload.Reasoning = &openai_Request_Reasoning{Effort: "low"}
+			payload.Reasoning = &openai_Request_Reasoning{Effort: "low", Summary: o.summary}
 		case 2:
-			payload.Reasoning = &openai_Request_Reasoning{Effort: "medium"}
+			payload.Reasoning = &openai_Request_Reasoning{Effort: "medium", Summary: o.summary}
 		case 3:
-			payload.Reasoning = &openai_Request_Reasoning{Effort: "high"}
+			payload.Reasoning = &openai_Request_Reasoning{Effort: "high", Summary: o.summary}
 		default:
 			o.logger.Errorf("invalid reasoning effort: %d, must be 1, 2, or 3", config.reasoningEffort)
 		}
 	} else if config.reasoningMaxTokens > 0 {
 		return nil, fmt.Errorf("reasoningMaxTokens is not supported by OpenAI, use reasoningEffort instead")
 	}
+	if config.frequencyPenalty != nil || config.presencePenalty != nil {
+		o.logger.Debugf("frequency and presence penalties are not supported by the OpenAI Responses API, ignoring")
+	}
+	if config.seed != nil {
+		o.logger.Debugf("seed is not supported by the OpenAI Responses API, ignoring")
+	}
+	if len(config.responseFormat) > 0 {
+		payload.Text = &openai_Request_Text{
+			Format: openai_Request_Text_Format{
+				Type:   "json_schema",
+				Name:   "response",
+				Schema: config.responseFormat,
+				Strict: true,
+			},
+		}
+	}
 	if len(o.tools) > 0 {
 		payload.Tools = make([]openai_Request_Tool, len(o.tools))
 		for i, tool := range o.tools {
@@ -269,13 +327,13 @@ func (o *OpenAI) request(ctx context.Context, messages []Message, config streamC
 	}
 	o.logger.Debugj("OpenAI request payload", data.Bytes())
 	req, err := http.NewRequestWithContext(ctx,
-		http.MethodPost, "https://api.openai.com/v1/responses", &data)
+		http.MethodPost, o.baseURL+"/responses", &data)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
 	req.Header.Set("authorization", "Bearer "+o.token)
 	req.Header.Set("content-type", "application/json")
-	client := &http.Client{Timeout: 300 * time.Second /* 5 min */}
+	client := &http.Client{Timeout: o.timeout}
 	return client.Do(req)
 }
 
@@ -289,9 +347,16 @@ func (o *OpenAI) processSSEEvent(event, data string, ch chan<- Event, toolCallBu
 			return
 		}
 		if outputItemDone.Item.Type == "reasoning" {
+			// the encrypted reasoning is opaque, the summary is the readable part
+			var summaries []string
+			for _, summary := range outputItemDone.Item.Summary {
+				if summary.Type == "summary_text" && summary.Text != "" {
+					summaries = append(summaries, summary.Text)
+				}
+			}
 			ch <- &ThinkingDeltaEvent{
-				Signature: "",
-				Thinking:  outputItemDone.Item.EncryptedContent,
+				Thinking:         strings.Join(summaries, "\n\n"),
+				EncryptedContent: outputItemDone.Item.EncryptedContent,
 			}
 			return
 		}
@@ -321,6 +386,13 @@ func (o *OpenAI) processSSEEvent(event, data string, ch chan<- Event, toolCallBu
 			}
 			return
 		}
+	case "response.refusal.done":
+		var refusalDone openai_Response_RefusalDone
+		if err := json.Unmarshal([]byte(data), &refusalDone); err != nil {
+			o.logger.Errorf("failed to parse 'response.refusal.done': %v", err)
+			return
+		}
+		ch <- &ErrorEvent{Err: &RefusalError{Reason: "refusal", Message: refusalDone.Refusal}}
 	case "response.completed":
 		var responseCompleted openai_Response_ResponseCompleted
 		if err := json.Unmarshal([]byte(data), &responseCompleted); err != nil {
@@ -347,11 +419,13 @@ func (o *OpenAI) processSSEEvent(event, data string, ch chan<- Event, toolCallBu
 
 func (o *OpenAI) generationConfig(opts ...StreamOption) streamConfig {
 	c := streamConfig{
-		maxTokens:          8192,
-		maxTurns:           1,
-		reasoningEffort:    0,
-		reasoningMaxTokens: 0,
-		temperature:        1.0,
+		maxTokens:            8192,
+		maxTurns:             1,
+		emptyResponseRetries: 1,
+		toolConcurrency:      defaultToolConcurrency,
+		reasoningEffort:      0,
+		reasoningMaxTokens:   0,
+		temperature:          1.0,
 	}
 	for _, opt := range opts {
 		if opt != nil {
@@ -385,7 +459,13 @@ func (o *OpenAI) estimateCost(usage openai_Usage) float64 {
 		},
 	}
 	var cost *costConfig
-	if c, ok := costs[o.model]; ok {
+	if p, ok := o.pricing[o.model]; ok {
+		cost = &costConfig{
+			inputTokens:  p.Input,
+			cachedTokens: p.Cached,
+			outputTokens: p.Output,
+		}
+	} else if c, ok := costs[o.model]; ok {
 		cost = &c
 	} else {
 		o.logger.Errorf("no cost information available for model %s, using intentionally high default values", o.model)
@@ -413,8 +493,9 @@ func (o *OpenAI) estimateCost(usage openai_Usage) float64 {
 
 // messages
 type openai_InputMessage_ContentItem struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type     string `json:"type"`
+	Text     string `json:"text,omitzero"`
+	ImageURL string `json:"image_url,omitzero"`
 }
 type openai_InputMessage struct {
 	Role    string                            `json:"role"`
@@ -455,19 +536,16 @@ func (m openai_Message) MarshalJSON() ([]byte, error) {
 	return json.Marshal(m.v)
 }
 
+func (m *openai_Message) fromToolCall(toolCall ToolCall) {
+	var v openai_FunctionToolCall
+	v.Arguments = toolCall.Function.Args
+	v.CallID = toolCall.ID
+	v.Name = toolCall.Function.Name
+	v.Type = "function_call"
+	m.v = v
+}
+
 func (m *openai_Message) from(msg Message) error {
-	if len(msg.ToolCalls) > 1 {
-		return fmt.Errorf("OpenAI does not support multiple tool calls in a single message")
-	}
-	if len(msg.ToolCalls) > 0 {
-		var v openai_FunctionToolCall
-		v.Arguments = msg.ToolCalls[0].Function.Args
-		v.CallID = msg.ToolCalls[0].ID
-		v.Name = msg.ToolCalls[0].Function.Name
-		v.Type = "function_call"
-		m.v = v
-		return nil
-	}
 	switch msg.Role {
 	case RoleSystem:
 		return fmt.Errorf("OpenAI does not support system messages")
@@ -499,7 +577,10 @@ func (m *openai_Message) from(msg Message) error {
 					Text: p.Text,
 				})
 			case ImageContentPart:
-				return fmt.Errorf("image content part currently not supported in OpenAI messages")
+				v.Content = append(v.Content, openai_InputMessage_ContentItem{
+					Type:     "input_image",
+					ImageURL: p.ImageURL,
+				})
 			case FileContentPart:
 				return fmt.Errorf("file content part currently not supported in OpenAI messages")
 			}
@@ -525,7 +606,17 @@ type openai_Request_Tool struct {
 	Parameters  json.RawMessage `json:"parameters"`
 }
 type openai_Request_Reasoning struct {
-	Effort string `json:"effort"`
+	Effort  string `json:"effort"`
+	Summary string `json:"summary,omitzero"`
+}
+type openai_Request_Text_Format struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict"`
+}
+type openai_Request_Text struct {
+	Format openai_Request_Text_Format `json:"format"`
 }
 type openai_Request struct {
 	Include         []string                  `json:"include"`
@@ -537,7 +628,9 @@ type openai_Request struct {
 	Store           bool                      `json:"store"`
 	Stream          bool                      `json:"stream"`
 	Temperature     float64                   `json:"temperature"`
+	Text            *openai_Request_Text      `json:"text,omitzero"`
 	Tools           []openai_Request_Tool     `json:"tools,omitzero"`
+	TopP            *float64                  `json:"top_p,omitzero"`
 	User            string                    `json:"user,omitzero"`
 }
 
@@ -556,21 +649,25 @@ type openai_Usage struct {
 	TotalTokens         int                               `json:"total_tokens"`
 }
 
+type openai_Response_ReasoningSummary struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
 type openai_Response_OutputItemDone struct {
 	Type           string `json:"type"`
 	SequenceNumber int    `json:"sequence_number"`
 	OutputIndex    int    `json:"output_index"`
 	Item           struct {
-		Arguments        string   `json:"arguments"`
-		CallID           string   `json:"call_id"`
-		Content          []any    `json:"content"`
-		EncryptedContent string   `json:"encrypted_content"`
-		ID               string   `json:"id"`
-		Name             string   `json:"name"`
-		Role             string   `json:"role"`
-		Status           string   `json:"status"`
-		Summary          []string `json:"summary"`
-		Type             string   `json:"type"`
+		Arguments        string                             `json:"arguments"`
+		CallID           string                             `json:"call_id"`
+		Content          []any                              `json:"content"`
+		EncryptedContent string                             `json:"encrypted_content"`
+		ID               string                             `json:"id"`
+		Name             string                             `json:"name"`
+		Role             string                             `json:"role"`
+		Status           string                             `json:"status"`
+		Summary          []openai_Response_ReasoningSummary `json:"summary"`
+		Type             string                             `json:"type"`
 	} `json:"item"`
 }
 
@@ -583,6 +680,15 @@ type openai_Response_OutputTextDelta struct {
 	ItemID         string `json:"item_id"`
 }
 
+type openai_Response_RefusalDone struct {
+	Type           string `json:"type"`
+	SequenceNumber int    `json:"sequence_number"`
+	OutputIndex    int    `json:"output_index"`
+	ContentIndex   int    `json:"content_index"`
+	Refusal        string `json:"refusal"`
+	ItemID         string `json:"item_id"`
+}
+
 type openai_Response_ResponseCompleted struct {
 	Type           string `json:"type"`
 	SequenceNumber int    `json:"sequence_number"`
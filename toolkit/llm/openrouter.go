@@ -1,7 +1,6 @@
 package llm
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/sha256"
@@ -12,24 +11,41 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/markusylisiurunen/ikm/internal/logger"
 	"golang.org/x/sync/errgroup"
 )
 
+// providers that support cache breakpoints (e.g. Anthropic) accept at most four per request
+const openRouterMaxCacheBreakpoints = 4
+
 var _ Model = (*OpenRouter)(nil)
 
 type OpenRouterOption func(*OpenRouter)
 
 type OpenRouter struct {
-	logger     logger.Logger
-	token      string
-	model      string
-	tools      []Tool
-	cache      bool
-	provider   *openRouter_Request_Provider
-	transforms []openRouterRequestTransform
+	logger      logger.Logger
+	baseURL     string
+	token       string
+	model       string
+	tools       []Tool
+	cache       bool
+	breakpoints int
+	provider    *openRouter_Request_Provider
+	transforms  []openRouterRequestTransform
+	middleware  []string
+	timeout     time.Duration
+	compatible  bool
+}
+
+func WithOpenRouterBaseURL(baseURL string) OpenRouterOption {
+	return func(o *OpenRouter) {
+		if baseURL != "" {
+			o.baseURL = strings.TrimSuffix(baseURL, "/")
+		}
+	}
 }
 
 func WithOpenRouterCacheEnabled() OpenRouterOption {
@@ -38,6 +54,15 @@ func WithOpenRouterCacheEnabled() OpenRouterOption {
 	}
 }
 
+func WithOpenRouterCacheBreakpoints(n int) OpenRouterOption {
+	return func(o *OpenRouter) {
+		if n > 0 {
+			o.cache = true
+			o.breakpoints = min(n, openRouterMaxCacheBreakpoints)
+		}
+	}
+}
+
 func WithOpenRouterOnlyProviders(only []string) OpenRouterOption {
 	return func(o *OpenRouter) {
 		o.provider = &openRouter_Request_Provider{
@@ -63,8 +88,37 @@ func WithOpenRouterRequestTransform(transform openRouterRequestTransform) OpenRo
 	}
 }
 
+func WithOpenRouterTransforms(transforms []string) OpenRouterOption {
+	return func(o *OpenRouter) {
+		// unlike the request transforms these are applied by OpenRouter itself, e.g. "middle-out"
+		o.middleware = transforms
+	}
+}
+
+func WithOpenRouterCompatibleAPI() OpenRouterOption {
+	return func(o *OpenRouter) {
+		// other OpenAI-compatible servers (e.g. Ollama) only understand the standard chat completions fields
+		o.compatible = true
+	}
+}
+
+func WithOpenRouterHTTPTimeout(timeout time.Duration) OpenRouterOption {
+	return func(o *OpenRouter) {
+		if timeout > 0 {
+			o.timeout = timeout
+		}
+	}
+}
+
 func NewOpenRouter(logger logger.Logger, token, model string, opts ...OpenRouterOption) *OpenRouter {
-	o := &OpenRouter{logger: logger, token: token, model: model}
+	o := &OpenRouter{
+		logger:      logger,
+		baseURL:     "https://openrouter.ai/api/v1",
+		token:       token,
+		model:       model,
+		breakpoints: 2,
+		timeout:     defaultHTTPTimeout,
+	}
 	for _, opt := range opts {
 		opt(o)
 	}
@@ -94,19 +148,32 @@ func (o *OpenRouter) streamTurns(ctx context.Context, messages []Message, config
 				return
 			default:
 			}
-			out := tee(o.streamTurn(ctx, cloned, config), ch)
-			builder := newMessageBuilder()
-			for event := range out {
-				builder.process(event)
-			}
-			messages, _, err := builder.result()
-			if err != nil {
-				ch <- &ErrorEvent{Err: fmt.Errorf("error processing events: %w", err)}
-				return
-			}
-			if len(messages) != 1 {
-				ch <- &ErrorEvent{Err: fmt.Errorf("expected exactly one message, got %d", len(messages))}
-				return
+			var messages []Message
+			for attempt := 0; ; attempt++ {
+				out := tee(o.streamTurn(ctx, withEmptyResponseNudge(cloned, attempt), config), ch)
+				builder := newMessageBuilder()
+				for event := range out {
+					builder.process(event)
+				}
+				var err error
+				messages, _, err = builder.result()
+				if err != nil {
+					ch <- &ErrorEvent{Err: fmt.Errorf("error processing events: %w", err)}
+					return
+				}
+				if isEmptyResponse(messages) {
+					if attempt < config.emptyResponseRetries {
+						o.logger.Errorf("OpenRouter returned an empty response, retrying the turn")
+						continue
+					}
+					ch <- &ErrorEvent{Err: ErrEmptyResponse}
+					return
+				}
+				if len(messages) != 1 {
+					ch <- &ErrorEvent{Err: fmt.Errorf("expected exactly one message, got %d", len(messages))}
+					return
+				}
+				break
 			}
 			if len(messages[0].ToolCalls) == 0 {
 				return
@@ -115,6 +182,7 @@ func (o *OpenRouter) streamTurns(ctx context.Context, messages []Message, config
 			if len(messages[0].ToolCalls) > 0 {
 				toolResultEvents := make([]*ToolResultEvent, len(messages[0].ToolCalls))
 				g, gctx := errgroup.WithContext(ctx)
+				g.SetLimit(config.toolConcurrency)
 				for idx, toolCall := range messages[0].ToolCalls {
 					g.Go(func() error {
 						var tool Tool
@@ -127,7 +195,17 @@ func (o *OpenRouter) streamTurns(ctx context.Context, messages []Message, config
 						if tool == nil {
 							return fmt.Errorf("tool %s not found", toolCall.Function.Name)
 						}
-						result, err := tool.Call(gctx, toolCall.Function.Args)
+						// arguments that do not match the schema are sent back to the model before anyone is asked to approve them
+						_, _, schema := tool.Spec()
+						if err := ValidateToolArgs(schema, toolCall.Function.Args); err != nil {
+							toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: invalidToolCallResult(err)}
+							return nil
+						}
+						if err := config.approveToolCall(gctx, toolCall.Function.Name, toolCall.Function.Args); err != nil {
+							toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: deniedToolCallResult(err)}
+							return nil
+						}
+						result, err := tool.Call(withToolCallID(gctx, toolCall.ID), toolCall.Function.Args)
 						toolResultEvents[idx] = &ToolResultEvent{ID: toolCall.ID, Result: result, Error: err}
 						return nil
 					})
@@ -155,7 +233,12 @@ func (o *OpenRouter) streamTurns(ctx context.Context, messages []Message, config
 					cloned = append(cloned, msg)
 				}
 			}
-			if turn >= config.maxTurns-1 || (config.stopCondition != nil && config.stopCondition(turn, cloned)) {
+			if config.stopCondition != nil && config.stopCondition(turn, cloned) {
+				return
+			}
+			if turn >= config.maxTurns-1 {
+				// the model still has tool results to respond to, let the caller know why the loop stopped
+				ch <- &MaxTurnsReachedEvent{MaxTurns: config.maxTurns}
 				return
 			}
 		}
@@ -177,14 +260,24 @@ func (o *OpenRouter) streamTurn(ctx context.Context, messages []Message, config
 			if err != nil {
 				ch <- &ErrorEvent{Err: fmt.Errorf("error reading response body: %w", err)}
 			} else {
-				ch <- &ErrorEvent{Err: fmt.Errorf("non-ok status (%d) from OpenRouter: %s", resp.StatusCode, string(body))}
+				// OpenRouter reports most failures as an error object, anything else is passed on as is
+				var chunk openRouter_Chunk
+				if err := json.Unmarshal(body, &chunk); err == nil && chunk.Error != nil {
+					if chunk.Error.Code == 0 {
+						chunk.Error.Code = resp.StatusCode
+					}
+					ch <- &ErrorEvent{Err: o.streamError(chunk.Error)}
+				} else {
+					ch <- &ErrorEvent{Err: fmt.Errorf("non-ok status (%d) from OpenRouter: %s", resp.StatusCode, string(body))}
+				}
 			}
 			return
 		}
-		toolCallBuffer := make([]*ToolUseEvent, 10)
-		reader := bufio.NewReader(resp.Body)
+		var toolCallBuffer []*ToolUseEvent
+		var finishReason string
+		reader := newSSEReader(resp.Body)
 		for {
-			line, err := reader.ReadString('\n')
+			event, err := reader.next()
 			select {
 			case <-ctx.Done():
 				ch <- &ErrorEvent{Err: ctx.Err()}
@@ -197,16 +290,7 @@ func (o *OpenRouter) streamTurn(ctx context.Context, messages []Message, config
 				ch <- &ErrorEvent{Err: fmt.Errorf("error reading stream: %w", err)}
 				return
 			}
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-			var raw string
-			if after, ok := strings.CutPrefix(line, "data: "); ok {
-				raw = after
-			} else if strings.HasPrefix(line, "{") {
-				raw = line
-			}
+			raw := strings.TrimSpace(event.data)
 			if raw == "" {
 				continue
 			}
@@ -218,11 +302,7 @@ func (o *OpenRouter) streamTurn(ctx context.Context, messages []Message, config
 				continue
 			}
 			if chunk.Error != nil {
-				ch <- &ErrorEvent{Err: &StreamError{
-					Code:     chunk.Error.Code,
-					Message:  chunk.Error.Message,
-					Metadata: chunk.Error.Metadata,
-				}}
+				ch <- &ErrorEvent{Err: o.streamError(chunk.Error)}
 				return
 			}
 			if chunk.Usage != nil {
@@ -243,14 +323,22 @@ func (o *OpenRouter) streamTurn(ctx context.Context, messages []Message, config
 				continue
 			}
 			choice := chunk.Choices[0]
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
 			if choice.Delta != nil && choice.Delta.Content != "" {
 				ch <- &ContentDeltaEvent{Content: choice.Delta.Content}
 			}
 			if choice.Delta != nil && choice.Delta.ToolCalls != nil {
 				for _, toolCall := range choice.Delta.ToolCalls {
 					index := toolCall.Index
-					if index < 0 || index >= len(toolCallBuffer) {
-						panic("tool call index out of range")
+					if index < 0 {
+						o.logger.Errorf("OpenRouter tool call index out of range: %d", index)
+						continue
+					}
+					// the buffer grows with the index so that any number of parallel tool calls fits
+					if index >= len(toolCallBuffer) {
+						toolCallBuffer = append(toolCallBuffer, make([]*ToolUseEvent, index-len(toolCallBuffer)+1)...)
 					}
 					if toolCallBuffer[index] == nil {
 						toolCallBuffer[index] = &ToolUseEvent{
@@ -273,26 +361,68 @@ func (o *OpenRouter) streamTurn(ctx context.Context, messages []Message, config
 		}
 		for _, toolCall := range toolCallBuffer {
 			if toolCall != nil {
+				for i := len(o.transforms) - 1; i >= 0; i-- {
+					o.transforms[i].transformToolCall(toolCall)
+				}
 				ch <- toolCall
 			}
 		}
+		if finishReason != "" {
+			ch <- &FinishEvent{Reason: finishReason}
+		}
+		if finishReason == "content_filter" {
+			ch <- &ErrorEvent{Err: &RefusalError{Reason: finishReason}}
+		}
 	}()
 	return ch
 }
 
+func (o *OpenRouter) streamError(e *openRouter_Chunk_Error) *StreamError {
+	streamErr := &StreamError{Code: e.Code, Message: e.Message, Metadata: e.Metadata}
+	if provider, ok := e.Metadata["provider_name"].(string); ok {
+		streamErr.Provider = provider
+	}
+	if reasons, ok := e.Metadata["reasons"].([]any); ok {
+		for _, reason := range reasons {
+			if reason, ok := reason.(string); ok {
+				streamErr.Reasons = append(streamErr.Reasons, reason)
+			}
+		}
+	}
+	// the codes follow https://openrouter.ai/docs/api-reference/errors
+	switch {
+	case e.Code == http.StatusPaymentRequired:
+		streamErr.Kind = StreamErrorKindInsufficientCredits
+	case e.Code == http.StatusForbidden && (len(streamErr.Reasons) > 0 || e.Metadata["flagged_input"] != nil):
+		streamErr.Kind = StreamErrorKindModeration
+	case e.Code == http.StatusTooManyRequests:
+		streamErr.Kind = StreamErrorKindRateLimited
+	case e.Code == http.StatusBadGateway || e.Code == http.StatusServiceUnavailable:
+		streamErr.Kind = StreamErrorKindProviderDown
+	}
+	o.logger.Errorf("OpenRouter error (%d): %s: %s", e.Code, e.Message, streamErr.RawMetadata())
+	return streamErr
+}
+
 func (o *OpenRouter) request(
 	ctx context.Context, messages []Message, config streamConfig,
 ) (*http.Response, error) {
+	config = config.withContextBudget(messages)
 	payload := openRouter_Request{
-		MaxTokens:   config.maxTokens,
-		Messages:    []openRouter_Message{},
-		Model:       o.model,
-		Provider:    o.provider,
-		Reasoning:   nil,
-		Stream:      true,
-		Temperature: config.temperature,
-		Tools:       nil,
-		Usage:       openRouter_Request_Usage{Include: true},
+		FrequencyPenalty: config.frequencyPenalty,
+		MaxTokens:        config.maxTokens,
+		Messages:         []openRouter_Message{},
+		Model:            o.model,
+		PresencePenalty:  config.presencePenalty,
+		Provider:         o.provider,
+		Reasoning:        nil,
+		Seed:             config.seed,
+		Stream:           true,
+		Temperature:      config.temperature,
+		Tools:            nil,
+		TopP:             config.topP,
+		Transforms:       o.middleware,
+		Usage:            &openRouter_Request_Usage{Include: true},
 	}
 	for _, msg := range messages {
 		var m openRouter_Message
@@ -304,8 +434,13 @@ func (o *OpenRouter) request(
 		}
 		payload.Messages = append(payload.Messages, m)
 	}
-	o.injectCacheControl(payload.Messages)
-	if config.reasoningEffort > 0 {
+	if !o.compatible {
+		o.injectCacheControl(payload.Messages)
+	}
+	// an explicit thinking budget takes precedence over the effort
+	if config.reasoningMaxTokens > 0 {
+		payload.Reasoning = &openRouter_Request_Reasoning{MaxTokens: config.reasoningMaxTokens}
+	} else if config.reasoningEffort > 0 {
 		switch config.reasoningEffort {
 		case 1:
 			payload.Reasoning = &openRouter_Request_Reasoning{Effort: "low"}
@@ -316,8 +451,16 @@ func (o *OpenRouter) request(
 		default:
 			o.logger.Errorf("invalid reasoning effort: %d, must be 1, 2, or 3", config.reasoningEffort)
 		}
-	} else if config.reasoningMaxTokens > 0 {
-		payload.Reasoning = &openRouter_Request_Reasoning{MaxTokens: config.reasoningMaxTokens}
+	}
+	if o.compatible {
+		payload.Provider = nil
+		payload.Reasoning = nil
+		payload.Transforms = nil
+		payload.Usage = nil
+		payload.StreamOptions = &openRouter_Request_StreamOptions{IncludeUsage: true}
+	}
+	if len(config.responseFormat) > 0 {
+		payload.ResponseFormat = newOpenRouterResponseFormat(config.responseFormat)
 	}
 	if len(o.tools) > 0 {
 		payload.Tools = make([]openRouter_Request_Tool, len(o.tools))
@@ -331,6 +474,9 @@ func (o *OpenRouter) request(
 					Parameters:  parameters,
 				},
 			}
+			for _, transform := range o.transforms {
+				transform.transformTool(payload.Tools[i].Function)
+			}
 		}
 	}
 	var data bytes.Buffer
@@ -341,13 +487,15 @@ func (o *OpenRouter) request(
 	}
 	o.logger.Debugj("OpenRouter request payload", data.Bytes())
 	req, err := http.NewRequestWithContext(ctx,
-		http.MethodPost, "https://openrouter.ai/api/v1/chat/completions", &data)
+		http.MethodPost, o.baseURL+"/chat/completions", &data)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+o.token)
+	if o.token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	}
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{Timeout: 300 * time.Second /* 5 min */}
+	client := &http.Client{Timeout: o.timeout}
 	return client.Do(req)
 }
 
@@ -355,38 +503,47 @@ func (o *OpenRouter) injectCacheControl(messages []openRouter_Message) {
 	if !o.cache {
 		return
 	}
-	lastSystemIdx := -1
-	lastUserIdx := -1
-	for i, msg := range messages {
-		switch msg.Role {
-		case "system":
-			lastSystemIdx = i
-		case "user":
-			lastUserIdx = i
+	remaining := o.breakpoints
+	for i := len(messages) - 1; i >= 0 && remaining > 0; i-- {
+		if messages[i].Role == "system" {
+			o.addCacheControlToMessage(&messages[i])
+			remaining--
+			break
 		}
 	}
-	if lastSystemIdx >= 0 {
-		o.addCacheControlToMessage(&messages[lastSystemIdx])
-	}
-	if lastUserIdx >= 0 {
-		o.addCacheControlToMessage(&messages[lastUserIdx])
+	// the rest of the breakpoints go to the latest user and tool messages so that the conversation so far is cached
+	for i := len(messages) - 1; i >= 0 && remaining > 0; i-- {
+		if messages[i].Role == "user" || messages[i].Role == "tool" {
+			if o.addCacheControlToMessage(&messages[i]) {
+				remaining--
+			}
+		}
 	}
 }
 
-func (o *OpenRouter) addCacheControlToMessage(msg *openRouter_Message) {
-	if len(msg.ContentParts) > 0 {
-		lastIdx := len(msg.ContentParts) - 1
-		msg.ContentParts[lastIdx].CacheControl = &openRouter_Message_ContentPart_CacheControl{Type: "ephemeral"}
+func (o *OpenRouter) addCacheControlToMessage(msg *openRouter_Message) bool {
+	// cache control can only be set on a content part, plain string content is converted into one
+	if len(msg.ContentParts) == 0 && msg.ContentString != "" {
+		msg.ContentParts.appendText(msg.ContentString)
+		msg.ContentString = ""
+	}
+	if len(msg.ContentParts) == 0 {
+		return false
 	}
+	lastIdx := len(msg.ContentParts) - 1
+	msg.ContentParts[lastIdx].CacheControl = &openRouter_Message_ContentPart_CacheControl{Type: "ephemeral"}
+	return true
 }
 
 func (o *OpenRouter) generationConfig(opts ...StreamOption) streamConfig {
 	c := streamConfig{
-		maxTokens:          8192,
-		maxTurns:           1,
-		reasoningEffort:    0,
-		reasoningMaxTokens: 0,
-		temperature:        1.0,
+		maxTokens:            8192,
+		maxTurns:             1,
+		emptyResponseRetries: 1,
+		toolConcurrency:      defaultToolConcurrency,
+		reasoningEffort:      0,
+		reasoningMaxTokens:   0,
+		temperature:          1.0,
 	}
 	for _, opt := range opts {
 		if opt != nil {
@@ -495,6 +652,9 @@ func (m *openRouter_Message) from(msg Message) error {
 	m.ContentString = ""
 	for _, part := range msg.Content {
 		switch p := part.(type) {
+		case ThinkingContentPart:
+			// thinking from other providers cannot be sent back through chat completions
+			continue
 		case TextContentPart:
 			if msg.Role == RoleAssistant || msg.Role == RoleTool {
 				m.ContentString += p.Text
@@ -605,10 +765,35 @@ type openRouter_Request_Reasoning struct {
 	MaxTokens uint   `json:"max_tokens,omitzero"`
 }
 
+type openRouter_Request_ResponseFormat_JSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict"`
+}
+type openRouter_Request_ResponseFormat struct {
+	Type       string                                        `json:"type"`
+	JSONSchema *openRouter_Request_ResponseFormat_JSONSchema `json:"json_schema,omitempty"`
+}
+
+func newOpenRouterResponseFormat(schema json.RawMessage) *openRouter_Request_ResponseFormat {
+	return &openRouter_Request_ResponseFormat{
+		Type: "json_schema",
+		JSONSchema: &openRouter_Request_ResponseFormat_JSONSchema{
+			Name:   "response",
+			Schema: schema,
+			Strict: true,
+		},
+	}
+}
+
 type openRouter_Request_Usage struct {
 	Include bool `json:"include"`
 }
 
+type openRouter_Request_StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
 type openRouter_Request_Provider struct {
 	Only           []string `json:"only,omitempty"`
 	Order          []string `json:"order,omitempty"`
@@ -616,15 +801,22 @@ type openRouter_Request_Provider struct {
 }
 
 type openRouter_Request struct {
-	MaxTokens   int                           `json:"max_tokens"`
-	Messages    []openRouter_Message          `json:"messages"`
-	Model       string                        `json:"model"`
-	Provider    *openRouter_Request_Provider  `json:"provider,omitempty"`
-	Reasoning   *openRouter_Request_Reasoning `json:"reasoning,omitempty"`
-	Stream      bool                          `json:"stream"`
-	Temperature float64                       `json:"temperature"`
-	Tools       []openRouter_Request_Tool     `json:"tools,omitempty"`
-	Usage       openRouter_Request_Usage      `json:"usage"`
+	FrequencyPenalty *float64                           `json:"frequency_penalty,omitempty"`
+	MaxTokens        int                                `json:"max_tokens"`
+	Messages         []openRouter_Message               `json:"messages"`
+	Model            string                             `json:"model"`
+	PresencePenalty  *float64                           `json:"presence_penalty,omitempty"`
+	Provider         *openRouter_Request_Provider       `json:"provider,omitempty"`
+	Reasoning        *openRouter_Request_Reasoning      `json:"reasoning,omitempty"`
+	ResponseFormat   *openRouter_Request_ResponseFormat `json:"response_format,omitempty"`
+	Seed             *int                               `json:"seed,omitempty"`
+	Stream           bool                               `json:"stream"`
+	StreamOptions    *openRouter_Request_StreamOptions  `json:"stream_options,omitempty"`
+	Temperature      float64                            `json:"temperature"`
+	Tools            []openRouter_Request_Tool          `json:"tools,omitempty"`
+	TopP             *float64                           `json:"top_p,omitempty"`
+	Transforms       []string                           `json:"transforms,omitempty"`
+	Usage            *openRouter_Request_Usage          `json:"usage,omitempty"`
 }
 
 // stream responses
@@ -671,8 +863,11 @@ type openRouter_Chunk struct {
 
 // request transforms ------------------------------------------------------------------------------
 
+// transforms are applied in order to the outgoing request and in reverse order to the tool calls that come back
 type openRouterRequestTransform interface {
 	transformMessage(*openRouter_Message)
+	transformTool(*openRouter_Request_Tool_Function)
+	transformToolCall(*ToolUseEvent)
 }
 
 type openRouterHexadecimalToolCallIDRequestTransform struct{}
@@ -698,6 +893,11 @@ func (t openRouterHexadecimalToolCallIDRequestTransform) transformMessage(msg *o
 	}
 }
 
+func (t openRouterHexadecimalToolCallIDRequestTransform) transformTool(*openRouter_Request_Tool_Function) {
+}
+
+func (t openRouterHexadecimalToolCallIDRequestTransform) transformToolCall(*ToolUseEvent) {}
+
 func (t *openRouterHexadecimalToolCallIDRequestTransform) getID(originalID string) string {
 	hash := sha256.Sum256([]byte(originalID))
 	hex := hex.EncodeToString(hash[:])
@@ -717,3 +917,82 @@ func (t *openRouterHexadecimalToolCallIDRequestTransform) getID(originalID strin
 	}
 	return result
 }
+
+type openRouterOmitToolNameRequestTransform struct{}
+
+func NewOpenRouterOmitToolNameRequestTransform() openRouterRequestTransform {
+	return &openRouterOmitToolNameRequestTransform{}
+}
+
+func (t openRouterOmitToolNameRequestTransform) transformMessage(msg *openRouter_Message) {
+	// some routed backends reject the `name` field on tool messages
+	if msg.Role == "tool" {
+		msg.Name = nil
+	}
+}
+
+func (t openRouterOmitToolNameRequestTransform) transformTool(*openRouter_Request_Tool_Function) {}
+
+func (t openRouterOmitToolNameRequestTransform) transformToolCall(*ToolUseEvent) {}
+
+type OpenRouterToolNameRule func(string) string
+
+func OpenRouterCamelCaseToolNames(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+type openRouterToolNameRequestTransform struct {
+	rule     OpenRouterToolNameRule
+	mu       sync.Mutex
+	original map[string]string
+}
+
+func NewOpenRouterToolNameTransform(rule OpenRouterToolNameRule) openRouterRequestTransform {
+	if rule == nil {
+		return nil
+	}
+	return &openRouterToolNameRequestTransform{rule: rule, original: map[string]string{}}
+}
+
+func (t *openRouterToolNameRequestTransform) transformMessage(msg *openRouter_Message) {
+	switch msg.Role {
+	case "assistant":
+		for i, toolCall := range msg.ToolCalls {
+			if toolCall.Function != nil {
+				msg.ToolCalls[i].Function.Name = t.rename(toolCall.Function.Name)
+			}
+		}
+	case "tool":
+		if msg.Name != nil {
+			name := t.rename(*msg.Name)
+			msg.Name = &name
+		}
+	}
+}
+
+func (t *openRouterToolNameRequestTransform) transformTool(tool *openRouter_Request_Tool_Function) {
+	tool.Name = t.rename(tool.Name)
+}
+
+func (t *openRouterToolNameRequestTransform) transformToolCall(toolCall *ToolUseEvent) {
+	// the model only knows the renamed tools, dispatch needs the original names back
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if name, ok := t.original[toolCall.FuncName]; ok {
+		toolCall.FuncName = name
+	}
+}
+
+func (t *openRouterToolNameRequestTransform) rename(name string) string {
+	renamed := t.rule(name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.original[renamed] = name
+	return renamed
+}
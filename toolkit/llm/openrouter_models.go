@@ -0,0 +1,99 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type OpenRouterModel struct {
+	ID                  string   `json:"id"`
+	Name                string   `json:"name"`
+	ContextLength       int      `json:"context_length"`
+	Pricing             Pricing  `json:"pricing"`
+	SupportedParameters []string `json:"supported_parameters,omitzero"`
+}
+
+func (m OpenRouterModel) SupportsTools() bool {
+	// a catalog cached before the parameters were recorded says nothing either way, so tools are assumed to work
+	return len(m.SupportedParameters) == 0 || slices.Contains(m.SupportedParameters, "tools")
+}
+
+func FetchOpenRouterModels(ctx context.Context, token string) ([]OpenRouterModel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://openrouter.ai/api/v1/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching OpenRouter models: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response body: %w", err)
+		}
+		return nil, fmt.Errorf("non-ok status (%d) from OpenRouter: %s", resp.StatusCode, string(body))
+	}
+	var payload openRouter_ModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("error decoding OpenRouter models: %w", err)
+	}
+	models := make([]OpenRouterModel, 0, len(payload.Data))
+	for _, m := range payload.Data {
+		if m.ID == "" {
+			continue
+		}
+		// OpenRouter reports prices in dollars per token, convert them to dollars per million tokens
+		models = append(models, OpenRouterModel{
+			ID:                  m.ID,
+			Name:                m.Name,
+			ContextLength:       m.ContextLength,
+			SupportedParameters: m.SupportedParameters,
+			Pricing: Pricing{
+				Input:      parseOpenRouterPrice(m.Pricing.Prompt),
+				Cached:     parseOpenRouterPrice(m.Pricing.InputCacheRead),
+				CacheWrite: parseOpenRouterPrice(m.Pricing.InputCacheWrite),
+				Output:     parseOpenRouterPrice(m.Pricing.Completion),
+			},
+		})
+	}
+	return models, nil
+}
+
+func parseOpenRouterPrice(price string) float64 {
+	v, err := strconv.ParseFloat(price, 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	return v * 1_000_000
+}
+
+// helper types ------------------------------------------------------------------------------------
+
+type openRouter_ModelsResponse_Model_Pricing struct {
+	Prompt          string `json:"prompt"`
+	Completion      string `json:"completion"`
+	InputCacheRead  string `json:"input_cache_read"`
+	InputCacheWrite string `json:"input_cache_write"`
+}
+type openRouter_ModelsResponse_Model struct {
+	ID                  string                                  `json:"id"`
+	Name                string                                  `json:"name"`
+	ContextLength       int                                     `json:"context_length"`
+	Pricing             openRouter_ModelsResponse_Model_Pricing `json:"pricing"`
+	SupportedParameters []string                                `json:"supported_parameters"`
+}
+type openRouter_ModelsResponse struct {
+	Data []openRouter_ModelsResponse_Model `json:"data"`
+}
@@ -0,0 +1,33 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type Pricing struct {
+	Input      float64 `json:"input"`
+	Cached     float64 `json:"cached"`
+	CacheWrite float64 `json:"cache_write,omitzero"`
+	Output     float64 `json:"output"`
+}
+
+type PricingTable map[string]Pricing
+
+func LoadPricingTable(path string) (PricingTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pricing table: %w", err)
+	}
+	var table PricingTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("error parsing pricing table: %w", err)
+	}
+	for model, pricing := range table {
+		if pricing.Input < 0 || pricing.Cached < 0 || pricing.CacheWrite < 0 || pricing.Output < 0 {
+			return nil, fmt.Errorf("invalid pricing for model %s: prices must not be negative", model)
+		}
+	}
+	return table, nil
+}
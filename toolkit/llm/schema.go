@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+func ValidateToolArgs(schema json.RawMessage, args string) error {
+	var s toolSchema
+	if len(schema) == 0 || json.Unmarshal(schema, &s) != nil {
+		// a tool without a usable schema gets its arguments as is
+		return nil
+	}
+	// models tend to send nothing at all for tools without parameters
+	if strings.TrimSpace(args) == "" {
+		args = "{}"
+	}
+	var value any
+	if err := json.Unmarshal([]byte(args), &value); err != nil {
+		return fmt.Errorf("arguments are not valid JSON: %w", err)
+	}
+	return s.validate("arguments", value)
+}
+
+func invalidToolCallResult(err error) string {
+	data, _ := json.Marshal(map[string]string{"error": "invalid arguments: " + err.Error()})
+	return string(data)
+}
+
+func (s toolSchema) validate(path string, value any) error {
+	if types := s.types(); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return matchesSchemaType(t, value) }) {
+		return fmt.Errorf("%s must be of type %s", path, strings.Join(types, " or "))
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return reflect.DeepEqual(e, value) }) {
+		var options []string
+		for _, e := range s.Enum {
+			data, _ := json.Marshal(e)
+			options = append(options, string(data))
+		}
+		return fmt.Errorf("%s must be one of %s", path, strings.Join(options, ", "))
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s is missing the required field %q", path, name)
+			}
+		}
+		// the properties are checked in a stable order so that the same call always reports the same error
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			// models often send null for optional fields they mean to leave out
+			if field, ok := v[name]; ok && (field != nil || slices.Contains(s.Required, name)) {
+				if err := s.Properties[name].validate(path+"."+name, field); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s toolSchema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var types []string
+		for _, v := range t {
+			if name, ok := v.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesSchemaType(t string, value any) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	// unknown types are not something to reject a call over
+	return true
+}
+
+// helper types ------------------------------------------------------------------------------------
+
+type toolSchema struct {
+	Type       any                   `json:"type"`
+	Enum       []any                 `json:"enum"`
+	Required   []string              `json:"required"`
+	Properties map[string]toolSchema `json:"properties"`
+	Items      *toolSchema           `json:"items"`
+}
@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+type sseReader struct {
+	reader *bufio.Reader
+	done   bool
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	return &sseReader{reader: bufio.NewReader(r)}
+}
+
+func (r *sseReader) next() (sseEvent, error) {
+	// an event may span several `data:` lines, they are joined with newlines and the event is dispatched on a
+	// blank line as described in https://html.spec.whatwg.org/multipage/server-sent-events.html
+	var (
+		event   sseEvent
+		data    []string
+		hasData bool
+	)
+	for !r.done {
+		line, err := r.reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			// whatever was pending when the stream ended is dispatched as well
+			r.done = true
+		} else if err != nil {
+			return sseEvent{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if hasData {
+				event.data = strings.Join(data, "\n")
+				return event, nil
+			}
+			event = sseEvent{}
+			continue
+		}
+		// some providers send plain JSON objects (e.g. errors) without any SSE framing
+		if !hasData && event.name == "" && strings.HasPrefix(line, "{") {
+			return sseEvent{data: line}, nil
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.name = value
+		case "data":
+			data = append(data, value)
+			hasData = true
+		}
+	}
+	if hasData {
+		event.data = strings.Join(data, "\n")
+		return event, nil
+	}
+	return sseEvent{}, io.EOF
+}
+
+// helper types ------------------------------------------------------------------------------------
+
+type sseEvent struct {
+	name string
+	data string
+}
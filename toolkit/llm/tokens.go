@@ -0,0 +1,54 @@
+package llm
+
+import "math"
+
+const (
+	charTokenEstimatorCharsPerToken   = 4
+	charTokenEstimatorMessageOverhead = 4
+	charTokenEstimatorImageTokens     = 1_000
+	charTokenEstimatorFileTokens      = 2_000
+)
+
+type TokenEstimator interface {
+	EstimateTokens(msg Message) int
+}
+
+func EstimateTokens(estimator TokenEstimator, messages []Message) int {
+	var total int
+	for _, msg := range messages {
+		total += estimator.EstimateTokens(msg)
+	}
+	return total
+}
+
+// char token estimator ----------------------------------------------------------------------------
+
+var _ TokenEstimator = (*charTokenEstimator)(nil)
+
+type charTokenEstimator struct{}
+
+func NewCharTokenEstimator() TokenEstimator {
+	return &charTokenEstimator{}
+}
+
+func (e *charTokenEstimator) EstimateTokens(msg Message) int {
+	// roughly four characters per token for English text and code, images and files are a flat guess
+	tokens := charTokenEstimatorMessageOverhead
+	var chars int
+	for _, part := range msg.Content {
+		switch p := part.(type) {
+		case TextContentPart:
+			chars += len(p.Text)
+		case ThinkingContentPart:
+			chars += len(p.Thinking)
+		case ImageContentPart:
+			tokens += charTokenEstimatorImageTokens
+		case FileContentPart:
+			tokens += charTokenEstimatorFileTokens
+		}
+	}
+	for _, toolCall := range msg.ToolCalls {
+		chars += len(toolCall.Function.Name) + len(toolCall.Function.Args)
+	}
+	return tokens + int(math.Ceil(float64(chars)/charTokenEstimatorCharsPerToken))
+}
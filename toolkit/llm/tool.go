@@ -9,3 +9,7 @@ type Tool interface {
 	Spec() (string, string, json.RawMessage)
 	Call(ctx context.Context, args string) (string, error)
 }
+
+type ToolRenderer interface {
+	Render(args string, width int) string
+}
@@ -1,6 +1,10 @@
 package llm
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"strings"
+)
 
 type messageBuilder struct {
 	init  bool
@@ -35,6 +39,9 @@ func (b *messageBuilder) process(event Event) {
 			if e.Signature != "" {
 				p.Signature = e.Signature
 			}
+			if e.EncryptedContent != "" {
+				p.EncryptedContent = e.EncryptedContent
+			}
 			b.msgs[len(b.msgs)-1].Content[0] = p
 		}
 	case *ContentDeltaEvent:
@@ -106,21 +113,78 @@ func (b *messageBuilder) result() ([]Message, Usage, error) {
 }
 
 func Rollup(events <-chan Event) ([]Message, Usage, error) {
+	return RollupWithProgress(events, nil)
+}
+
+func RollupWithProgress(events <-chan Event, progress func(Event)) ([]Message, Usage, error) {
 	b := newMessageBuilder()
 	for event := range events {
+		if progress != nil {
+			progress(event)
+		}
 		b.process(event)
 	}
 	return b.result()
 }
 
+type toolCallIDKey struct{}
+
+func withToolCallID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, toolCallIDKey{}, id)
+}
+
+func ToolCallID(ctx context.Context) string {
+	id, _ := ctx.Value(toolCallIDKey{}).(string)
+	return id
+}
+
 func tee(in <-chan Event, out chan<- Event) <-chan Event {
 	fork := make(chan Event)
 	go func() {
 		defer close(fork)
+		// deltas are held back until the attempt produces something, an empty attempt is retried and must not end up
+		// in the caller's history, the held back deltas of such an attempt are simply dropped
+		var held []Event
+		produced := false
 		for event := range in {
-			out <- event
+			switch {
+			case produced:
+				out <- event
+			case producesContent(event):
+				produced = true
+				for _, e := range held {
+					out <- e
+				}
+				held = nil
+				out <- event
+			case isDeltaEvent(event):
+				held = append(held, event)
+			default:
+				out <- event
+			}
 			fork <- event
 		}
 	}()
 	return fork
 }
+
+func isDeltaEvent(event Event) bool {
+	switch event.(type) {
+	case *ContentDeltaEvent, *ThinkingDeltaEvent:
+		return true
+	}
+	return false
+}
+
+func producesContent(event Event) bool {
+	// this matches what isEmptyResponse considers empty
+	switch e := event.(type) {
+	case *ContentDeltaEvent:
+		return strings.TrimSpace(e.Content) != ""
+	case *ThinkingDeltaEvent:
+		return strings.TrimSpace(e.Thinking) != ""
+	case *ToolUseEvent:
+		return true
+	}
+	return false
+}
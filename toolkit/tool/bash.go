@@ -4,8 +4,12 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/markusylisiurunen/ikm/internal/logger"
 	"github.com/markusylisiurunen/ikm/toolkit/llm"
@@ -13,14 +17,19 @@ import (
 )
 
 const (
-	bashToolMaxCmdLength = 8192
+	bashToolDefaultMaxOutputBytes = 16 * 1024
+	bashToolDefaultTimeout        = 120 * time.Second
+	bashToolMaxCmdLength          = 8192
 )
 
 type bashToolResult struct {
-	Ok     bool   `json:"ok"`
-	Error  string `json:"error,omitzero"`
-	Stdout string `json:"stdout,omitzero"`
-	Stderr string `json:"stderr,omitzero"`
+	Ok           bool   `json:"ok"`
+	Error        string `json:"error,omitzero"`
+	ExitCode     int    `json:"exit_code"`
+	Stdout       string `json:"stdout,omitzero"`
+	StdoutLength int    `json:"stdout_original_length,omitzero"`
+	Stderr       string `json:"stderr,omitzero"`
+	StderrLength int    `json:"stderr_original_length,omitzero"`
 }
 
 func (r bashToolResult) result() (string, error) {
@@ -33,13 +42,60 @@ func (r bashToolResult) result() (string, error) {
 
 var _ llm.Tool = (*bashTool)(nil)
 
+type BashOption func(*bashTool)
+
 type bashTool struct {
-	logger logger.Logger
-	exec   func(context.Context, string) (int, string, string, error)
+	logger         logger.Logger
+	exec           func(context.Context, string) (int, string, string, error)
+	timeout        time.Duration
+	maxOutputBytes int
+	writableDir    string
+	networkCmds    []string
+	allow          []*regexp.Regexp
+	deny           []*regexp.Regexp
+}
+
+func WithBashTimeout(timeout time.Duration) BashOption {
+	return func(t *bashTool) {
+		if timeout > 0 {
+			t.timeout = timeout
+		}
+	}
+}
+
+func WithBashMaxOutputBytes(maxOutputBytes int) BashOption {
+	return func(t *bashTool) {
+		if maxOutputBytes > 0 {
+			t.maxOutputBytes = maxOutputBytes
+		}
+	}
+}
+
+func WithBashSandbox(writableDir string, networkCmds []string) BashOption {
+	return func(t *bashTool) {
+		t.writableDir = writableDir
+		t.networkCmds = networkCmds
+	}
+}
+
+func WithBashPolicy(allow, deny []*regexp.Regexp) BashOption {
+	return func(t *bashTool) {
+		t.allow = allow
+		t.deny = deny
+	}
 }
 
-func NewBash(exec func(context.Context, string) (int, string, string, error)) *bashTool {
-	return &bashTool{logger.NoOp(), exec}
+func NewBash(exec func(context.Context, string) (int, string, string, error), opts ...BashOption) *bashTool {
+	t := &bashTool{
+		logger:         logger.NoOp(),
+		exec:           exec,
+		timeout:        bashToolDefaultTimeout,
+		maxOutputBytes: bashToolDefaultMaxOutputBytes,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 func (t *bashTool) SetLogger(logger logger.Logger) *bashTool {
@@ -51,7 +107,22 @@ func (t *bashTool) SetLogger(logger logger.Logger) *bashTool {
 var bashToolDescription string
 
 func (t *bashTool) Spec() (string, string, json.RawMessage) {
-	return "bash", strings.TrimSpace(bashToolDescription), json.RawMessage(`{
+	description := strings.TrimSpace(bashToolDescription)
+	// the sandbox exceptions configured by the user are appended to the generic description
+	var exceptions []string
+	if t.writableDir != "" {
+		exceptions = append(exceptions, fmt.Sprintf("- The `%s` directory is writable, use it for build artifacts, test output and other scratch files.", t.writableDir))
+	}
+	if len(t.networkCmds) > 0 {
+		exceptions = append(exceptions, fmt.Sprintf("- Network access is enabled for these commands when run on their own (no `;`, `&&`, pipes or redirects): `%s`.", strings.Join(t.networkCmds, "`, `")))
+	}
+	if len(exceptions) > 0 {
+		description += "\n\nSandbox exceptions configured by the user (these override the restrictions above):\n\n" + strings.Join(exceptions, "\n")
+	}
+	if rules := t.describePolicy(); rules != "" {
+		description += "\n\nCommand policy configured by the user (commands breaking it are refused without running):\n\n" + rules
+	}
+	return "bash", description, json.RawMessage(`{
 		"type": "object",
 		"properties": {
 			"command": {
@@ -77,15 +148,117 @@ func (t *bashTool) Call(ctx context.Context, args string) (string, error) {
 		t.logger.Errorf("bash tool called with command exceeding max length: %d", len(cmd))
 		return bashToolResult{Ok: false, Error: fmt.Sprintf("command exceeds maximum length of %d characters", bashToolMaxCmdLength)}.result()
 	}
-	_, stdout, stderr, err := t.exec(ctx, cmd)
+	if err := t.checkPolicy(cmd); err != nil {
+		t.logger.Errorf("bash tool refused %q: %s", cmd, err.Error())
+		return bashToolResult{Ok: false, Error: err.Error()}.result()
+	}
+	execCtx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	exitCode, stdout, stderr, err := t.exec(execCtx, cmd)
+	if ctx.Err() == nil && errors.Is(execCtx.Err(), context.DeadlineExceeded) {
+		t.logger.Errorf("bash tool execution of %q timed out after %s", cmd, t.timeout)
+		return t.withOutput(bashToolResult{
+			Ok:    false,
+			Error: fmt.Sprintf("command timed out after %ds", int(t.timeout.Seconds())),
+		}, stdout, stderr).result()
+	}
 	if err != nil {
 		t.logger.Errorf("bash tool execution of %q failed: %s", cmd, err.Error())
 		return bashToolResult{Ok: false, Error: err.Error()}.result()
 	}
 	t.logger.Debugf("bash tool executed %q successfully", cmd)
-	return bashToolResult{
-		Ok:     true,
-		Stdout: stdout,
-		Stderr: stderr,
-	}.result()
+	return t.withOutput(bashToolResult{Ok: true, ExitCode: exitCode}, stdout, stderr).result()
+}
+
+var bashCommandSeparatorRegexp = regexp.MustCompile(`&&|\|\||[;&|\n]`)
+
+// output redirects to files (e.g. `> file`, `>> file`, `&> file`), duplicating a descriptor like `2>&1` is not one
+var bashOutputRedirectRegexp = regexp.MustCompile(`>>?\|?\s*([^\s&;|<>()]+)`)
+
+func (t *bashTool) checkPolicy(cmd string) error {
+	// the policy is on top of the sandbox, a command is refused if it matches any deny pattern
+	for _, re := range t.deny {
+		if re.MatchString(cmd) {
+			return fmt.Errorf("command refused by the bash policy: matches denied pattern `%s`", re.String())
+		}
+	}
+	if len(t.allow) == 0 {
+		return nil
+	}
+	// with an allowlist every chained command must be allowed on its own, substitutions could run anything
+	if strings.Contains(cmd, "`") || strings.Contains(cmd, "$(") {
+		return errors.New("command refused by the bash policy: command substitution is not allowed")
+	}
+	if strings.Contains(cmd, "<(") || strings.Contains(cmd, ">(") {
+		return errors.New("command refused by the bash policy: process substitution is not allowed")
+	}
+	// a redirect could overwrite any file (e.g. `echo x > ~/.bashrc`) no matter which command is allowed
+	for _, match := range bashOutputRedirectRegexp.FindAllStringSubmatch(cmd, -1) {
+		if match[1] != "/dev/null" {
+			return fmt.Errorf("command refused by the bash policy: output redirect to `%s` is not allowed", match[1])
+		}
+	}
+	for _, segment := range bashCommandSeparatorRegexp.Split(cmd, -1) {
+		segment = strings.TrimSpace(segment)
+		if segment == "" {
+			continue
+		}
+		allowed := false
+		for _, re := range t.allow {
+			if re.MatchString(segment) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("command refused by the bash policy: `%s` does not match any allowed pattern", segment)
+		}
+	}
+	return nil
+}
+
+func (t *bashTool) describePolicy() string {
+	var rules []string
+	if len(t.allow) > 0 {
+		patterns := make([]string, len(t.allow))
+		for i, re := range t.allow {
+			patterns[i] = re.String()
+		}
+		rules = append(rules, fmt.Sprintf("- Each command (also each part of a chained command) must match one of these regular expressions: `%s`. Command and process substitution and output redirects to files (other than `/dev/null`) are refused.", strings.Join(patterns, "`, `")))
+	}
+	if len(t.deny) > 0 {
+		patterns := make([]string, len(t.deny))
+		for i, re := range t.deny {
+			patterns[i] = re.String()
+		}
+		rules = append(rules, fmt.Sprintf("- Commands matching any of these regular expressions are refused: `%s`.", strings.Join(patterns, "`, `")))
+	}
+	return strings.Join(rules, "\n")
+}
+
+func (t *bashTool) withOutput(r bashToolResult, stdout, stderr string) bashToolResult {
+	r.Stdout = truncateMiddle(stdout, t.maxOutputBytes)
+	if len(r.Stdout) != len(stdout) {
+		r.StdoutLength = len(stdout)
+	}
+	r.Stderr = truncateMiddle(stderr, t.maxOutputBytes)
+	if len(r.Stderr) != len(stderr) {
+		r.StderrLength = len(stderr)
+	}
+	return r
+}
+
+func truncateMiddle(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	// keep the head and the tail as they are usually the most informative parts of the output
+	head, tail := maxBytes/2, len(s)-maxBytes/2
+	for head > 0 && !utf8.RuneStart(s[head]) {
+		head--
+	}
+	for tail < len(s) && !utf8.RuneStart(s[tail]) {
+		tail++
+	}
+	return fmt.Sprintf("%s\n... (%d bytes omitted) ...\n%s", s[:head], tail-head, s[tail:])
 }
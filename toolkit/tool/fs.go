@@ -7,16 +7,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/markusylisiurunen/ikm/internal/logger"
 	"github.com/markusylisiurunen/ikm/toolkit/llm"
 	"github.com/tidwall/gjson"
 )
 
+type EditRecorder func(path string)
+
 // fs_list -----------------------------------------------------------------------------------------
 
 const (
@@ -38,12 +45,25 @@ func (r fsListToolResult) result() (string, error) {
 	return string(b), nil
 }
 
+type FSListOption func(*fsListTool)
+
 type fsListTool struct {
 	logger logger.Logger
+	roots  []string
 }
 
-func NewFSList() *fsListTool {
-	return &fsListTool{logger.NoOp()}
+func WithFSListRoots(roots []string) FSListOption {
+	return func(t *fsListTool) {
+		t.roots = roots
+	}
+}
+
+func NewFSList(opts ...FSListOption) *fsListTool {
+	t := &fsListTool{logger: logger.NoOp()}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 func (t *fsListTool) SetLogger(logger logger.Logger) *fsListTool {
@@ -55,12 +75,20 @@ func (t *fsListTool) SetLogger(logger logger.Logger) *fsListTool {
 var fsListToolDescription string
 
 func (t *fsListTool) Spec() (string, string, json.RawMessage) {
-	return "fs_list", strings.TrimSpace(fsListToolDescription), json.RawMessage(`{
+	return "fs_list", describeFSRoots(fsListToolDescription, t.roots), json.RawMessage(`{
 		"type": "object",
 		"properties": {
 			"path": {
 				"type": "string",
 				"description": "The absolute path to the directory to list"
+			},
+			"glob": {
+				"type": "string",
+				"description": "Only list files matching this glob pattern relative to the path (e.g. '**/*.go', 'cmd/*'), a pattern without a slash matches the file name"
+			},
+			"max_depth": {
+				"type": "integer",
+				"description": "Only list files at most this many levels below the path (1 lists only the files directly in the path)"
 			}
 		},
 		"required": ["path"]
@@ -74,11 +102,24 @@ func (t *fsListTool) Call(ctx context.Context, args string) (string, error) {
 	}
 	// validate the provided path
 	path := gjson.Get(args, "path").String()
-	absPath, err := validatePath(path)
+	absPath, err := validatePath(path, t.roots...)
 	if err != nil {
 		t.logger.Errorf("fs_list operation failed: %s", err.Error())
 		return fsListToolResult{Error: err.Error()}.result()
 	}
+	// validate the optional filters
+	glob := gjson.Get(args, "glob").String()
+	if glob != "" {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			t.logger.Errorf("fs_list operation failed: invalid glob %q: %s", glob, err.Error())
+			return fsListToolResult{Error: fmt.Sprintf("invalid glob pattern: %s", err.Error())}.result()
+		}
+	}
+	maxDepth := int(gjson.Get(args, "max_depth").Int())
+	if maxDepth < 0 {
+		t.logger.Errorf("fs_list operation failed: negative max_depth %d", maxDepth)
+		return fsListToolResult{Error: "max_depth must be a positive integer"}.result()
+	}
 	// check if the path exists and is a directory
 	fileInfo, err := os.Stat(absPath)
 	if err != nil {
@@ -96,43 +137,124 @@ func (t *fsListTool) Call(ctx context.Context, args string) (string, error) {
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	err = cmd.Run()
+	var files []string
 	var exitErr *exec.ExitError
-	if errors.As(err, &exitErr) {
+	if errors.As(err, &exitErr) && strings.Contains(stderr.String(), "not a git repository") {
+		// outside of a git repository there is no ignore information, so the directory is walked instead
+		files, err = walkFSListDir(ctx, absPath, glob, maxDepth)
+		if err != nil {
+			t.logger.Errorf("fs_list operation failed: %s", err.Error())
+			return fsListToolResult{Error: err.Error()}.result()
+		}
+	} else if errors.As(err, &exitErr) {
 		t.logger.Errorf("fs_list operation failed: %s", stderr.String())
 		return fsListToolResult{Error: fmt.Sprintf("command failed with exit code %d: %s", exitErr.ExitCode(), stderr.String())}.result()
-	}
-	if err != nil {
+	} else if err != nil {
 		t.logger.Errorf("fs_list operation failed: %s", err.Error())
 		return fsListToolResult{Error: fmt.Sprintf("command failed: %s", err.Error())}.result()
+	} else {
+		// process the output
+		for file := range strings.SplitSeq(strings.TrimSpace(stdout.String()), "\n") {
+			if file != "" && matchFSListFilters(file, glob, maxDepth) {
+				files = append(files, file)
+			}
+		}
 	}
-	// process the output
-	output := strings.TrimSpace(stdout.String())
-	if output == "" {
+	if len(files) == 0 {
 		t.logger.Debugf("fs_list operation succeeded: no files found")
 		return fsListToolResult{Files: []string{}}.result()
 	}
-	files := strings.Split(output, "\n")
 	if len(files) > fsListToolMaxFileCount {
-		err := fmt.Errorf("too many files to list: %d exceeds limit of %d", len(files), fsListToolMaxFileCount)
+		err := fmt.Errorf("too many files to list: %d exceeds limit of %d, narrow the listing with glob or max_depth", len(files), fsListToolMaxFileCount)
 		t.logger.Errorf("fs_list operation failed: %s", err.Error())
 		return fsListToolResult{Error: err.Error()}.result()
 	}
 	// convert relative paths to absolute paths
 	absFiles := make([]string, 0, len(files))
 	for _, file := range files {
-		if file != "" {
-			absFile := filepath.Join(absPath, file)
-			absFiles = append(absFiles, absFile)
-		}
+		absFiles = append(absFiles, filepath.Join(absPath, file))
 	}
 	t.logger.Debugf("fs_list operation succeeded: found %d files", len(absFiles))
 	return fsListToolResult{Files: absFiles}.result()
 }
 
+func walkFSListDir(ctx context.Context, root, glob string, maxDepth int) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && (d.Name() == ".git" || (maxDepth > 0 && strings.Count(rel, "/")+1 >= maxDepth)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if matchFSListFilters(rel, glob, maxDepth) {
+			files = append(files, rel)
+		}
+		// there is no point in walking further once the limit is exceeded
+		if len(files) > fsListToolMaxFileCount {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+	return files, nil
+}
+
+func matchFSListFilters(file, glob string, maxDepth int) bool {
+	segments := strings.Split(file, "/")
+	if maxDepth > 0 && len(segments) > maxDepth {
+		return false
+	}
+	if glob == "" {
+		return true
+	}
+	if !strings.Contains(glob, "/") {
+		ok, _ := filepath.Match(glob, segments[len(segments)-1])
+		return ok
+	}
+	return matchGlobSegments(strings.Split(glob, "/"), segments)
+}
+
+func matchGlobSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	// a "**" segment matches any number of directories, including none
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchGlobSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchGlobSegments(pattern[1:], segments[1:])
+}
+
 // fs_read -----------------------------------------------------------------------------------------
 
 const (
-	fsReadToolMaxFileSize = 10 * 1024 * 1024
+	fsReadToolMaxFileSize   = 10 * 1024 * 1024
+	fsReadToolSniffByteSize = 8 * 1024
 )
 
 var _ llm.Tool = (*fsReadTool)(nil)
@@ -150,12 +272,25 @@ func (r fsReadToolResult) result() (string, error) {
 	return string(b), nil
 }
 
+type FSReadOption func(*fsReadTool)
+
 type fsReadTool struct {
 	logger logger.Logger
+	roots  []string
 }
 
-func NewFSRead() *fsReadTool {
-	return &fsReadTool{logger.NoOp()}
+func WithFSReadRoots(roots []string) FSReadOption {
+	return func(t *fsReadTool) {
+		t.roots = roots
+	}
+}
+
+func NewFSRead(opts ...FSReadOption) *fsReadTool {
+	t := &fsReadTool{logger: logger.NoOp()}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 func (t *fsReadTool) SetLogger(logger logger.Logger) *fsReadTool {
@@ -167,7 +302,7 @@ func (t *fsReadTool) SetLogger(logger logger.Logger) *fsReadTool {
 var fsReadToolDescription string
 
 func (t *fsReadTool) Spec() (string, string, json.RawMessage) {
-	return "fs_read", strings.TrimSpace(fsReadToolDescription), json.RawMessage(`{
+	return "fs_read", describeFSRoots(fsReadToolDescription, t.roots), json.RawMessage(`{
 		"type": "object",
 		"properties": {
 			"path": {
@@ -185,6 +320,10 @@ func (t *fsReadTool) Spec() (string, string, json.RawMessage) {
 			"no_line_numbers": {
 				"type": "boolean",
 				"description": "If true, do not add line numbers to the output"
+			},
+			"force": {
+				"type": "boolean",
+				"description": "If true, read the file even if it appears to be binary"
 			}
 		},
 		"required": ["path"]
@@ -201,7 +340,8 @@ func (t *fsReadTool) Call(ctx context.Context, args string) (string, error) {
 	offset := gjson.Get(args, "offset").Int()
 	limit := gjson.Get(args, "limit").Int()
 	noLineNumbers := gjson.Get(args, "no_line_numbers").Bool()
-	absPath, err := validatePath(filePath)
+	force := gjson.Get(args, "force").Bool()
+	absPath, err := validatePath(filePath, t.roots...)
 	if err != nil {
 		t.logger.Errorf("fs_read operation failed: %s", err.Error())
 		return fsReadToolResult{Error: err.Error()}.result()
@@ -217,6 +357,19 @@ func (t *fsReadTool) Call(ctx context.Context, args string) (string, error) {
 		t.logger.Errorf("fs_read operation failed: %s", err.Error())
 		return fsReadToolResult{Error: err.Error()}.result()
 	}
+	// binary content only wastes tokens, so it is not read unless explicitly forced
+	if !force {
+		binary, err := isBinaryFile(absPath)
+		if err != nil {
+			t.logger.Errorf("fs_read operation failed: %s", err.Error())
+			return fsReadToolResult{Error: fmt.Sprintf("failed to read file: %s", err.Error())}.result()
+		}
+		if binary {
+			err := fmt.Errorf("file appears to be binary (%d bytes), not reading", fileInfo.Size())
+			t.logger.Errorf("fs_read operation failed: %s", err.Error())
+			return fsReadToolResult{Error: err.Error()}.result()
+		}
+	}
 	// read the file using appropriate command based on offset and limit
 	var cmd *exec.Cmd
 	if offset > 0 && limit > 0 {
@@ -264,6 +417,30 @@ func (t *fsReadTool) Call(ctx context.Context, args string) (string, error) {
 	return fsReadToolResult{Content: content}.result()
 }
 
+func isBinaryFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close() //nolint:errcheck
+	buf := make([]byte, fsReadToolSniffByteSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, err
+	}
+	buf = buf[:n]
+	if bytes.IndexByte(buf, 0) >= 0 {
+		return true, nil
+	}
+	// the sniffed prefix may end in the middle of a multi-byte character
+	if n == fsReadToolSniffByteSize {
+		for i := 0; i < utf8.UTFMax && len(buf) > 0 && !utf8.Valid(buf); i++ {
+			buf = buf[:len(buf)-1]
+		}
+	}
+	return !utf8.Valid(buf), nil
+}
+
 // fs_write ----------------------------------------------------------------------------------------
 
 const (
@@ -273,7 +450,9 @@ const (
 var _ llm.Tool = (*fsWriteTool)(nil)
 
 type fsWriteToolResult struct {
-	Error string `json:"error,omitzero"`
+	Error  string `json:"error,omitzero"`
+	DryRun bool   `json:"dry_run,omitzero"`
+	Diff   string `json:"diff,omitzero"`
 }
 
 func (r fsWriteToolResult) result() (string, error) {
@@ -284,12 +463,25 @@ func (r fsWriteToolResult) result() (string, error) {
 	return string(b), nil
 }
 
+type FSWriteOption func(*fsWriteTool)
+
 type fsWriteTool struct {
 	logger logger.Logger
+	record EditRecorder
 }
 
-func NewFSWrite() *fsWriteTool {
-	return &fsWriteTool{logger.NoOp()}
+func WithFSWriteRecorder(record EditRecorder) FSWriteOption {
+	return func(t *fsWriteTool) {
+		t.record = record
+	}
+}
+
+func NewFSWrite(opts ...FSWriteOption) *fsWriteTool {
+	t := &fsWriteTool{logger: logger.NoOp()}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 func (t *fsWriteTool) SetLogger(logger logger.Logger) *fsWriteTool {
@@ -311,6 +503,10 @@ func (t *fsWriteTool) Spec() (string, string, json.RawMessage) {
 			"content": {
 				"type": "string",
 				"description": "The content to write to the file"
+			},
+			"dry_run": {
+				"type": "boolean",
+				"description": "Return a unified diff of the change without writing the file (default false)"
 			}
 		},
 		"required": ["path", "content"]
@@ -339,6 +535,16 @@ func (t *fsWriteTool) Call(ctx context.Context, args string) (string, error) {
 		t.logger.Errorf("fs_write operation failed: %s", err.Error())
 		return fsWriteToolResult{Error: err.Error()}.result()
 	}
+	if gjson.Get(args, "dry_run").Bool() {
+		// a missing file is diffed as empty, nothing is created on disk
+		oldContent, err := os.ReadFile(absPath)
+		if err != nil && !os.IsNotExist(err) {
+			t.logger.Errorf("fs_write operation failed: %s", err.Error())
+			return fsWriteToolResult{Error: fmt.Sprintf("failed to read file: %s", err.Error())}.result()
+		}
+		t.logger.Debugf("fs_write dry run for path %q succeeded", filePath)
+		return fsWriteToolResult{DryRun: true, Diff: unifiedDiff(filePath, string(oldContent), content)}.result()
+	}
 	// make sure the parent directory exists
 	parentDir := filepath.Dir(absPath)
 	if err := os.MkdirAll(parentDir, 0755); err != nil {
@@ -346,11 +552,14 @@ func (t *fsWriteTool) Call(ctx context.Context, args string) (string, error) {
 		return fsWriteToolResult{Error: fmt.Sprintf("failed to create parent directories: %s", err.Error())}.result()
 	}
 	// write the content to the file
-	if err := os.WriteFile(absPath, []byte(content), 0644); err != nil {
+	if err := writeFilePreservingMode(absPath, []byte(content)); err != nil {
 		t.logger.Errorf("fs_write operation failed: %s", err.Error())
 		return fsWriteToolResult{Error: fmt.Sprintf("failed to write file: %s", err.Error())}.result()
 	}
 	t.logger.Debugf("fs_write operation for path %q succeeded", filePath)
+	if t.record != nil {
+		t.record(absPath)
+	}
 	return fsWriteToolResult{}.result()
 }
 
@@ -363,7 +572,9 @@ const (
 var _ llm.Tool = (*fsReplaceTool)(nil)
 
 type fsReplaceToolResult struct {
-	Error string `json:"error,omitzero"`
+	Error  string `json:"error,omitzero"`
+	DryRun bool   `json:"dry_run,omitzero"`
+	Diff   string `json:"diff,omitzero"`
 }
 
 func (r fsReplaceToolResult) result() (string, error) {
@@ -374,12 +585,25 @@ func (r fsReplaceToolResult) result() (string, error) {
 	return string(b), nil
 }
 
+type FSReplaceOption func(*fsReplaceTool)
+
 type fsReplaceTool struct {
 	logger logger.Logger
+	record EditRecorder
 }
 
-func NewFSReplace() *fsReplaceTool {
-	return &fsReplaceTool{logger.NoOp()}
+func WithFSReplaceRecorder(record EditRecorder) FSReplaceOption {
+	return func(t *fsReplaceTool) {
+		t.record = record
+	}
+}
+
+func NewFSReplace(opts ...FSReplaceOption) *fsReplaceTool {
+	t := &fsReplaceTool{logger: logger.NoOp()}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 func (t *fsReplaceTool) SetLogger(logger logger.Logger) *fsReplaceTool {
@@ -409,6 +633,14 @@ func (t *fsReplaceTool) Spec() (string, string, json.RawMessage) {
 			"replace_all": {
 				"type": "boolean",
 				"description": "Replace all occurrences of old_string (default false)"
+			},
+			"regex": {
+				"type": "boolean",
+				"description": "Treat old_string as a Go regular expression, new_string may reference capture groups with $1 or ${name} (default false)"
+			},
+			"dry_run": {
+				"type": "boolean",
+				"description": "Return a unified diff of the change without writing the file (default false)"
 			}
 		},
 		"required": ["path", "old_string", "new_string"]
@@ -425,6 +657,7 @@ func (t *fsReplaceTool) Call(ctx context.Context, args string) (string, error) {
 	oldStr := gjson.Get(args, "old_string").String()
 	newStr := gjson.Get(args, "new_string").String()
 	replaceAll := gjson.Get(args, "replace_all").Bool()
+	useRegex := gjson.Get(args, "regex").Bool()
 	if oldStr == "" {
 		t.logger.Errorf("fs_replace operation failed: old_string parameter is required")
 		return fsReplaceToolResult{Error: "old_string parameter is required"}.result()
@@ -433,6 +666,15 @@ func (t *fsReplaceTool) Call(ctx context.Context, args string) (string, error) {
 		t.logger.Errorf("fs_replace operation failed: old_string and new_string must be different")
 		return fsReplaceToolResult{Error: "old_string and new_string must be different"}.result()
 	}
+	var re *regexp.Regexp
+	if useRegex {
+		var err error
+		re, err = regexp.Compile(oldStr)
+		if err != nil {
+			t.logger.Errorf("fs_replace operation failed: invalid regular expression: %s", err.Error())
+			return fsReplaceToolResult{Error: fmt.Sprintf("old_string is not a valid regular expression: %s", err.Error())}.result()
+		}
+	}
 	absPath, err := validatePath(filePath)
 	if err != nil {
 		t.logger.Errorf("fs_replace operation failed: %s", err.Error())
@@ -458,7 +700,12 @@ func (t *fsReplaceTool) Call(ctx context.Context, args string) (string, error) {
 	contentStr := string(content)
 	// replace the old string with the new string (if valid)
 	if !replaceAll {
-		occurrences := strings.Count(contentStr, oldStr)
+		var occurrences int
+		if useRegex {
+			occurrences = len(re.FindAllStringIndex(contentStr, -1))
+		} else {
+			occurrences = strings.Count(contentStr, oldStr)
+		}
 		if occurrences == 0 {
 			err := fmt.Errorf("old_string not found in file")
 			t.logger.Errorf("fs_replace operation failed: %s", err.Error())
@@ -471,9 +718,16 @@ func (t *fsReplaceTool) Call(ctx context.Context, args string) (string, error) {
 		}
 	}
 	var newContent string
-	if replaceAll {
+	switch {
+	case useRegex && replaceAll:
+		newContent = re.ReplaceAllString(contentStr, newStr)
+	case useRegex:
+		loc := re.FindStringSubmatchIndex(contentStr)
+		replacement := re.ExpandString(nil, newStr, contentStr, loc)
+		newContent = contentStr[:loc[0]] + string(replacement) + contentStr[loc[1]:]
+	case replaceAll:
 		newContent = strings.ReplaceAll(contentStr, oldStr, newStr)
-	} else {
+	default:
 		newContent = strings.Replace(contentStr, oldStr, newStr, 1)
 	}
 	// write the modified content back to the file
@@ -482,17 +736,301 @@ func (t *fsReplaceTool) Call(ctx context.Context, args string) (string, error) {
 		t.logger.Errorf("fs_replace operation failed: %s", err.Error())
 		return fsReplaceToolResult{Error: err.Error()}.result()
 	}
-	if err := os.WriteFile(absPath, []byte(newContent), 0644); err != nil {
+	if gjson.Get(args, "dry_run").Bool() {
+		t.logger.Debugf("fs_replace dry run for path %q succeeded", filePath)
+		return fsReplaceToolResult{DryRun: true, Diff: unifiedDiff(filePath, contentStr, newContent)}.result()
+	}
+	if err := writeFilePreservingMode(absPath, []byte(newContent)); err != nil {
 		t.logger.Errorf("fs_replace operation failed: %s", err.Error())
 		return fsReplaceToolResult{Error: fmt.Sprintf("failed to write file: %s", err.Error())}.result()
 	}
 	t.logger.Debugf("fs_replace operation for path %q succeeded", filePath)
+	if t.record != nil {
+		t.record(absPath)
+	}
 	return fsReplaceToolResult{}.result()
 }
 
+// fs_patch ----------------------------------------------------------------------------------------
+
+const (
+	fsPatchToolMaxFileSize = 10 * 1024 * 1024
+)
+
+var _ llm.Tool = (*fsPatchTool)(nil)
+
+type fsPatchToolHunkResult struct {
+	Header string `json:"header"`
+	Line   int    `json:"line"`
+}
+type fsPatchToolResult struct {
+	Error   string                  `json:"error,omitzero"`
+	Applied []fsPatchToolHunkResult `json:"applied,omitzero"`
+}
+
+func (r fsPatchToolResult) result() (string, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result to JSON: %w", err)
+	}
+	return string(b), nil
+}
+
+type FSPatchOption func(*fsPatchTool)
+
+type fsPatchTool struct {
+	logger logger.Logger
+	record EditRecorder
+}
+
+func WithFSPatchRecorder(record EditRecorder) FSPatchOption {
+	return func(t *fsPatchTool) {
+		t.record = record
+	}
+}
+
+func NewFSPatch(opts ...FSPatchOption) *fsPatchTool {
+	t := &fsPatchTool{logger: logger.NoOp()}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (t *fsPatchTool) SetLogger(logger logger.Logger) *fsPatchTool {
+	t.logger = logger
+	return t
+}
+
+//go:embed fs_patch.md
+var fsPatchToolDescription string
+
+func (t *fsPatchTool) Spec() (string, string, json.RawMessage) {
+	return "fs_patch", strings.TrimSpace(fsPatchToolDescription), json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"path": {
+				"type": "string",
+				"description": "The absolute path to the file to patch"
+			},
+			"diff": {
+				"type": "string",
+				"description": "The unified diff to apply to the file, containing one or more hunks"
+			}
+		},
+		"required": ["path", "diff"]
+	}`)
+}
+
+func (t *fsPatchTool) Call(ctx context.Context, args string) (string, error) {
+	if !gjson.Valid(args) {
+		t.logger.Errorf("fs_patch tool called with invalid JSON arguments")
+		return fsPatchToolResult{Error: "invalid JSON arguments"}.result()
+	}
+	// validate the provided path and diff
+	filePath := gjson.Get(args, "path").String()
+	diff := gjson.Get(args, "diff").String()
+	if strings.TrimSpace(diff) == "" {
+		t.logger.Errorf("fs_patch operation failed: diff parameter is required")
+		return fsPatchToolResult{Error: "diff parameter is required"}.result()
+	}
+	absPath, err := validatePath(filePath)
+	if err != nil {
+		t.logger.Errorf("fs_patch operation failed: %s", err.Error())
+		return fsPatchToolResult{Error: err.Error()}.result()
+	}
+	hunks, err := parseUnifiedDiff(diff)
+	if err != nil {
+		t.logger.Errorf("fs_patch operation failed: %s", err.Error())
+		return fsPatchToolResult{Error: err.Error()}.result()
+	}
+	// read the file content, a missing file is treated as empty so that a diff can create it
+	var content []byte
+	fileInfo, err := os.Stat(absPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		t.logger.Errorf("fs_patch operation failed: %s", err.Error())
+		return fsPatchToolResult{Error: fmt.Sprintf("failed to stat file: %s", err.Error())}.result()
+	case fileInfo.Size() > fsPatchToolMaxFileSize:
+		err := fmt.Errorf("file size exceeds limit of %d bytes", fsPatchToolMaxFileSize)
+		t.logger.Errorf("fs_patch operation failed: %s", err.Error())
+		return fsPatchToolResult{Error: err.Error()}.result()
+	default:
+		content, err = os.ReadFile(absPath)
+		if err != nil {
+			t.logger.Errorf("fs_patch operation failed: %s", err.Error())
+			return fsPatchToolResult{Error: fmt.Sprintf("failed to read file: %s", err.Error())}.result()
+		}
+	}
+	// apply every hunk in memory first, so that a failing hunk leaves the file untouched
+	newContent, applied, err := applyUnifiedDiff(string(content), hunks)
+	if err != nil {
+		t.logger.Errorf("fs_patch operation failed: %s", err.Error())
+		return fsPatchToolResult{Error: err.Error()}.result()
+	}
+	if len(newContent) > fsPatchToolMaxFileSize {
+		err := fmt.Errorf("new content size exceeds limit of %d bytes", fsPatchToolMaxFileSize)
+		t.logger.Errorf("fs_patch operation failed: %s", err.Error())
+		return fsPatchToolResult{Error: err.Error()}.result()
+	}
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		t.logger.Errorf("fs_patch operation failed: %s", err.Error())
+		return fsPatchToolResult{Error: fmt.Sprintf("failed to create parent directories: %s", err.Error())}.result()
+	}
+	if err := writeFilePreservingMode(absPath, []byte(newContent)); err != nil {
+		t.logger.Errorf("fs_patch operation failed: %s", err.Error())
+		return fsPatchToolResult{Error: fmt.Sprintf("failed to write file: %s", err.Error())}.result()
+	}
+	t.logger.Debugf("fs_patch operation for path %q succeeded: applied %d hunks", filePath, len(applied))
+	if t.record != nil {
+		t.record(absPath)
+	}
+	return fsPatchToolResult{Applied: applied}.result()
+}
+
+type unifiedDiffHunk struct {
+	header   string
+	oldStart int
+	oldLines []string
+	newLines []string
+}
+
+var unifiedDiffHunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+func parseUnifiedDiff(diff string) ([]unifiedDiffHunk, error) {
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(diff, "\r\n", "\n"), "\n"), "\n")
+	var (
+		hunks     []unifiedDiffHunk
+		current   *unifiedDiffHunk
+		fileCount int
+	)
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		// a file header is a "---" line directly followed by a "+++" line
+		if strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+			fileCount++
+			if fileCount > 1 {
+				return nil, errors.New("diff touches multiple files, patch one file per call")
+			}
+			current = nil
+			i++
+			continue
+		}
+		if strings.HasPrefix(line, "@@") {
+			m := unifiedDiffHunkHeaderRegex.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("invalid hunk header %q", line)
+			}
+			oldStart, _ := strconv.Atoi(m[1])
+			hunks = append(hunks, unifiedDiffHunk{header: strings.TrimSpace(m[0]), oldStart: oldStart})
+			current = &hunks[len(hunks)-1]
+			continue
+		}
+		if current == nil {
+			// anything before the first hunk (e.g. "diff --git" or "index" lines) is ignored
+			continue
+		}
+		switch {
+		case line == "":
+			// editors and models often strip the single space of an empty context line
+			current.oldLines = append(current.oldLines, "")
+			current.newLines = append(current.newLines, "")
+		case line[0] == ' ':
+			current.oldLines = append(current.oldLines, line[1:])
+			current.newLines = append(current.newLines, line[1:])
+		case line[0] == '-':
+			current.oldLines = append(current.oldLines, line[1:])
+		case line[0] == '+':
+			current.newLines = append(current.newLines, line[1:])
+		case line[0] == '\\':
+			// "\ No newline at end of file" markers are ignored
+		default:
+			return nil, fmt.Errorf("hunk %d (%s) has an invalid line %q, lines must start with ' ', '-' or '+'", len(hunks), current.header, line)
+		}
+	}
+	if len(hunks) == 0 {
+		return nil, errors.New("diff contains no hunks")
+	}
+	return hunks, nil
+}
+
+func applyUnifiedDiff(content string, hunks []unifiedDiffHunk) (string, []fsPatchToolHunkResult, error) {
+	hasTrailingNewline := content == "" || strings.HasSuffix(content, "\n")
+	var lines []string
+	if content != "" {
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+	applied := make([]fsPatchToolHunkResult, 0, len(hunks))
+	// offset tracks how much earlier hunks have shifted the line numbers, minIndex keeps hunks in order
+	offset, minIndex := 0, 0
+	for i, hunk := range hunks {
+		expected := max(hunk.oldStart-1, 0) + offset
+		if len(hunk.oldLines) == 0 && hunk.oldStart > 0 {
+			// a pure insertion hunk refers to the line after which the new lines are inserted
+			expected = hunk.oldStart + offset
+		}
+		index := findUnifiedDiffHunk(lines, hunk.oldLines, expected, minIndex)
+		if index < 0 {
+			return "", nil, fmt.Errorf("hunk %d (%s) does not match the file: %s", i+1, hunk.header,
+				describeUnifiedDiffMismatch(lines, hunk.oldLines, expected))
+		}
+		patched := make([]string, 0, len(lines)-len(hunk.oldLines)+len(hunk.newLines))
+		patched = append(patched, lines[:index]...)
+		patched = append(patched, hunk.newLines...)
+		patched = append(patched, lines[index+len(hunk.oldLines):]...)
+		lines = patched
+		applied = append(applied, fsPatchToolHunkResult{Header: hunk.header, Line: index + 1})
+		offset += len(hunk.newLines) - len(hunk.oldLines)
+		minIndex = index + len(hunk.newLines)
+	}
+	if len(lines) == 0 {
+		return "", applied, nil
+	}
+	newContent := strings.Join(lines, "\n")
+	if hasTrailingNewline {
+		newContent += "\n"
+	}
+	return newContent, applied, nil
+}
+
+func findUnifiedDiffHunk(lines, oldLines []string, expected, minIndex int) int {
+	// the hunk is placed at the matching position closest to where its header says it should be
+	best := -1
+	for index := minIndex; index+len(oldLines) <= len(lines); index++ {
+		if !slices.Equal(lines[index:index+len(oldLines)], oldLines) {
+			continue
+		}
+		if best < 0 || abs(index-expected) < abs(best-expected) {
+			best = index
+		}
+	}
+	return best
+}
+
+func describeUnifiedDiffMismatch(lines, oldLines []string, expected int) string {
+	expected = min(max(expected, 0), len(lines))
+	for i, want := range oldLines {
+		if expected+i >= len(lines) {
+			return fmt.Sprintf("expected line %d to be %q but the file has only %d lines", expected+i+1, want, len(lines))
+		}
+		if got := lines[expected+i]; got != want {
+			return fmt.Sprintf("expected line %d to be %q but found %q", expected+i+1, want, got)
+		}
+	}
+	return "the context lines were not found in the file"
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
 // helpers -----------------------------------------------------------------------------------------
 
-func validatePath(filePath string) (string, error) {
+func validatePath(filePath string, roots ...string) (string, error) {
 	if filePath == "" {
 		return "", fmt.Errorf("path parameter is required")
 	}
@@ -513,14 +1051,91 @@ func validatePath(filePath string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to get current working directory: %s", err.Error())
 	}
-	// ensure the absolute path is within the current working directory
-	relPath, err := filepath.Rel(cwd, absPath)
+	// symlinks are resolved so that a link inside an allowed directory cannot be used to reach outside of it
+	resolvedPath, err := resolveSymlinks(absPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to determine relative path: %s", err.Error())
+		return "", fmt.Errorf("failed to resolve symlinks: %s", err.Error())
+	}
+	// ensure the path is within the current working directory or one of the extra roots
+	for _, root := range append([]string{cwd}, roots...) {
+		resolvedRoot, err := resolveSymlinks(root)
+		if err == nil && isWithinDir(resolvedRoot, resolvedPath) {
+			return absPath, nil
+		}
+	}
+	if len(roots) > 0 {
+		return "", fmt.Errorf("path must be within the current working directory or one of: %s", strings.Join(roots, ", "))
+	}
+	return "", fmt.Errorf("path must be within the current working directory")
+}
+
+func resolveSymlinks(path string) (string, error) {
+	// a file that does not exist yet (e.g. one about to be written) is resolved through its nearest existing parent
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		// writing through a dangling symlink would create its target, wherever that is
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s is a symlink to a missing file", path)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+func isWithinDir(dir, path string) bool {
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	// check if the path tries to escape the directory
+	return relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator)) && !filepath.IsAbs(relPath)
+}
+
+func describeFSRoots(description string, roots []string) string {
+	description = strings.TrimSpace(description)
+	if len(roots) > 0 {
+		description += fmt.Sprintf("\n\nBesides the current working directory, these directories can be read: %s.", strings.Join(roots, ", "))
+	}
+	return description
+}
+
+func writeFilePreservingMode(path string, data []byte) error {
+	// overwritten files keep their mode (e.g. the executable bit on scripts), only new files get 0644
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	// writes go through a symlink to its target, renaming over the link would replace it with a regular file
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	// the data is written next to the file and renamed over it, so an interrupted write never leaves a partial file
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck
+	if _, err := f.Write(data); err != nil {
+		f.Close() //nolint:errcheck
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close() //nolint:errcheck
+		return err
 	}
-	// check if the path tries to escape the current working directory
-	if strings.HasPrefix(relPath, "..") || filepath.IsAbs(relPath) {
-		return "", fmt.Errorf("path must be within the current working directory")
+	if err := f.Close(); err != nil {
+		return err
 	}
-	return absPath, nil
+	return os.Rename(f.Name(), path)
 }
@@ -8,22 +8,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"image"
+	_ "image/gif"
 	"image/jpeg"
 	"image/png"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/markusylisiurunen/ikm/internal/logger"
 	"github.com/markusylisiurunen/ikm/toolkit/llm"
 	"github.com/tidwall/gjson"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
 )
 
 const (
 	llmToolMaxFileSize     = 50 * 1024 * 1024
 	llmToolMaxImageSize    = 1536 // 2*768 pixels: https://ai.google.dev/gemini-api/docs/image-understanding#technical-details-image
+	llmToolImageQuality    = 90
 	llmToolMaxPromptLength = 32 * 1024
+	llmToolMaxAnswerLength = 16 * 1024
 	llmToolTimeout         = 5 * time.Minute
 )
 
@@ -42,22 +51,88 @@ func (r llmToolResult) result() (string, error) {
 
 var _ llm.Tool = (*llmTool)(nil)
 
+type LLMOption func(*llmTool)
+
 type llmTool struct {
 	logger          logger.Logger
 	openRouterToken string
 	availableModels map[string]string
+	progress        ProgressFunc
+	maxAnswerLength int
+	maxImageSize    int
+	imageQuality    int
+	httpTimeout     time.Duration
+}
+
+func WithLLMModels(models map[string]string) LLMOption {
+	return func(t *llmTool) {
+		if len(models) > 0 {
+			t.availableModels = models
+		}
+	}
 }
 
-func NewLLM(openRouterToken string) *llmTool {
-	return &llmTool{
+func WithLLMProgress(progress ProgressFunc) LLMOption {
+	return func(t *llmTool) {
+		t.progress = progress
+	}
+}
+
+func WithLLMMaxAnswerLength(length int) LLMOption {
+	return func(t *llmTool) {
+		if length > 0 {
+			t.maxAnswerLength = length
+		}
+	}
+}
+
+func WithLLMImageMaxSize(size int) LLMOption {
+	return func(t *llmTool) {
+		if size > 0 {
+			t.maxImageSize = size
+		}
+	}
+}
+
+func WithLLMImageQuality(quality int) LLMOption {
+	return func(t *llmTool) {
+		if quality >= 1 && quality <= 100 {
+			t.imageQuality = quality
+		}
+	}
+}
+
+func WithLLMHTTPTimeout(timeout time.Duration) LLMOption {
+	return func(t *llmTool) {
+		t.httpTimeout = timeout
+	}
+}
+
+func NewLLM(openRouterToken string, opts ...LLMOption) *llmTool {
+	t := &llmTool{
 		logger:          logger.NoOp(),
 		openRouterToken: openRouterToken,
+		maxAnswerLength: llmToolMaxAnswerLength,
+		maxImageSize:    llmToolMaxImageSize,
+		imageQuality:    llmToolImageQuality,
 		availableModels: map[string]string{
+			"claude-opus-4":    "anthropic/claude-opus-4",
 			"claude-sonnet-4":  "anthropic/claude-sonnet-4",
+			"codex-mini":       "openai/codex-mini",
+			"devstral-small":   "mistralai/devstral-small",
 			"gemini-2.5-flash": "google/gemini-2.5-flash",
 			"gemini-2.5-pro":   "google/gemini-2.5-pro",
+			"gpt-4.1":          "openai/gpt-4.1",
+			"gpt-4.1-mini":     "openai/gpt-4.1-mini",
+			"o3":               "openai/o3",
+			"o4-mini":          "openai/o4-mini",
+			"qwen3-32b":        "qwen/qwen3-32b",
 		},
 	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 func (t *llmTool) SetLogger(logger logger.Logger) *llmTool {
@@ -76,6 +151,11 @@ func (t *llmTool) Spec() (string, string, json.RawMessage) {
 				"type": "string",
 				"description": "The LLM model to use. Must be one of the available models."
 			},
+			"reasoning_effort": {
+				"type": "string",
+				"enum": ["low", "medium", "high"],
+				"description": "Optional reasoning effort for models that support reasoning. If not provided, the model's default is used."
+			},
 			"system_prompt": {
 				"type": "string",
 				"description": "Optional system prompt to set the context for the LLM. If not provided, no system prompt will be used."
@@ -91,6 +171,13 @@ func (t *llmTool) Spec() (string, string, json.RawMessage) {
 				},
 				"description": "Optional array of absolute file paths to images to include with the prompt. Images will be passed after the user prompt. Must be supported image formats."
 			},
+			"image_urls": {
+				"type": "array",
+				"items": {
+					"type": "string"
+				},
+				"description": "Optional array of public http(s) URLs to images to include with the prompt. The URLs are passed to the model as is, after any local images."
+			},
 			"pdf_paths": {
 				"type": "array",
 				"items": {
@@ -119,7 +206,22 @@ func (t *llmTool) Call(ctx context.Context, args string) (string, error) {
 	modelName := t.availableModels[model]
 	if modelName == "" {
 		t.logger.Errorf("llm tool called with invalid model: %s", model)
-		return llmToolResult{Error: fmt.Sprintf("model %q is not available", model)}.result()
+		return llmToolResult{Error: fmt.Sprintf("model %q is not available, must be one of: %s",
+			model, strings.Join(t.listModels(), ", "))}.result()
+	}
+	// validate the optional reasoning effort
+	streamOptions := []llm.StreamOption{
+		llm.WithMaxTokens(16384),
+		llm.WithMaxTurns(1),
+		llm.WithTemperature(0.7),
+	}
+	if effort := gjson.Get(args, "reasoning_effort").String(); effort != "" {
+		effortOption, err := getLLMToolReasoningEffort(effort)
+		if err != nil {
+			t.logger.Errorf("llm tool called with invalid reasoning effort: %s", effort)
+			return llmToolResult{Error: err.Error()}.result()
+		}
+		streamOptions = append(streamOptions, effortOption)
 	}
 	// validate user prompt
 	userPrompt := gjson.Get(args, "user_prompt").String()
@@ -135,15 +237,16 @@ func (t *llmTool) Call(ctx context.Context, args string) (string, error) {
 	systemPrompt := gjson.Get(args, "system_prompt").String()
 	// process file paths
 	imagePaths := gjson.Get(args, "image_paths").Array()
+	imageURLs := gjson.Get(args, "image_urls").Array()
 	pdfPaths := gjson.Get(args, "pdf_paths").Array()
 	// check if claude model is used with images or PDFs
 	isClaudeModel := strings.Contains(model, "claude")
-	if isClaudeModel && (len(imagePaths) > 0 || len(pdfPaths) > 0) {
+	if isClaudeModel && (len(imagePaths) > 0 || len(imageURLs) > 0 || len(pdfPaths) > 0) {
 		t.logger.Errorf("claude models do not support images or PDFs")
 		return llmToolResult{Error: "claude models do not support images or PDFs"}.result()
 	}
 	// build content parts
-	t.logger.Debugf("calling LLM with model %s, user prompt length %d, %d images and %d PDFs", model, len(userPrompt), len(imagePaths), len(pdfPaths))
+	t.logger.Debugf("calling LLM with model %s, user prompt length %d, %d images and %d PDFs", model, len(userPrompt), len(imagePaths)+len(imageURLs), len(pdfPaths))
 	contentParts := llm.ContentParts{llm.NewTextContentPart(userPrompt)}
 	// add images
 	for _, imagePathValue := range imagePaths {
@@ -158,6 +261,18 @@ func (t *llmTool) Call(ctx context.Context, args string) (string, error) {
 		}
 		contentParts = append(contentParts, imageContentPart)
 	}
+	// add remote images, these are fetched by the provider rather than encoded here
+	for _, imageURLValue := range imageURLs {
+		imageURL := imageURLValue.String()
+		if imageURL == "" {
+			continue
+		}
+		if err := checkImageURL(imageURL); err != nil {
+			t.logger.Errorf("invalid image URL %s: %s", imageURL, err.Error())
+			return llmToolResult{Error: fmt.Sprintf("invalid image URL %s: %s", imageURL, err.Error())}.result()
+		}
+		contentParts = append(contentParts, llm.NewImageContentPart(imageURL))
+	}
 	// add PDFs
 	for _, pdfPathValue := range pdfPaths {
 		pdfPath := pdfPathValue.String()
@@ -172,7 +287,7 @@ func (t *llmTool) Call(ctx context.Context, args string) (string, error) {
 		contentParts = append(contentParts, pdfContentPart)
 	}
 	// create LLM model and messages
-	llmModel := llm.NewOpenRouter(t.logger, t.openRouterToken, model)
+	llmModel := llm.NewOpenRouter(t.logger, t.openRouterToken, modelName, llm.WithOpenRouterHTTPTimeout(t.httpTimeout))
 	messages := []llm.Message{}
 	if systemPrompt != "" {
 		messages = append(messages, llm.Message{
@@ -185,12 +300,9 @@ func (t *llmTool) Call(ctx context.Context, args string) (string, error) {
 		Content: contentParts,
 	})
 	// call LLM
-	events := llmModel.Stream(ctx, messages,
-		llm.WithMaxTokens(16384),
-		llm.WithMaxTurns(1),
-		llm.WithTemperature(0.7),
-	)
-	responseMessages, _, err := llm.Rollup(events)
+	events := llmModel.Stream(ctx, messages, streamOptions...)
+	progress := newGenerationProgress(ctx, t.logger, "llm", t.progress)
+	responseMessages, _, err := llm.RollupWithProgress(events, progress.track)
 	if err != nil {
 		t.logger.Errorf("LLM call failed: %s", err.Error())
 		return llmToolResult{Error: fmt.Sprintf("LLM call failed: %s", err.Error())}.result()
@@ -205,9 +317,39 @@ func (t *llmTool) Call(ctx context.Context, args string) (string, error) {
 	}
 	answer := responseMessages[0].Content.Text()
 	t.logger.Debugf("LLM call completed successfully, response length: %d", len(answer))
+	// the answer ends up in the caller's context, so a verbose model is cut short like the task tool's report
+	if len(answer) > t.maxAnswerLength {
+		cut := t.maxAnswerLength
+		for cut > 0 && !utf8.RuneStart(answer[cut]) {
+			cut--
+		}
+		answer = answer[:cut] + "... (truncated)"
+	}
 	return llmToolResult{Answer: answer}.result()
 }
 
+func (t *llmTool) listModels() []string {
+	models := make([]string, 0, len(t.availableModels))
+	for model := range t.availableModels {
+		models = append(models, model)
+	}
+	slices.Sort(models)
+	return models
+}
+
+func getLLMToolReasoningEffort(effort string) (llm.StreamOption, error) {
+	switch effort {
+	case "low":
+		return llm.WithReasoningEffortLow(), nil
+	case "medium":
+		return llm.WithReasoningEffortMedium(), nil
+	case "high":
+		return llm.WithReasoningEffortHigh(), nil
+	default:
+		return nil, fmt.Errorf("reasoning_effort %q is not valid, must be one of: low, medium, high", effort)
+	}
+}
+
 func (t *llmTool) loadImageFile(imagePath string) (llm.ImageContentPart, error) {
 	absPath, err := validatePath(imagePath)
 	if err != nil {
@@ -224,8 +366,18 @@ func (t *llmTool) loadImageFile(imagePath string) (llm.ImageContentPart, error)
 	if err != nil {
 		return llm.ImageContentPart{}, fmt.Errorf("failed to read image file: %w", err)
 	}
-	ext := strings.ToLower(filepath.Ext(absPath))
-	resizedData, mediaType, err := resizeImage(imageData, ext, llmToolMaxImageSize)
+	return newImageContentPart(imageData, t.maxImageSize, t.imageQuality)
+}
+
+func NewImageContentPart(imageData []byte) (llm.ImageContentPart, error) {
+	return newImageContentPart(imageData, llmToolMaxImageSize, llmToolImageQuality)
+}
+
+func newImageContentPart(imageData []byte, maxSize, quality int) (llm.ImageContentPart, error) {
+	if len(imageData) > llmToolMaxFileSize {
+		return llm.ImageContentPart{}, fmt.Errorf("image size exceeds limit of %d bytes", llmToolMaxFileSize)
+	}
+	resizedData, mediaType, err := resizeImage(imageData, maxSize, quality)
 	if err != nil {
 		return llm.ImageContentPart{}, fmt.Errorf("failed to process image: %w", err)
 	}
@@ -233,6 +385,28 @@ func (t *llmTool) loadImageFile(imagePath string) (llm.ImageContentPart, error)
 	return llm.NewImageContentPart(fmt.Sprintf("data:%s;base64,%s", mediaType, base64Data)), nil
 }
 
+func checkImageURL(imageURL string) error {
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q, must be http or https", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("URL has no host")
+	}
+	// the provider fetches the image, so only obviously internal hosts are rejected without resolving them
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal") {
+		return fmt.Errorf("host %s is not allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && isWebFetchBlockedIP(ip) {
+		return fmt.Errorf("address %s is not allowed", ip.String())
+	}
+	return nil
+}
+
 func (t *llmTool) loadPDFFile(pdfPath string) (llm.FileContentPart, error) {
 	absPath, err := validatePath(pdfPath)
 	if err != nil {
@@ -262,7 +436,7 @@ func (t *llmTool) loadPDFFile(pdfPath string) (llm.FileContentPart, error) {
 	return llm.NewFileContentPart(fileName, fmt.Sprintf("data:%s;base64,%s", mediaType, base64Data)), nil
 }
 
-func resizeImage(imageData []byte, ext string, maxSize int) ([]byte, string, error) {
+func resizeImage(imageData []byte, maxSize, quality int) ([]byte, string, error) {
 	img, format, err := image.Decode(bytes.NewReader(imageData))
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to decode image: %w", err)
@@ -270,46 +444,32 @@ func resizeImage(imageData []byte, ext string, maxSize int) ([]byte, string, err
 	bounds := img.Bounds()
 	width, height := bounds.Dx(), bounds.Dy()
 	shortestSide := min(height, width)
-	if shortestSide <= maxSize {
-		var mediaType string
-		switch strings.ToLower(ext) {
-		case ".jpg", ".jpeg":
-			mediaType = "image/jpeg"
-		case ".png":
-			mediaType = "image/png"
-		default:
-			return nil, "", fmt.Errorf("unsupported image format: %s", ext)
-		}
-		return imageData, mediaType, nil
+	if shortestSide <= maxSize && (format == "jpeg" || format == "png") {
+		return imageData, "image/" + format, nil
 	}
-	var newWidth, newHeight int
-	if width < height {
-		newWidth = maxSize
-		newHeight = (height * maxSize) / width
-	} else {
-		newHeight = maxSize
-		newWidth = (width * maxSize) / height
-	}
-	resized := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
-	for y := range newHeight {
-		for x := range newWidth {
-			srcX := (x * width) / newWidth
-			srcY := (y * height) / newHeight
-			resized.Set(x, y, img.At(srcX, srcY))
+	newWidth, newHeight := width, height
+	if shortestSide > maxSize {
+		if width < height {
+			newWidth = maxSize
+			newHeight = (height * maxSize) / width
+		} else {
+			newHeight = maxSize
+			newWidth = (width * maxSize) / height
 		}
 	}
+	// gifs and webps are re-encoded even when small, not every provider accepts them
+	resized := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	draw.BiLinear.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
 	var buf bytes.Buffer
 	var mediaType string
 	switch format {
-	case "jpeg":
-		mediaType = "image/jpeg"
-		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 90})
-	case "png":
+	case "png", "gif":
+		// these are likely to be screenshots or have transparency, both of which jpeg handles poorly
 		mediaType = "image/png"
 		err = png.Encode(&buf, resized)
 	default:
 		mediaType = "image/jpeg"
-		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 90})
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: quality})
 	}
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to encode resized image: %w", err)
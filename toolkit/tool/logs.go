@@ -0,0 +1,211 @@
+package tool
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/markusylisiurunen/ikm/internal/logger"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	logsToolDefaultDir     = ".ikm/logs"
+	logsToolDefaultLines   = 100
+	logsToolMaxLines       = 1000
+	logsToolMaxFiles       = 20
+	logsToolMaxLineLength  = 2000
+	logsToolMaxTailedBytes = 4 * 1024 * 1024
+)
+
+// rotated logs keep their original name with a numeric suffix
+var logsToolFileNameRegex = regexp.MustCompile(`^[^/\\]+\.log(\.\d+)?$`)
+
+type logsToolResult_File struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+}
+type logsToolResult struct {
+	Ok        bool                  `json:"ok"`
+	Error     string                `json:"error,omitzero"`
+	Files     []logsToolResult_File `json:"files,omitzero"`
+	File      string                `json:"file,omitzero"`
+	Lines     []string              `json:"lines,omitzero"`
+	Truncated bool                  `json:"truncated,omitzero"`
+}
+
+func (r logsToolResult) result() (string, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result to JSON: %w", err)
+	}
+	return string(b), nil
+}
+
+var _ llm.Tool = (*logsTool)(nil)
+
+type LogsOption func(*logsTool)
+
+type logsTool struct {
+	logger logger.Logger
+	dir    string
+}
+
+func WithLogsDir(dir string) LogsOption {
+	return func(t *logsTool) {
+		if dir != "" {
+			t.dir = dir
+		}
+	}
+}
+
+func NewLogs(opts ...LogsOption) *logsTool {
+	t := &logsTool{
+		logger: logger.NoOp(),
+		dir:    logsToolDefaultDir,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (t *logsTool) SetLogger(logger logger.Logger) *logsTool {
+	t.logger = logger
+	return t
+}
+
+//go:embed logs.md
+var logsToolDescription string
+
+func (t *logsTool) Spec() (string, string, json.RawMessage) {
+	return "logs", strings.TrimSpace(logsToolDescription), json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"file": {
+				"type": "string",
+				"description": "Optional name of the log file to read, as returned when listing the files. If not provided, the log files are listed."
+			},
+			"lines": {
+				"type": "integer",
+				"description": "Optional number of lines to return from the end of the file (default 100, max 1000)."
+			}
+		}
+	}`)
+}
+
+func (t *logsTool) Call(ctx context.Context, args string) (string, error) {
+	if args == "" {
+		args = "{}"
+	}
+	if !gjson.Valid(args) {
+		t.logger.Errorf("logs tool called with invalid JSON arguments")
+		return logsToolResult{Ok: false, Error: "invalid JSON arguments"}.result()
+	}
+	name := strings.TrimSpace(gjson.Get(args, "file").String())
+	if name == "" {
+		files, err := t.listFiles()
+		if err != nil {
+			t.logger.Errorf("logs tool failed to list log files: %s", err.Error())
+			return logsToolResult{Ok: false, Error: err.Error()}.result()
+		}
+		return logsToolResult{Ok: true, Files: files}.result()
+	}
+	// only plain file names are accepted, so nothing outside of the logs folder can be read
+	if !logsToolFileNameRegex.MatchString(name) {
+		t.logger.Errorf("logs tool called with invalid file name: %s", name)
+		return logsToolResult{Ok: false, Error: fmt.Sprintf("invalid log file name %q, list the log files to see the available ones", name)}.result()
+	}
+	count := logsToolDefaultLines
+	if v := gjson.Get(args, "lines"); v.Exists() {
+		count = min(max(int(v.Int()), 1), logsToolMaxLines)
+	}
+	lines, truncated, err := tailLogFile(filepath.Join(t.dir, name), count)
+	if err != nil {
+		t.logger.Errorf("logs tool failed to read %s: %s", name, err.Error())
+		return logsToolResult{Ok: false, Error: err.Error()}.result()
+	}
+	return logsToolResult{Ok: true, File: name, Lines: lines, Truncated: truncated}.result()
+}
+
+func (t *logsTool) listFiles() ([]logsToolResult_File, error) {
+	entries, err := os.ReadDir(t.dir)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no logs found, debug logging is enabled with the -debug flag")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logs folder: %w", err)
+	}
+	var files []logsToolResult_File
+	for _, entry := range entries {
+		if entry.IsDir() || !logsToolFileNameRegex.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, logsToolResult_File{
+			Name:     entry.Name(),
+			Size:     info.Size(),
+			Modified: info.ModTime().Format(time.RFC3339),
+		})
+	}
+	// the most recent logs are the interesting ones, the timestamps share a zone so they sort as strings
+	slices.SortStableFunc(files, func(a, b logsToolResult_File) int { return strings.Compare(b.Modified, a.Modified) })
+	return files[:min(len(files), logsToolMaxFiles)], nil
+}
+
+func tailLogFile(path string, count int) ([]string, bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("log file %s does not exist", filepath.Base(path))
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to stat log file: %w", err)
+	}
+	// only the end of the file is read, the logs can grow to tens of megabytes
+	offset := max(info.Size()-logsToolMaxTailedBytes, 0)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, false, fmt.Errorf("failed to seek log file: %w", err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read log file: %w", err)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if offset > 0 {
+		// the first line is most likely cut in half
+		lines = lines[1:]
+	}
+	truncated := offset > 0 || len(lines) > count
+	lines = lines[max(len(lines)-count, 0):]
+	for i, line := range lines {
+		// the logs are redacted when written, but older logs may predate some of the rules
+		line = string(logger.Redact([]byte(line)))
+		if utf8.RuneCountInString(line) > logsToolMaxLineLength {
+			line = string([]rune(line)[:logsToolMaxLineLength]) + "... (truncated)"
+		}
+		lines[i] = line
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		lines = nil
+	}
+	return lines, truncated, nil
+}
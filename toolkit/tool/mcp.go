@@ -0,0 +1,338 @@
+package tool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markusylisiurunen/ikm/internal/logger"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+)
+
+const (
+	mcpProtocolVersion = "2025-06-18"
+	mcpRequestTimeout  = 30 * time.Second
+	mcpToolCallTimeout = 5 * time.Minute
+)
+
+type mcpToolResult struct {
+	Error   string `json:"error,omitzero"`
+	Content string `json:"content,omitzero"`
+}
+
+func (r mcpToolResult) result() (string, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result to JSON: %w", err)
+	}
+	return string(b), nil
+}
+
+type mcpClient struct {
+	logger  logger.Logger
+	command []string
+
+	mux      sync.Mutex
+	writeMux sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	nextID   int
+	pending  map[int]chan mcp_Response
+	done     chan struct{}
+	err      error
+}
+
+func NewMCPClient(command []string) *mcpClient {
+	return &mcpClient{
+		logger:  logger.NoOp(),
+		command: command,
+		pending: make(map[int]chan mcp_Response),
+		done:    make(chan struct{}),
+	}
+}
+
+func (c *mcpClient) SetLogger(logger logger.Logger) *mcpClient {
+	c.logger = logger
+	return c
+}
+
+func (c *mcpClient) Start(ctx context.Context) error {
+	if len(c.command) == 0 {
+		return errors.New("MCP server command is empty")
+	}
+	c.cmd = exec.Command(c.command[0], c.command[1:]...)
+	stdin, err := c.cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error opening MCP server stdin: %w", err)
+	}
+	stdout, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error opening MCP server stdout: %w", err)
+	}
+	c.stdin = stdin
+	if err := c.cmd.Start(); err != nil {
+		return fmt.Errorf("error starting MCP server: %w", err)
+	}
+	go c.read(c.cmd, stdout)
+	// perform the initialization handshake
+	var initResult json.RawMessage
+	if err := c.request(ctx, "initialize", map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "ikm", "version": "1.0.0"},
+	}, &initResult); err != nil {
+		c.Close() //nolint:errcheck
+		return fmt.Errorf("error initializing MCP server: %w", err)
+	}
+	if err := c.write(mcp_Request{JSONRPC: "2.0", Method: "notifications/initialized"}); err != nil {
+		c.Close() //nolint:errcheck
+		return fmt.Errorf("error initializing MCP server: %w", err)
+	}
+	return nil
+}
+
+func (c *mcpClient) Tools(ctx context.Context) ([]llm.Tool, error) {
+	var tools []llm.Tool
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var result mcp_ToolsListResult
+		if err := c.request(ctx, "tools/list", params, &result); err != nil {
+			return nil, fmt.Errorf("error listing MCP tools: %w", err)
+		}
+		for _, t := range result.Tools {
+			schema := t.InputSchema
+			if len(schema) == 0 {
+				schema = json.RawMessage(`{"type": "object", "properties": {}}`)
+			}
+			tools = append(tools, &mcpTool{client: c, name: t.Name, description: t.Description, schema: schema})
+		}
+		if result.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+func (c *mcpClient) Close() error {
+	c.mux.Lock()
+	cmd := c.cmd
+	c.cmd = nil
+	c.mux.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+	// closing stdin asks the server to exit, it is killed if it does not
+	c.stdin.Close() //nolint:errcheck
+	select {
+	case <-c.done:
+	case <-time.After(2 * time.Second):
+		cmd.Process.Kill() //nolint:errcheck
+		<-c.done
+	}
+	return nil
+}
+
+func (c *mcpClient) read(cmd *exec.Cmd, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg mcp_Response
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			c.logger.Errorf("failed to parse MCP message: %v", err)
+			continue
+		}
+		if msg.Method != "" {
+			// the server may send requests and notifications of its own, only pings are answered
+			if msg.Method == "ping" && msg.ID != nil {
+				c.write(mcp_Request{JSONRPC: "2.0", ID: msg.ID, Result: map[string]any{}}) //nolint:errcheck
+			}
+			continue
+		}
+		if msg.ID == nil {
+			continue
+		}
+		c.mux.Lock()
+		ch, ok := c.pending[*msg.ID]
+		delete(c.pending, *msg.ID)
+		c.mux.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+	// the server exited or closed its output, fail all pending requests
+	err := scanner.Err()
+	if err == nil {
+		err = errors.New("MCP server closed the connection")
+	}
+	c.mux.Lock()
+	c.err = err
+	for id, ch := range c.pending {
+		delete(c.pending, id)
+		close(ch)
+	}
+	c.mux.Unlock()
+	cmd.Wait() //nolint:errcheck
+	close(c.done)
+}
+
+func (c *mcpClient) write(req mcp_Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("error marshalling MCP request: %w", err)
+	}
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	if _, err := c.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing MCP request: %w", err)
+	}
+	return nil
+}
+
+func (c *mcpClient) request(ctx context.Context, method string, params any, result any) error {
+	c.mux.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mux.Unlock()
+		return err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan mcp_Response, 1)
+	c.pending[id] = ch
+	c.mux.Unlock()
+	if err := c.write(mcp_Request{JSONRPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		c.mux.Lock()
+		delete(c.pending, id)
+		c.mux.Unlock()
+		return err
+	}
+	timeout := mcpRequestTimeout
+	if method == "tools/call" {
+		timeout = mcpToolCallTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		c.mux.Lock()
+		delete(c.pending, id)
+		c.mux.Unlock()
+		return ctx.Err()
+	case resp, ok := <-ch:
+		if !ok {
+			c.mux.Lock()
+			err := c.err
+			c.mux.Unlock()
+			return err
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("MCP error (%d): %s", resp.Error.Code, resp.Error.Message)
+		}
+		if result != nil {
+			if err := json.Unmarshal(resp.Result, result); err != nil {
+				return fmt.Errorf("error unmarshalling MCP result: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// mcp tool ----------------------------------------------------------------------------------------
+
+var _ llm.Tool = (*mcpTool)(nil)
+
+type mcpTool struct {
+	client      *mcpClient
+	name        string
+	description string
+	schema      json.RawMessage
+}
+
+func (t *mcpTool) Spec() (string, string, json.RawMessage) {
+	return t.name, strings.TrimSpace(t.description), t.schema
+}
+
+func (t *mcpTool) Call(ctx context.Context, args string) (string, error) {
+	var arguments json.RawMessage
+	if strings.TrimSpace(args) == "" {
+		arguments = json.RawMessage(`{}`)
+	} else if !json.Valid([]byte(args)) {
+		t.client.logger.Errorf("MCP tool %s called with invalid JSON arguments", t.name)
+		return mcpToolResult{Error: "invalid JSON arguments"}.result()
+	} else {
+		arguments = json.RawMessage(args)
+	}
+	var result mcp_ToolsCallResult
+	if err := t.client.request(ctx, "tools/call", map[string]any{"name": t.name, "arguments": arguments}, &result); err != nil {
+		t.client.logger.Errorf("MCP tool %s call failed: %s", t.name, err.Error())
+		return mcpToolResult{Error: fmt.Sprintf("MCP tool call failed: %s", err.Error())}.result()
+	}
+	var content strings.Builder
+	for i, item := range result.Content {
+		if i > 0 {
+			content.WriteString("\n\n")
+		}
+		switch item.Type {
+		case "text":
+			content.WriteString(item.Text)
+		default:
+			content.WriteString(fmt.Sprintf("[%s content omitted]", item.Type))
+		}
+	}
+	if result.IsError {
+		return mcpToolResult{Error: content.String()}.result()
+	}
+	return mcpToolResult{Content: content.String()}.result()
+}
+
+// helper types ------------------------------------------------------------------------------------
+
+type mcp_Request struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      *int   `json:"id,omitempty"`
+	Method  string `json:"method,omitempty"`
+	Params  any    `json:"params,omitempty"`
+	Result  any    `json:"result,omitempty"`
+}
+
+type mcp_Response_Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+type mcp_Response struct {
+	JSONRPC string              `json:"jsonrpc"`
+	ID      *int                `json:"id"`
+	Method  string              `json:"method"`
+	Result  json.RawMessage     `json:"result"`
+	Error   *mcp_Response_Error `json:"error"`
+}
+
+type mcp_ToolsListResult_Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+type mcp_ToolsListResult struct {
+	Tools      []mcp_ToolsListResult_Tool `json:"tools"`
+	NextCursor string                     `json:"nextCursor"`
+}
+
+type mcp_ToolsCallResult_Content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+type mcp_ToolsCallResult struct {
+	Content []mcp_ToolsCallResult_Content `json:"content"`
+	IsError bool                          `json:"isError"`
+}
@@ -0,0 +1,49 @@
+package tool
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/markusylisiurunen/ikm/internal/logger"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+)
+
+const generationProgressLogInterval = 4096
+
+type ProgressFunc func(toolCallID string, generated int)
+
+type generationProgress struct {
+	logger    logger.Logger
+	name      string
+	id        string
+	report    ProgressFunc
+	generated atomic.Int64
+	logged    atomic.Int64
+}
+
+func newGenerationProgress(ctx context.Context, logger logger.Logger, name string, report ProgressFunc) *generationProgress {
+	return &generationProgress{logger: logger, name: name, id: llm.ToolCallID(ctx), report: report}
+}
+
+func (p *generationProgress) track(event llm.Event) {
+	// anything the model produces counts, the sub-generation may be thinking or calling tools for a long time
+	var n int
+	switch e := event.(type) {
+	case *llm.ContentDeltaEvent:
+		n = len(e.Content)
+	case *llm.ThinkingDeltaEvent:
+		n = len(e.Thinking)
+	case *llm.ToolUseEvent:
+		n = len(e.FuncArgs)
+	}
+	if n == 0 {
+		return
+	}
+	generated := p.generated.Add(int64(n))
+	if logged := p.logged.Load(); generated-logged >= generationProgressLogInterval && p.logged.CompareAndSwap(logged, generated) {
+		p.logger.Debugf("%s tool call %s has generated %d characters so far", p.name, p.id, generated)
+	}
+	if p.report != nil && p.id != "" {
+		p.report(p.id, int(generated))
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -23,9 +25,15 @@ const (
 	taskToolMaxUserPrompts  = 3
 )
 
+type taskToolAgentFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
 type taskToolResult struct {
-	Error  string `json:"error,omitzero"`
-	Report string `json:"report,omitzero"`
+	Error           string                 `json:"error,omitzero"`
+	Report          string                 `json:"report,omitzero"`
+	FailedAgents    []taskToolAgentFailure `json:"failed_agents,omitzero"`
+	CancelledAgents []string               `json:"cancelled_agents,omitzero"`
 }
 
 func (r taskToolResult) result() (string, error) {
@@ -44,20 +52,62 @@ type taskTool struct {
 	openRouterToken        string
 	fastButCapableModel    string
 	thoroughButCostlyModel string
+	progress               ProgressFunc
+	record                 EditRecorder
+	approver               llm.ToolApprover
+	bashOptions            []BashOption
+	httpTimeout            time.Duration
+}
+
+type TaskOption func(*taskTool)
+
+func WithTaskProgress(progress ProgressFunc) TaskOption {
+	return func(t *taskTool) {
+		t.progress = progress
+	}
+}
+
+func WithTaskEditRecorder(record EditRecorder) TaskOption {
+	return func(t *taskTool) {
+		t.record = record
+	}
+}
+
+func WithTaskBashOptions(opts ...BashOption) TaskOption {
+	return func(t *taskTool) {
+		t.bashOptions = append(t.bashOptions, opts...)
+	}
+}
+
+func WithTaskToolApprover(approver llm.ToolApprover) TaskOption {
+	return func(t *taskTool) {
+		t.approver = approver
+	}
+}
+
+func WithTaskHTTPTimeout(timeout time.Duration) TaskOption {
+	return func(t *taskTool) {
+		t.httpTimeout = timeout
+	}
 }
 
 func NewTask(
 	exec func(context.Context, string) (int, string, string, error),
 	openRouterToken string,
 	fastButCapableModel, thoroughButCostlyModel string,
+	opts ...TaskOption,
 ) *taskTool {
-	return &taskTool{
+	t := &taskTool{
 		logger:                 logger.NoOp(),
 		exec:                   exec,
 		openRouterToken:        openRouterToken,
 		fastButCapableModel:    fastButCapableModel,
 		thoroughButCostlyModel: thoroughButCostlyModel,
 	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 func (t *taskTool) SetLogger(logger logger.Logger) *taskTool {
@@ -81,6 +131,10 @@ func (t *taskTool) Spec() (string, string, json.RawMessage) {
 				"type": "string",
 				"description": "The task to be performed. Can include variables like {{file_path}} to be replaced per agent"
 			},
+			"context": {
+				"type": "string",
+				"description": "Optional background shared with every agent, e.g. relevant findings and decisions from the current conversation"
+			},
 			"agents": {
 				"type": "array",
 				"items": {
@@ -93,6 +147,13 @@ func (t *taskTool) Spec() (string, string, json.RawMessage) {
 						"variables": {
 							"type": "object",
 							"description": "A map of variable names to values that can be used in the prompt (e.g. 'file_path': '/path/to/file.txt')"
+						},
+						"files": {
+							"type": "array",
+							"items": {
+								"type": "string"
+							},
+							"description": "Optional list of file paths whose content is read and given to the agent up front"
 						}
 					},
 					"required": ["id"]
@@ -114,6 +175,7 @@ func (t *taskTool) Call(ctx context.Context, args string) (string, error) {
 	// parse and validate the arguments
 	effort := gjson.Get(args, "effort").String()
 	prompt := gjson.Get(args, "prompt").String()
+	sharedContext := strings.TrimSpace(gjson.Get(args, "context").String())
 	agentsData := gjson.Get(args, "agents")
 	if prompt == "" {
 		return taskToolResult{Error: "prompt cannot be empty"}.result()
@@ -145,83 +207,169 @@ func (t *taskTool) Call(ctx context.Context, args string) (string, error) {
 		return taskToolResult{Error: fmt.Sprintf("no model configured for effort level '%s'", effort)}.result()
 	}
 	t.logger.Debugf("executing task with effort %q and model %q for %d agents: %s", effort, modelName, len(agents), prompt)
-	// run agents in parallel using errgroup
-	g, gctx := errgroup.WithContext(ctx)
+	// run agents in parallel, a failing or cancelled agent does not discard the work of the others
+	var g errgroup.Group
+	// the progress of all agents is reported as one, they all belong to the same tool call
+	progress := newGenerationProgress(ctx, t.logger, "task", t.progress)
 	results := make([]string, len(agents))
+	errs := make([]error, len(agents))
+	agentIDs := make([]string, len(agents))
 	for i, agentData := range agents {
+		agentIDs[i] = gjson.Get(agentData.Raw, "id").String()
 		g.Go(func() error {
-			agentID := gjson.Get(agentData.Raw, "id").String()
-			if agentID == "" {
-				return fmt.Errorf("agent at index %d missing required 'id' field", i)
-			}
-			// substitute variables in prompt for this agent
-			agentPrompt := prompt
-			if variablesData := gjson.Get(agentData.Raw, "variables"); variablesData.Exists() {
-				variablesData.ForEach(func(key, value gjson.Result) bool {
-					placeholder := fmt.Sprintf("{{%s}}", key.String())
-					agentPrompt = strings.ReplaceAll(agentPrompt, placeholder, value.String())
-					return true
-				})
-			}
-			// check for unsubstituted variables
-			if strings.Contains(agentPrompt, "{{") && strings.Contains(agentPrompt, "}}") {
-				return fmt.Errorf("agent %q has unsubstituted variables in prompt: %s", agentID, agentPrompt)
-			}
-			// execute the agent with the substituted prompt
-			result, err := t.runSingleAgent(gctx, modelName, agentID, agentPrompt)
-			if err != nil {
-				return fmt.Errorf("agent %q failed (effort: %s, model: %s): %w", agentID, effort, modelName, err)
-			}
-			results[i] = result
+			results[i], errs[i] = t.runAgent(ctx, progress, agentData, agentIDs[i], modelName, effort, prompt, sharedContext)
 			return nil
 		})
 	}
-	if err := g.Wait(); err != nil {
-		t.logger.Errorf("task execution failed: %s", err.Error())
-		return taskToolResult{Error: fmt.Sprintf("task execution failed: %s", err.Error())}.result()
-	}
-	// combine results from all agents
+	g.Wait() //nolint:errcheck
+	// combine results from the completed agents and classify the rest
+	var r taskToolResult
 	var combinedReport strings.Builder
-	for i, result := range results {
-		if i > 0 {
+	completed := 0
+	for i, err := range errs {
+		agentID := agentIDs[i]
+		if agentID == "" {
+			agentID = fmt.Sprintf("#%d", i)
+		}
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				r.CancelledAgents = append(r.CancelledAgents, agentID)
+			} else {
+				r.FailedAgents = append(r.FailedAgents, taskToolAgentFailure{ID: agentID, Error: err.Error()})
+			}
+			continue
+		}
+		if completed > 0 {
 			combinedReport.WriteString("\n\n")
 		}
-		agentID := gjson.Get(agents[i].Raw, "id").String()
-		combinedReport.WriteString(fmt.Sprintf("Agent %s:\n%s", agentID, result))
+		combinedReport.WriteString(fmt.Sprintf("Agent %s:\n%s", agentID, results[i]))
+		completed++
 	}
 	report := combinedReport.String()
 	if len(report) > taskToolMaxReportLength {
 		report = report[:taskToolMaxReportLength] + "... (truncated)"
 	}
-	t.logger.Debugf("task completed successfully with %d agent results", len(results))
-	return taskToolResult{Report: report}.result()
+	r.Report = report
+	switch {
+	case completed == 0 && len(r.FailedAgents) == 0:
+		r.Error = "task was cancelled before any agent completed"
+	case completed == 0:
+		r.Error = "all agents failed or were cancelled"
+	case len(r.FailedAgents) > 0 || len(r.CancelledAgents) > 0:
+		r.Error = fmt.Sprintf("only %d of %d agents completed, the report contains their results", completed, len(agents))
+	}
+	if r.Error != "" {
+		t.logger.Errorf("task execution incomplete: %s (%d failed, %d cancelled)", r.Error, len(r.FailedAgents), len(r.CancelledAgents))
+	} else {
+		t.logger.Debugf("task completed successfully with %d agent results", completed)
+	}
+	return r.result()
 }
 
-func (t *taskTool) runSingleAgent(ctx context.Context, modelName, agentID, prompt string) (string, error) {
+func (t *taskTool) runAgent(
+	ctx context.Context, progress *generationProgress, agentData gjson.Result, agentID, modelName, effort, prompt, sharedContext string,
+) (string, error) {
+	if agentID == "" {
+		return "", errors.New("agent is missing the required 'id' field")
+	}
+	// substitute variables in prompt for this agent
+	agentPrompt := prompt
+	if variablesData := gjson.Get(agentData.Raw, "variables"); variablesData.Exists() {
+		variablesData.ForEach(func(key, value gjson.Result) bool {
+			placeholder := fmt.Sprintf("{{%s}}", key.String())
+			agentPrompt = strings.ReplaceAll(agentPrompt, placeholder, value.String())
+			return true
+		})
+	}
+	// check for unsubstituted variables
+	if strings.Contains(agentPrompt, "{{") && strings.Contains(agentPrompt, "}}") {
+		return "", fmt.Errorf("agent %q has unsubstituted variables in prompt: %s", agentID, agentPrompt)
+	}
+	// build the context shared with the agent, including its pre-read files
+	var files []string
+	for _, file := range gjson.Get(agentData.Raw, "files").Array() {
+		files = append(files, file.String())
+	}
+	agentContext, err := t.buildAgentContext(sharedContext, files)
+	if err != nil {
+		return "", fmt.Errorf("agent %q context is invalid: %w", agentID, err)
+	}
+	// execute the agent with the substituted prompt
+	result, err := t.runSingleAgent(ctx, progress, modelName, agentID, agentContext, agentPrompt)
+	if err != nil {
+		return "", fmt.Errorf("agent %q failed (effort: %s, model: %s): %w", agentID, effort, modelName, err)
+	}
+	return result, nil
+}
+
+func (t *taskTool) buildAgentContext(sharedContext string, files []string) (string, error) {
+	var b strings.Builder
+	if sharedContext != "" {
+		b.WriteString("<context>\n" + sharedContext + "\n</context>")
+	}
+	for _, file := range files {
+		absPath, err := validatePath(file)
+		if err != nil {
+			return "", err
+		}
+		binary, err := isBinaryFile(absPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read file %s: %w", file, err)
+		}
+		if binary {
+			return "", fmt.Errorf("file %s appears to be binary", file)
+		}
+		content, err := os.ReadFile(absPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read file %s: %w", file, err)
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString(fmt.Sprintf("<file path=%q>\n%s\n</file>", absPath, strings.TrimRight(string(content), "\n")))
+		// fail early instead of reading the rest of the files
+		if b.Len() > taskToolMaxReportLength {
+			break
+		}
+	}
+	// the context is bounded like the report, which is the other text passed between agents
+	if b.Len() > taskToolMaxReportLength {
+		return "", fmt.Errorf("context and files exceed maximum length of %d characters", taskToolMaxReportLength)
+	}
+	return b.String(), nil
+}
+
+func (t *taskTool) runSingleAgent(ctx context.Context, progress *generationProgress, modelName, agentID, agentContext, prompt string) (string, error) {
 	t.logger.Debugf("starting agent %q with model %q: %s", agentID, modelName, prompt)
 	// initialise the model with the tools
-	model := llm.NewOpenRouter(t.logger, t.openRouterToken, modelName)
-	model.Register(NewBash(t.exec).SetLogger(t.logger))
+	model := llm.NewOpenRouter(t.logger, t.openRouterToken, modelName, llm.WithOpenRouterHTTPTimeout(t.httpTimeout))
+	model.Register(NewBash(t.exec, t.bashOptions...).SetLogger(t.logger))
 	model.Register(NewFSList().SetLogger(t.logger))
 	model.Register(NewFSRead().SetLogger(t.logger))
-	model.Register(NewFSReplace().SetLogger(t.logger))
-	model.Register(NewFSWrite().SetLogger(t.logger))
-	model.Register(NewLLM(t.openRouterToken).SetLogger(t.logger))
+	model.Register(NewFSReplace(WithFSReplaceRecorder(t.record)).SetLogger(t.logger))
+	model.Register(NewFSWrite(WithFSWriteRecorder(t.record)).SetLogger(t.logger))
+	model.Register(NewLLM(t.openRouterToken, WithLLMHTTPTimeout(t.httpTimeout)).SetLogger(t.logger))
 	model.Register(NewThink().SetLogger(t.logger))
-	// populate the conversation history with the system and initial user messages
-	history := []llm.Message{
-		t.systemMessage(),
-		t.initialUserMessage(prompt),
+	// populate the conversation history with the system, the optional context and initial user messages
+	history := []llm.Message{t.systemMessage()}
+	if agentContext != "" {
+		history = append(history, t.contextUserMessage(agentContext))
 	}
+	history = append(history, t.initialUserMessage(prompt))
 	// start running the agent in a loop
+	streamOptions := []llm.StreamOption{
+		llm.WithMaxTokens(16384),
+		llm.WithMaxTurns(taskToolMaxTurns),
+		llm.WithTemperature(0.7),
+	}
+	// the sub-agents edit files and run commands just like the main agent, so they need the same approval
+	if t.approver != nil {
+		streamOptions = append(streamOptions, llm.WithToolApprover(t.approver))
+	}
 	userPromptCount := 1
 	for userPromptCount <= taskToolMaxUserPrompts {
-		events := model.Stream(ctx, history,
-			llm.WithMaxTokens(16384),
-			llm.WithMaxTurns(taskToolMaxTurns),
-			llm.WithTemperature(0.7),
-		)
-		messages, _, err := llm.Rollup(events)
+		events := model.Stream(ctx, history, streamOptions...)
+		messages, _, err := llm.RollupWithProgress(events, progress.track)
 		if err != nil {
 			return "", fmt.Errorf("agent %q stream failed: %w", agentID, err)
 		}
@@ -267,6 +415,18 @@ func (t *taskTool) systemMessage() llm.Message {
 	}
 }
 
+//go:embed task_user_context.md
+var taskToolUserContext string
+
+func (t *taskTool) contextUserMessage(agentContext string) llm.Message {
+	text := strings.TrimSpace(taskToolUserContext)
+	text = strings.ReplaceAll(text, "{{context}}", agentContext)
+	return llm.Message{
+		Role:    llm.RoleUser,
+		Content: llm.ContentParts{llm.NewTextContentPart(text)},
+	}
+}
+
 //go:embed task_user_initial.md
 var taskToolUserInitial string
 
@@ -0,0 +1,275 @@
+package tool
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/markusylisiurunen/ikm/internal/logger"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	testToolDefaultCommand     = "go test ./..."
+	testToolDefaultTimeout     = 5 * time.Minute
+	testToolMaxFailedTests     = 20
+	testToolMaxFailureLines    = 10
+	testToolMaxBuildErrors     = 20
+	testToolMaxUnparsedOutput  = 4096
+	testToolMaxArgumentsLength = 512
+)
+
+type testToolResult_FailedTest struct {
+	Name    string   `json:"name"`
+	Package string   `json:"package,omitzero"`
+	Output  []string `json:"output,omitzero"`
+}
+type testToolResult struct {
+	Ok             bool                        `json:"ok"`
+	Error          string                      `json:"error,omitzero"`
+	Command        string                      `json:"command,omitzero"`
+	ExitCode       int                         `json:"exit_code"`
+	Passed         int                         `json:"passed"`
+	Failed         int                         `json:"failed"`
+	Skipped        int                         `json:"skipped"`
+	PassedPackages []string                    `json:"passed_packages,omitzero"`
+	FailedPackages []string                    `json:"failed_packages,omitzero"`
+	FailedTests    []testToolResult_FailedTest `json:"failed_tests,omitzero"`
+	BuildErrors    []string                    `json:"build_errors,omitzero"`
+	Output         string                      `json:"output,omitzero"`
+}
+
+func (r testToolResult) result() (string, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result to JSON: %w", err)
+	}
+	return string(b), nil
+}
+
+var _ llm.Tool = (*testTool)(nil)
+
+type TestOption func(*testTool)
+
+type testTool struct {
+	logger  logger.Logger
+	exec    func(context.Context, string) (int, string, string, error)
+	command string
+	timeout time.Duration
+}
+
+func WithTestCommand(command string) TestOption {
+	return func(t *testTool) {
+		if command != "" {
+			t.command = command
+		}
+	}
+}
+
+func WithTestTimeout(timeout time.Duration) TestOption {
+	return func(t *testTool) {
+		if timeout > 0 {
+			t.timeout = timeout
+		}
+	}
+}
+
+func NewTest(exec func(context.Context, string) (int, string, string, error), opts ...TestOption) *testTool {
+	t := &testTool{
+		logger:  logger.NoOp(),
+		exec:    exec,
+		command: testToolDefaultCommand,
+		timeout: testToolDefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (t *testTool) SetLogger(logger logger.Logger) *testTool {
+	t.logger = logger
+	return t
+}
+
+//go:embed test.md
+var testToolDescription string
+
+func (t *testTool) Spec() (string, string, json.RawMessage) {
+	description := strings.TrimSpace(testToolDescription)
+	description += fmt.Sprintf("\n\nThe configured test command is `%s`.", t.command)
+	return "test", description, json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"args": {
+				"type": "string",
+				"description": "Optional extra arguments appended to the test command, e.g. a package path or '-run TestName'"
+			}
+		}
+	}`)
+}
+
+func (t *testTool) Call(ctx context.Context, args string) (string, error) {
+	if !gjson.Valid(args) {
+		t.logger.Errorf("test tool called with invalid JSON arguments")
+		return testToolResult{Ok: false, Error: "invalid JSON arguments"}.result()
+	}
+	extra := strings.TrimSpace(gjson.Get(args, "args").String())
+	if len(extra) > testToolMaxArgumentsLength {
+		t.logger.Errorf("test tool called with arguments exceeding max length: %d", len(extra))
+		return testToolResult{Ok: false, Error: fmt.Sprintf("args exceed maximum length of %d characters", testToolMaxArgumentsLength)}.result()
+	}
+	// the extra arguments must not be able to run anything other than the configured command
+	if strings.ContainsAny(extra, ";&|`$<>\n") {
+		t.logger.Errorf("test tool called with shell operators in arguments: %q", extra)
+		return testToolResult{Ok: false, Error: "args must not contain shell operators"}.result()
+	}
+	cmd := t.command
+	if extra != "" {
+		cmd += " " + extra
+	}
+	execCtx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	exitCode, stdout, stderr, err := t.exec(execCtx, cmd)
+	if ctx.Err() == nil && errors.Is(execCtx.Err(), context.DeadlineExceeded) {
+		t.logger.Errorf("test tool execution of %q timed out after %s", cmd, t.timeout)
+		return testToolResult{Ok: false, Command: cmd, Error: fmt.Sprintf("tests timed out after %ds", int(t.timeout.Seconds()))}.result()
+	}
+	if err != nil {
+		t.logger.Errorf("test tool execution of %q failed: %s", cmd, err.Error())
+		return testToolResult{Ok: false, Command: cmd, Error: err.Error()}.result()
+	}
+	r := parseGoTestOutput(stdout + "\n" + stderr)
+	r.Ok = exitCode == 0
+	r.Command = cmd
+	r.ExitCode = exitCode
+	if !r.Ok && len(r.FailedTests) == 0 && len(r.BuildErrors) == 0 {
+		// the output could not be made sense of, so its tail is returned instead
+		r.Output = truncateHead(strings.TrimSpace(stdout+"\n"+stderr), testToolMaxUnparsedOutput)
+	}
+	t.logger.Debugf("test tool executed %q: %d passed, %d failed, %d skipped", cmd, r.Passed, r.Failed, r.Skipped)
+	return r.result()
+}
+
+var (
+	goTestResultRegexp  = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+)`)
+	goTestRunRegexp     = regexp.MustCompile(`^=== (RUN|PAUSE|CONT|NAME)\s+(\S+)`)
+	goTestPackageRegexp = regexp.MustCompile(`^(ok|FAIL|\?)\s+(\S+)`)
+)
+
+func parseGoTestOutput(output string) testToolResult {
+	var r testToolResult
+	// with -v the output of a test comes before its result line, without it the indented output comes after,
+	// and the package is only known once the package result line is reached
+	var (
+		outputs      = map[string][]string{}
+		running      string
+		pendingTests []int
+		current      = -1
+		inBuildError bool
+	)
+	for line := range strings.SplitSeq(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "# ") {
+			inBuildError = true
+			current = -1
+			continue
+		}
+		if m := goTestResultRegexp.FindStringSubmatch(line); m != nil {
+			inBuildError = false
+			current = -1
+			switch m[1] {
+			case "PASS":
+				r.Passed++
+			case "SKIP":
+				r.Skipped++
+			case "FAIL":
+				r.Failed++
+				if len(r.FailedTests) < testToolMaxFailedTests {
+					r.FailedTests = append(r.FailedTests, testToolResult_FailedTest{Name: m[2], Output: outputs[m[2]]})
+					current = len(r.FailedTests) - 1
+					pendingTests = append(pendingTests, current)
+				}
+			}
+			running = ""
+			continue
+		}
+		if m := goTestRunRegexp.FindStringSubmatch(line); m != nil {
+			running = m[2]
+			current = -1
+			continue
+		}
+		if m := goTestPackageRegexp.FindStringSubmatch(line); m != nil && (m[1] != "FAIL" || strings.Contains(line, "\t")) {
+			inBuildError = false
+			switch m[1] {
+			case "ok":
+				r.PassedPackages = append(r.PassedPackages, m[2])
+			case "FAIL":
+				r.FailedPackages = append(r.FailedPackages, m[2])
+			}
+			for _, idx := range pendingTests {
+				r.FailedTests[idx].Package = m[2]
+			}
+			outputs, running, pendingTests, current = map[string][]string{}, "", nil, -1
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "FAIL" || trimmed == "PASS" {
+			continue
+		}
+		if inBuildError {
+			if len(r.BuildErrors) < testToolMaxBuildErrors {
+				r.BuildErrors = append(r.BuildErrors, trimmed)
+			}
+			continue
+		}
+		if current >= 0 && trimmed != line {
+			r.FailedTests[current].Output = append(r.FailedTests[current].Output, trimmed)
+			continue
+		}
+		current = -1
+		outputs[running] = append(outputs[running], trimmed)
+	}
+	// subtests fail their parents as well, the parents carry no output of their own and are dropped
+	var failedTests []testToolResult_FailedTest
+	for _, test := range r.FailedTests {
+		isParent := false
+		for _, other := range r.FailedTests {
+			if strings.HasPrefix(other.Name, test.Name+"/") && other.Package == test.Package {
+				isParent = true
+				break
+			}
+		}
+		if !isParent || len(test.Output) > 0 {
+			test.Output = firstLines(test.Output, testToolMaxFailureLines)
+			failedTests = append(failedTests, test)
+		}
+	}
+	r.FailedTests = failedTests
+	return r
+}
+
+func firstLines(lines []string, n int) []string {
+	if len(lines) <= n {
+		return lines
+	}
+	return append(lines[:n:n], fmt.Sprintf("... (%d more lines)", len(lines)-n))
+}
+
+func truncateHead(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	start := len(s) - maxBytes
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return "..." + s[start:]
+}
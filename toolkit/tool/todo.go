@@ -196,6 +196,14 @@ func saveTodoList(newTodoList TodoList) {
 	todoList = newTodoList
 }
 
+func GetTodoList() TodoList {
+	return loadTodoList()
+}
+
+func ClearTodoList() {
+	saveTodoList(TodoList{})
+}
+
 func isValidStatus(status string) bool {
 	switch status {
 	case "pending", "in_progress", "completed", "cancelled":
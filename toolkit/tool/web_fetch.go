@@ -0,0 +1,364 @@
+package tool
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/markusylisiurunen/ikm/internal/logger"
+	"github.com/markusylisiurunen/ikm/toolkit/llm"
+	"github.com/tidwall/gjson"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	webFetchToolDefaultTimeout  = 30 * time.Second
+	webFetchToolMaxBodyBytes    = 2 * 1024 * 1024
+	webFetchToolMaxContentBytes = 64 * 1024
+	webFetchToolMaxRedirects    = 5
+)
+
+type webFetchToolResult struct {
+	Ok          bool   `json:"ok"`
+	Error       string `json:"error,omitzero"`
+	URL         string `json:"url,omitzero"`
+	StatusCode  int    `json:"status_code,omitzero"`
+	ContentType string `json:"content_type,omitzero"`
+	Content     string `json:"content,omitzero"`
+	Truncated   bool   `json:"truncated,omitzero"`
+}
+
+func (r webFetchToolResult) result() (string, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result to JSON: %w", err)
+	}
+	return string(b), nil
+}
+
+var _ llm.Tool = (*webFetchTool)(nil)
+
+type WebFetchOption func(*webFetchTool)
+
+type webFetchTool struct {
+	logger       logger.Logger
+	timeout      time.Duration
+	allowedHosts []string
+	deniedHosts  []string
+}
+
+func WithWebFetchTimeout(timeout time.Duration) WebFetchOption {
+	return func(t *webFetchTool) {
+		if timeout > 0 {
+			t.timeout = timeout
+		}
+	}
+}
+
+func WithWebFetchAllowedHosts(hosts []string) WebFetchOption {
+	return func(t *webFetchTool) {
+		t.allowedHosts = hosts
+	}
+}
+
+func WithWebFetchDeniedHosts(hosts []string) WebFetchOption {
+	return func(t *webFetchTool) {
+		t.deniedHosts = hosts
+	}
+}
+
+func NewWebFetch(opts ...WebFetchOption) *webFetchTool {
+	t := &webFetchTool{
+		logger:  logger.NoOp(),
+		timeout: webFetchToolDefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (t *webFetchTool) SetLogger(logger logger.Logger) *webFetchTool {
+	t.logger = logger
+	return t
+}
+
+//go:embed web_fetch.md
+var webFetchToolDescription string
+
+func (t *webFetchTool) Spec() (string, string, json.RawMessage) {
+	return "web_fetch", strings.TrimSpace(webFetchToolDescription), json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"url": {
+				"type": "string",
+				"description": "The absolute http(s) URL to fetch"
+			}
+		},
+		"required": ["url"]
+	}`)
+}
+
+func (t *webFetchTool) Call(ctx context.Context, args string) (string, error) {
+	if !gjson.Valid(args) {
+		t.logger.Errorf("web_fetch tool called with invalid JSON arguments")
+		return webFetchToolResult{Ok: false, Error: "invalid JSON arguments"}.result()
+	}
+	rawURL := strings.TrimSpace(gjson.Get(args, "url").String())
+	if rawURL == "" {
+		t.logger.Errorf("web_fetch tool called without url")
+		return webFetchToolResult{Ok: false, Error: "url is required"}.result()
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.logger.Errorf("web_fetch tool called with invalid url %q: %s", rawURL, err.Error())
+		return webFetchToolResult{Ok: false, Error: fmt.Sprintf("invalid url: %s", err.Error())}.result()
+	}
+	if err := t.checkURL(u); err != nil {
+		t.logger.Errorf("web_fetch tool refused to fetch %q: %s", rawURL, err.Error())
+		return webFetchToolResult{Ok: false, Error: err.Error()}.result()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return webFetchToolResult{Ok: false, Error: fmt.Sprintf("error creating request: %s", err.Error())}.result()
+	}
+	req.Header.Set("Accept", "text/html, text/plain, text/markdown, application/json, */*;q=0.5")
+	req.Header.Set("User-Agent", "ikm")
+	resp, err := t.client().Do(req)
+	if err != nil {
+		t.logger.Errorf("web_fetch tool request to %q failed: %s", rawURL, err.Error())
+		return webFetchToolResult{Ok: false, Error: fmt.Sprintf("request failed: %s", err.Error())}.result()
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	body, err := io.ReadAll(io.LimitReader(resp.Body, webFetchToolMaxBodyBytes+1))
+	if err != nil {
+		t.logger.Errorf("web_fetch tool failed to read the response of %q: %s", rawURL, err.Error())
+		return webFetchToolResult{Ok: false, Error: fmt.Sprintf("error reading response: %s", err.Error())}.result()
+	}
+	truncated := len(body) > webFetchToolMaxBodyBytes
+	if truncated {
+		body = body[:webFetchToolMaxBodyBytes]
+	}
+	r := webFetchToolResult{
+		Ok:          resp.StatusCode >= 200 && resp.StatusCode < 300,
+		URL:         resp.Request.URL.String(),
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if r.ContentType == "" {
+		r.ContentType = http.DetectContentType(body)
+	}
+	if !r.Ok {
+		r.Error = fmt.Sprintf("non-ok status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.ContentType)
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		r.Content = htmlToText(string(body))
+	case isTextMediaType(mediaType):
+		r.Content = string(body)
+	default:
+		t.logger.Errorf("web_fetch tool got unsupported content type %q from %q", r.ContentType, rawURL)
+		r.Ok = false
+		r.Error = fmt.Sprintf("unsupported content type %q", r.ContentType)
+		return r.result()
+	}
+	if len(r.Content) > webFetchToolMaxContentBytes {
+		end := webFetchToolMaxContentBytes
+		for end > 0 && !utf8.RuneStart(r.Content[end]) {
+			end--
+		}
+		r.Content = r.Content[:end]
+		truncated = true
+	}
+	r.Truncated = truncated
+	t.logger.Debugf("web_fetch tool fetched %q (status %d, %d bytes)", rawURL, resp.StatusCode, len(body))
+	return r.result()
+}
+
+func (t *webFetchTool) client() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: webFetchDialControl}
+	return &http.Client{
+		Timeout: t.timeout,
+		// a proxy would hide the real destination from the dial check, so one is never used
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= webFetchToolMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", webFetchToolMaxRedirects)
+			}
+			return t.checkURL(req.URL)
+		},
+	}
+}
+
+func (t *webFetchTool) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported url scheme %q, must be http or https", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return errors.New("url has no host")
+	}
+	for _, denied := range t.deniedHosts {
+		if matchWebFetchHost(host, denied) {
+			return fmt.Errorf("host %s is denied", host)
+		}
+	}
+	if len(t.allowedHosts) == 0 {
+		return nil
+	}
+	for _, allowed := range t.allowedHosts {
+		if matchWebFetchHost(host, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s is not allowed", host)
+}
+
+func matchWebFetchHost(host, pattern string) bool {
+	pattern = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(pattern), "*."))
+	if pattern == "" {
+		return false
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+func webFetchDialControl(network, address string, _ syscall.RawConn) error {
+	// the check runs on the resolved address, so it also covers hostnames resolving to internal addresses
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %s", address)
+	}
+	if isWebFetchBlockedIP(ip) {
+		return fmt.Errorf("connections to %s are not allowed", ip.String())
+	}
+	return nil
+}
+
+var webFetchCarrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isWebFetchBlockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		webFetchCarrierGradeNAT.Contains(ip)
+}
+
+func isTextMediaType(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-yaml", "application/yaml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+func htmlToText(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	skipDepth, preDepth := 0, 0
+	pendingSpace := false
+	writeBreak := func(br string) {
+		b.WriteString(br)
+		pendingSpace = false
+	}
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return cleanHTMLText(b.String())
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			tag := atom.Lookup(name)
+			switch tag {
+			case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg, atom.Head:
+				if tt == html.StartTagToken {
+					skipDepth++
+				} else if tt == html.EndTagToken && skipDepth > 0 {
+					skipDepth--
+				}
+			case atom.Pre:
+				if tt == html.StartTagToken {
+					preDepth++
+				} else if tt == html.EndTagToken && preDepth > 0 {
+					preDepth--
+				}
+				writeBreak("\n\n")
+			case atom.Br:
+				writeBreak("\n")
+			case atom.Li:
+				if tt == html.StartTagToken {
+					writeBreak("\n- ")
+				}
+			case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Nav, atom.Main,
+				atom.Aside, atom.Ul, atom.Ol, atom.Table, atom.Tr, atom.Blockquote, atom.Hr,
+				atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+				writeBreak("\n\n")
+			case atom.Td, atom.Th:
+				pendingSpace = true
+			}
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			text := string(z.Text())
+			if preDepth > 0 {
+				writeBreak(text)
+				continue
+			}
+			words := strings.Fields(text)
+			if len(words) == 0 {
+				pendingSpace = pendingSpace || text != ""
+				continue
+			}
+			// whitespace between inline elements collapses into a single space
+			atLineStart := b.Len() == 0 || strings.HasSuffix(b.String(), "\n")
+			if (pendingSpace || strings.IndexAny(text[:1], " \t\r\n") == 0) && !atLineStart {
+				b.WriteString(" ")
+			}
+			b.WriteString(strings.Join(words, " "))
+			pendingSpace = strings.IndexAny(text[len(text)-1:], " \t\r\n") == 0
+		}
+	}
+}
+
+func cleanHTMLText(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.TrimSpace(line) == "" {
+			blank = len(out) > 0
+			continue
+		}
+		if blank {
+			out = append(out, "")
+			blank = false
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}